@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bgp-hijack-detection/detector"
+)
+
+/*
+Alerts (of the watchlists) are delivered to the sinks configured in the file of -alertsinks (JSON):
+	{"sinks": [
+		{"type": "webhook", "url": "https://hooks.slack.com/services/...", "minSeverity": "warning"},
+		{"type": "syslog", "network": "udp", "address": "localhost:514", "facility": 3},
+		{"type": "smtp", "address": "localhost:25", "from": "hijacks@example.net", "to": ["noc@example.net"], "tags": ["noc"]},
+		{"type": "exec", "command": "/usr/local/bin/page", "args": ["--team", "noc"]}
+	]}
+Every sink can be restricted to alerts with at least minSeverity (info, warning or critical) and to alerts with one of the
+tags. Each sink has its own queue and goroutine, so a slow sink neither delays the detection nor the other sinks. A failed
+delivery is retried (retries, default 5) with exponential backoff (backoff in seconds, default 1, at most 60).
+  - webhook: POST of a JSON body with "text" (understood by Slack and Mattermost) and the complete "alert"
+  - syslog:  RFC 5424 messages over UDP (one per datagram) or TCP (with octet counting framing, RFC 6587)
+  - smtp:    an email per alert, with PLAIN authentication if a username is given
+  - exec:    runs the command with the alert as JSON on stdin. Exit code 0 counts as delivered
+*/
+
+const alertQueueLength = 1000
+const maxAlertBackoff = time.Minute
+
+type alertSinkConfig struct {
+	Type        string   `json:"type"`
+	MinSeverity string   `json:"minSeverity"`
+	Tags        []string `json:"tags"`
+	Retries     *int     `json:"retries"`
+	Backoff     float64  `json:"backoff"`
+
+	URL string `json:"url"` //webhook
+
+	Network  string `json:"network"` //syslog: udp or tcp
+	Address  string `json:"address"` //syslog and smtp: host:port
+	Facility *int   `json:"facility"`
+
+	From     string   `json:"from"` //smtp
+	To       []string `json:"to"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+
+	Command string   `json:"command"` //exec
+	Args    []string `json:"args"`
+}
+
+type alertSinksConfig struct {
+	Sinks []alertSinkConfig `json:"sinks"`
+}
+
+type alertSink struct {
+	name    string //type and index in the file, for the output and the metrics
+	config  alertSinkConfig
+	send    func(detector.AlertJSON) error
+	queue   chan detector.AlertJSON
+	retries int
+	backoff time.Duration
+}
+
+var alertSinks []*alertSink
+var alertSinksWaitGroup sync.WaitGroup
+var alertSinksLock sync.Mutex //protects the queues against being closed while alerts are dispatched
+var alertSinksClosed bool
+
+var severityRank = map[string]int{detector.SeverityInfo: 0, detector.SeverityWarning: 1, detector.SeverityCritical: 2}
+
+// readAlertSinks reads the configuration and starts one goroutine per sink
+func readAlertSinks(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	var config alertSinksConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	for i, c := range config.Sinks {
+		s, err := newAlertSink(c)
+		if err != nil {
+			return fmt.Errorf("sink %d (%s): %v", i+1, c.Type, err)
+		}
+		s.name = c.Type + "-" + strconv.Itoa(i+1)
+		alertSinks = append(alertSinks, s)
+	}
+	for _, s := range alertSinks {
+		alertSinksWaitGroup.Add(1)
+		go s.run()
+		fmt.Println(Teal("Alert sink ", s.name, " started"))
+	}
+	return nil
+}
+
+func newAlertSink(c alertSinkConfig) (*alertSink, error) {
+	s := &alertSink{config: c, queue: make(chan detector.AlertJSON, alertQueueLength), retries: 5, backoff: time.Second}
+	if c.Retries != nil {
+		s.retries = *c.Retries
+	}
+	if c.Backoff > 0 {
+		s.backoff = time.Duration(c.Backoff * float64(time.Second))
+	}
+	if _, ok := severityRank[c.MinSeverity]; c.MinSeverity != "" && !ok {
+		return nil, fmt.Errorf("unknown severity %q", c.MinSeverity)
+	}
+	switch c.Type {
+	case "webhook":
+		if c.URL == "" {
+			return nil, fmt.Errorf("url missing")
+		}
+		s.send = s.sendWebhook
+	case "syslog":
+		if c.Network != "udp" && c.Network != "tcp" {
+			return nil, fmt.Errorf("network must be udp or tcp")
+		}
+		if c.Address == "" {
+			return nil, fmt.Errorf("address missing")
+		}
+		s.send = s.sendSyslog
+	case "smtp":
+		if c.Address == "" || c.From == "" || len(c.To) == 0 {
+			return nil, fmt.Errorf("address, from and to are required")
+		}
+		s.send = s.sendEmail
+	case "exec":
+		if c.Command == "" {
+			return nil, fmt.Errorf("command missing")
+		}
+		s.send = s.runCommand
+	default:
+		return nil, fmt.Errorf("unknown type")
+	}
+	return s, nil
+}
+
+// accepts applies the severity and tag filters of the sink
+func (s *alertSink) accepts(a detector.AlertJSON) bool {
+	if severityRank[a.Severity] < severityRank[s.config.MinSeverity] {
+		return false
+	}
+	if len(s.config.Tags) == 0 {
+		return true
+	}
+	for _, tag := range s.config.Tags {
+		if tag == a.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *alertSink) run() {
+	defer alertSinksWaitGroup.Done()
+	for a := range s.queue {
+		backoff := s.backoff
+		for attempt := 0; ; attempt++ {
+			err := s.send(a)
+			if err == nil {
+				countAlertDelivery(s.name, "delivered")
+				break
+			}
+			if attempt >= s.retries {
+				countAlertDelivery(s.name, "failed")
+				fmt.Println(Red("Alert sink ", s.name, " failed ", attempt+1, " times, dropping alert: ", err))
+				break
+			}
+			countAlertDelivery(s.name, "retried")
+			time.Sleep(backoff)
+			backoff = backoff * 2
+			if backoff > maxAlertBackoff {
+				backoff = maxAlertBackoff
+			}
+		}
+	}
+}
+
+// dispatchAlert queues an alert for all sinks accepting it. If the queue of a sink is full, the alert is dropped for this sink
+func dispatchAlert(a detector.AlertJSON) {
+	alertSinksLock.Lock()
+	defer alertSinksLock.Unlock()
+	if alertSinksClosed {
+		return
+	}
+	for _, s := range alertSinks {
+		if !s.accepts(a) {
+			continue
+		}
+		select {
+		case s.queue <- a:
+		default:
+			countAlertDelivery(s.name, "dropped")
+			fmt.Println(Red("Queue of alert sink ", s.name, " is full, dropping alert"))
+		}
+	}
+}
+
+// closeAlertSinks delivers the queued alerts, but waits at most the timeout for it
+func closeAlertSinks(timeout time.Duration) {
+	alertSinksLock.Lock()
+	alertSinksClosed = true
+	for _, s := range alertSinks {
+		close(s.queue)
+	}
+	alertSinksLock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		alertSinksWaitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		fmt.Println(Red("Not all alerts could be delivered before stopping"))
+	}
+}
+
+// alertSummary is the alert in one line
+func alertSummary(a detector.AlertJSON) string {
+	result := "[" + a.Severity + "] " + a.Category + " " + a.Reason + ": " + a.Announcement.Subnet +
+		" origin AS" + strconv.Itoa(a.Announcement.OriginAS)
+	if a.Conflicting != nil {
+		result = result + " <-> " + a.Conflicting.Subnet + " origin AS" + strconv.Itoa(a.Conflicting.OriginAS)
+	}
+	if a.WatchedPrefix != "" {
+		result = result + " (watched prefix " + a.WatchedPrefix + ")"
+	}
+	if a.WatchedASN != 0 {
+		result = result + " (watched AS" + strconv.Itoa(a.WatchedASN) + ")"
+	}
+	if a.Tag != "" {
+		result = result + " tag: " + a.Tag
+	}
+	return result
+}
+
+func (s *alertSink) sendWebhook(a detector.AlertJSON) error {
+	body, err := json.Marshal(struct {
+		Text  string             `json:"text"`
+		Alert detector.AlertJSON `json:"alert"`
+	}{alertSummary(a), a})
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(s.config.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// syslogMessage formats the alert as RFC 5424 message: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func (s *alertSink) syslogMessage(a detector.AlertJSON) ([]byte, error) {
+	facility := 1 //user-level messages
+	if s.config.Facility != nil {
+		facility = *s.config.Facility
+	}
+	severity := 6 //informational
+	switch a.Severity {
+	case detector.SeverityWarning:
+		severity = 4
+	case detector.SeverityCritical:
+		severity = 2
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s %s hijackdetector %d %s - %s %s", facility*8+severity,
+		time.Now().UTC().Format("2006-01-02T15:04:05.000000Z07:00"), hostname, os.Getpid(), a.Category, alertSummary(a), data)), nil
+}
+
+func (s *alertSink) sendSyslog(a detector.AlertJSON) error {
+	message, err := s.syslogMessage(a)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout(s.config.Network, s.config.Address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if s.config.Network == "tcp" {
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+	_, err = conn.Write(message)
+	return err
+}
+
+func (s *alertSink) sendEmail(a detector.AlertJSON) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	summary := alertSummary(a)
+	message := "From: " + s.config.From + "\r\n" +
+		"To: " + strings.Join(s.config.To, ", ") + "\r\n" +
+		"Subject: BGP hijack detection: " + summary + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		summary + "\r\n\r\n" + strings.ReplaceAll(string(data), "\n", "\r\n") + "\r\n"
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		host, _, _ := net.SplitHostPort(s.config.Address)
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, host)
+	}
+	return smtp.SendMail(s.config.Address, auth, s.config.From, s.config.To, []byte(message))
+}
+
+func (s *alertSink) runCommand(a detector.AlertJSON) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.config.Command, s.config.Args...)
+	cmd.Stdin = bytes.NewReader(append(data, '\n'))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bgp-hijack-detection/detector"
+)
+
+func testAlert(severity string, tag string) detector.AlertJSON {
+	return detector.AlertJSON{
+		Category:      "watchlist",
+		Reason:        "unexpected-origin",
+		Severity:      severity,
+		Tag:           tag,
+		WatchedPrefix: "192.0.2.0/24",
+		Expected:      []int{64500},
+		Announcement:  detector.MessageJSON{MessageType: "announcement", Subnet: "192.0.2.0/24", OriginAS: 64501, Timestamp: 1600000000},
+	}
+}
+
+func newTestSink(t *testing.T, c alertSinkConfig) *alertSink {
+	s, err := newAlertSink(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.name = c.Type + "-test"
+	return s
+}
+
+// deliver runs the sink until all given alerts are delivered (or dropped) and returns how long it took
+func deliver(s *alertSink, alerts ...detector.AlertJSON) time.Duration {
+	start := time.Now()
+	for _, a := range alerts {
+		s.queue <- a
+	}
+	close(s.queue)
+	alertSinksWaitGroup.Add(1)
+	s.run()
+	return time.Since(start)
+}
+
+func TestAlertSinkAccepts(t *testing.T) {
+	tests := []struct {
+		minSeverity string
+		tags        []string
+		severity    string
+		tag         string
+		want        bool
+	}{
+		{"", nil, detector.SeverityInfo, "", true},
+		{detector.SeverityWarning, nil, detector.SeverityInfo, "", false},
+		{detector.SeverityWarning, nil, detector.SeverityWarning, "", true},
+		{detector.SeverityWarning, nil, detector.SeverityCritical, "", true},
+		{detector.SeverityCritical, nil, detector.SeverityWarning, "", false},
+		{"", []string{"noc", "security"}, detector.SeverityInfo, "security", true},
+		{"", []string{"noc"}, detector.SeverityCritical, "security", false},
+		{"", []string{"noc"}, detector.SeverityCritical, "", false},
+		{detector.SeverityCritical, []string{"noc"}, detector.SeverityWarning, "noc", false},
+		{detector.SeverityWarning, []string{"noc"}, detector.SeverityCritical, "noc", true},
+	}
+	for _, tt := range tests {
+		s := newTestSink(t, alertSinkConfig{Type: "exec", Command: "true", MinSeverity: tt.minSeverity, Tags: tt.tags})
+		if got := s.accepts(testAlert(tt.severity, tt.tag)); got != tt.want {
+			t.Errorf("sink (min severity %q, tags %v) accepts %s alert with tag %q: %v, want %v", tt.minSeverity, tt.tags,
+				tt.severity, tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestNewAlertSinkValidates(t *testing.T) {
+	for _, c := range []alertSinkConfig{
+		{Type: "webhook"},
+		{Type: "syslog", Network: "unix", Address: "/dev/log"},
+		{Type: "syslog", Network: "udp"},
+		{Type: "smtp", Address: "localhost:25", From: "hijacks@example.net"},
+		{Type: "exec"},
+		{Type: "exec", Command: "true", MinSeverity: "fatal"},
+		{Type: "pager"},
+	} {
+		if _, err := newAlertSink(c); err == nil {
+			t.Errorf("invalid sink %+v was accepted", c)
+		}
+	}
+}
+
+func TestWebhookSinkRetries(t *testing.T) {
+	var lock sync.Mutex
+	var requests int
+	var bodies []map[string]json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		var body map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("bad request: %v", err)
+		}
+		bodies = append(bodies, body)
+		if requests <= 2 { //the first two attempts fail
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	backoff := 0.02
+	retries := 3
+	s := newTestSink(t, alertSinkConfig{Type: "webhook", URL: server.URL, Backoff: backoff, Retries: &retries})
+	a := testAlert(detector.SeverityCritical, "noc")
+	took := deliver(s, a)
+
+	if requests != 3 {
+		t.Fatalf("%d requests, want 3", requests)
+	}
+	if took < 3*time.Duration(backoff*float64(time.Second)) { //backoff, then twice the backoff
+		t.Errorf("the retries took %v, the backoff was not doubled", took)
+	}
+	var text string
+	var alert detector.AlertJSON
+	json.Unmarshal(bodies[2]["text"], &text)
+	json.Unmarshal(bodies[2]["alert"], &alert)
+	if text != alertSummary(a) || !strings.Contains(text, "192.0.2.0/24") {
+		t.Errorf("text %q, want %q", text, alertSummary(a))
+	}
+	if alert.Reason != a.Reason || alert.Announcement.OriginAS != 64501 || alert.Tag != "noc" {
+		t.Errorf("alert %+v, want %+v", alert, a)
+	}
+
+	//without any successful attempt the alert is dropped after the retries
+	requests = 0
+	retries = 1
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		lock.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	deliver(newTestSink(t, alertSinkConfig{Type: "webhook", URL: failing.URL, Backoff: backoff, Retries: &retries}), a)
+	if requests != 2 {
+		t.Errorf("%d requests to the failing webhook, want 2", requests)
+	}
+}
+
+// checkSyslogMessage checks the fields of an RFC 5424 message: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func checkSyslogMessage(t *testing.T, message string, wantPRI int, a detector.AlertJSON) {
+	fields := strings.SplitN(message, " ", 8)
+	if len(fields) != 8 {
+		t.Fatalf("message %q has not all fields", message)
+	}
+	if fields[0] != "<"+strconv.Itoa(wantPRI)+">1" {
+		t.Errorf("PRI and version %q, want <%d>1", fields[0], wantPRI)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fields[1]); err != nil {
+		t.Errorf("timestamp: %v", err)
+	}
+	if fields[3] != "hijackdetector" || fields[5] != a.Category || fields[6] != "-" {
+		t.Errorf("APP-NAME, MSGID or SD wrong in %q", message)
+	}
+	if !strings.HasPrefix(fields[7], alertSummary(a)) {
+		t.Errorf("MSG %q does not start with the summary", fields[7])
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	facility := 3
+	s := newTestSink(t, alertSinkConfig{Type: "syslog", Network: "udp", Address: conn.LocalAddr().String(), Facility: &facility})
+
+	for _, tt := range []struct {
+		severity string
+		pri      int
+	}{{detector.SeverityCritical, 3*8 + 2}, {detector.SeverityWarning, 3*8 + 4}, {detector.SeverityInfo, 3*8 + 6}} {
+		a := testAlert(tt.severity, "")
+		if err := s.sendSyslog(a); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSyslogMessage(t, string(buf[:n]), tt.pri, a)
+	}
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []byte, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := ioutil.ReadAll(conn)
+			conn.Close()
+			received <- data
+		}
+	}()
+	s := newTestSink(t, alertSinkConfig{Type: "syslog", Network: "tcp", Address: listener.Addr().String()})
+
+	a := testAlert(detector.SeverityCritical, "noc")
+	if err := s.sendSyslog(a); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	select {
+	case data = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no syslog message received")
+	}
+	//octet counting (RFC 6587): MSG-LEN SP SYSLOG-MSG
+	frame := strings.SplitN(string(data), " ", 2)
+	length, err := strconv.Atoi(frame[0])
+	if err != nil || len(frame) != 2 || length != len(frame[1]) {
+		t.Fatalf("frame %q: the length does not match the message", data)
+	}
+	checkSyslogMessage(t, frame[1], 1*8+2, a)
+}
+
+// smtpServer is a minimal SMTP server which accepts one mail
+type smtpServer struct {
+	listener   net.Listener
+	from       string
+	recipients []string
+	data       string
+	done       chan struct{}
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: listener, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.recipients = append(s.recipients, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestEmailSink(t *testing.T) {
+	server := newSMTPServer(t)
+	s := newTestSink(t, alertSinkConfig{Type: "smtp", Address: server.listener.Addr().String(), From: "hijacks@example.net",
+		To: []string{"noc@example.net", "security@example.net"}})
+	a := testAlert(detector.SeverityCritical, "noc")
+	if err := s.sendEmail(a); err != nil {
+		t.Fatal(err)
+	}
+	<-server.done
+
+	if server.from != "hijacks@example.net" || len(server.recipients) != 2 || server.recipients[1] != "security@example.net" {
+		t.Errorf("envelope from %s to %v", server.from, server.recipients)
+	}
+	for _, want := range []string{
+		"To: noc@example.net, security@example.net\r\n",
+		"Subject: BGP hijack detection: " + alertSummary(a) + "\r\n",
+		"\"reason\": \"unexpected-origin\"",
+	} {
+		if !strings.Contains(server.data, want) {
+			t.Errorf("mail does not contain %q:\n%s", want, server.data)
+		}
+	}
+}
+
+func TestExecSink(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "alert.json")
+	s := newTestSink(t, alertSinkConfig{Type: "exec", Command: "sh", Args: []string{"-c", "cat > " + output}})
+	a := testAlert(detector.SeverityWarning, "noc")
+	if err := s.runCommand(a); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got detector.AlertJSON
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Reason != a.Reason || got.Severity != a.Severity || got.Announcement.Subnet != a.Announcement.Subnet {
+		t.Errorf("the command read %s", data)
+	}
+
+	failing := newTestSink(t, alertSinkConfig{Type: "exec", Command: "sh", Args: []string{"-c", "cat > /dev/null; echo no pager >&2; exit 3"}})
+	if err := failing.runCommand(a); err == nil || !strings.Contains(err.Error(), "no pager") {
+		t.Errorf("error %v, want the exit status with the output of the command", err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+The input directories may contain the files of one or several route collectors, either flat or in the layout of the archives:
+	Routeviews: route-views.X/bgpdata/YYYY.MM/RIBS/rib.YYYYMMDD.HHMM.bz2 and route-views.X/bgpdata/YYYY.MM/UPDATES/updates.YYYYMMDD.HHMM.bz2
+	RIPE RIS:   rrcXX/YYYY.MM/bview.YYYYMMDD.HHMM.gz and rrcXX/YYYY.MM/updates.YYYYMMDD.HHMM.gz
+The collector of a file is the nearest directory above it which is not part of the archive layout itself.
+*/
+
+var archiveLayoutDirectory = regexp.MustCompile(`^(bgpdata|RIBS|UPDATES|\d{4}\.\d{2})$`)
+var collectorDirectory = regexp.MustCompile(`^(rrc\d+|route-views.*)$`)
+
+// archiveFile is a RIB (rib or bview) or an updates file of one collector
+type archiveFile struct {
+	path      string
+	collector string
+	isRIB     bool
+	time      string //YYYYMMDD.HHMM, so that comparing the strings compares the times
+}
+
+// collectorArchive contains all files of one collector, sorted by time
+type collectorArchive struct {
+	collector string
+	ribs      []archiveFile
+	updates   []archiveFile
+}
+
+// parseArchiveFileName accepts [rib|bview|updates].YYYYMMDD.HHMM with an optional suffix (e.g. .bz2 or .gz)
+func parseArchiveFileName(name string) (isRIB bool, time string, ok bool) {
+	s := strings.Split(name, ".")
+	if len(s) < 3 || len(s[1]) != 8 || len(s[2]) != 4 || !isDigits(s[1]) || !isDigits(s[2]) {
+		return false, "", false
+	}
+	switch s[0] {
+	case "rib", "bview":
+		return true, s[1] + "." + s[2], true
+	case "updates":
+		return false, s[1] + "." + s[2], true
+	}
+	return false, "", false
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// collectorOfPath returns the collector of a file below the input directory root ("" if it can not be told)
+func collectorOfPath(root string, file string) string {
+	rel, err := filepath.Rel(root, filepath.Dir(file))
+	if err != nil || rel == "." {
+		rel = ""
+	}
+	dirs := strings.Split(filepath.ToSlash(rel), "/")
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if dirs[i] != "" && !archiveLayoutDirectory.MatchString(dirs[i]) {
+			return dirs[i]
+		}
+	}
+	base := filepath.Base(filepath.Clean(root))
+	if collectorDirectory.MatchString(base) {
+		return base
+	}
+	return ""
+}
+
+// findArchives walks through the input directories (comma separated) and groups all RIBs and updates files by collector
+func findArchives(inputDirectories string) ([]*collectorArchive, error) {
+	byCollector := make(map[string]*collectorArchive)
+	for _, root := range strings.Split(inputDirectories, ",") {
+		root = strings.TrimSpace(root)
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			isRIB, time, ok := parseArchiveFileName(info.Name())
+			if !ok {
+				return nil
+			}
+			f := archiveFile{path: path, collector: collectorOfPath(root, path), isRIB: isRIB, time: time}
+			a, ok := byCollector[f.collector]
+			if !ok {
+				a = &collectorArchive{collector: f.collector}
+				byCollector[f.collector] = a
+			}
+			if isRIB {
+				a.ribs = append(a.ribs, f)
+			} else {
+				a.updates = append(a.updates, f)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]*collectorArchive, 0, len(byCollector))
+	for _, a := range byCollector {
+		sort.Slice(a.ribs, func(i, j int) bool { return a.ribs[i].time < a.ribs[j].time })
+		sort.Slice(a.updates, func(i, j int) bool { return a.updates[i].time < a.updates[j].time })
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].collector < result[j].collector })
+	return result, nil
+}
+
+// selectRIB returns the newest RIB of the collector which is not newer than notAfter (YYYYMMDD.HHMM, "" for the newest RIB at all)
+func (a *collectorArchive) selectRIB(notAfter string) (archiveFile, bool) {
+	for i := len(a.ribs) - 1; i >= 0; i-- {
+		if notAfter == "" || a.ribs[i].time <= notAfter {
+			return a.ribs[i], true
+		}
+	}
+	return archiveFile{}, false
+}
+
+// updatesInWindow returns all updates files of the collector, which represent a time between start and end (YYYYMMDD.HHMM, "" for no end).
+// A file represents the time from its own time until the time of the next file
+func (a *collectorArchive) updatesInWindow(start string, end string) []string {
+	result := make([]string, 0, len(a.updates))
+	for i, f := range a.updates {
+		if end != "" && f.time > end {
+			break
+		}
+		if i+1 < len(a.updates) && a.updates[i+1].time <= start {
+			continue
+		}
+		result = append(result, f.path)
+	}
+	return result
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeArchiveFile writes MRT records to a file below the root of an archive
+func writeArchiveFile(t *testing.T, root string, path string, records ...[]byte) {
+	fileName := filepath.Join(root, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fileName, concat(records...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useInputDirectory sets the input directory and the time window for processBGPFiles for the duration of the test
+func useInputDirectory(t *testing.T, directory string) {
+	inputDirectoryBefore, ribBefore, fromBefore, untilBefore := inputDirectory, rib, fromT, untilT
+	inputDirectory = directory
+	t.Cleanup(func() { inputDirectory, rib, fromT, untilT = inputDirectoryBefore, ribBefore, fromBefore, untilBefore })
+}
+
+func TestFindArchives(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{
+		"rrc00/2020.09/bview.20200913.0800.gz",
+		"rrc00/2020.09/updates.20200913.0805.gz",
+		"rrc00/2020.09/updates.20200913.0800.gz",
+		"route-views.2/bgpdata/2020.09/RIBS/rib.20200913.0800.bz2",
+		"route-views.2/bgpdata/2020.09/UPDATES/updates.20200913.0800.bz2",
+		"route-views.2/bgpdata/2020.09/UPDATES/README",
+	} {
+		writeArchiveFile(t, root, path)
+	}
+	archives, err := findArchives(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 2 || archives[0].collector != "route-views.2" || archives[1].collector != "rrc00" {
+		t.Fatalf("got %d archives, want route-views.2 and rrc00", len(archives))
+	}
+	rrc00 := archives[1]
+	if len(rrc00.ribs) != 1 || len(rrc00.updates) != 2 || rrc00.updates[0].time != "20200913.0800" || rrc00.updates[1].time != "20200913.0805" {
+		t.Errorf("files of rrc00: %+v %+v", rrc00.ribs, rrc00.updates)
+	}
+	if len(archives[0].ribs) != 1 || len(archives[0].updates) != 1 {
+		t.Errorf("files of route-views.2: %+v %+v", archives[0].ribs, archives[0].updates)
+	}
+}
+
+func TestProcessBGPFilesMergesCollectors(t *testing.T) {
+	out := useTestDetector(t)
+	root := t.TempDir()
+	//read one collector after the other, the announcement of rrc00 would be withdrawn before the one of route-views.2 is read
+	writeArchiveFile(t, root, "rrc00/2020.09/updates.20200913.1200",
+		bgp4mpAddPath(1600000000, addPathUpdate(1, "192.0.2.0/24", false, 64496, 64500)),
+		bgp4mpAddPath(1600000300, addPathUpdate(1, "192.0.2.0/24", true)))
+	writeArchiveFile(t, root, "route-views.2/bgpdata/2020.09/UPDATES/updates.20200913.1200",
+		bgp4mpAddPath(1600000200, addPathUpdate(1, "192.0.2.0/24", false, 64496, 64501)))
+	useInputDirectory(t, root)
+
+	processBGPFiles()
+	if conflicts, closed := out.counts(); conflicts != 1 || closed != 1 {
+		t.Fatalf("%d conflicts and %d ended conflicts, want 1 and 1", conflicts, closed)
+	}
+	ref := out.conflicts[0].ReferenceAnnouncement
+	if ref.Timestamp != 1600000200 || ref.OriginAS != 64501 || ref.Collector != "route-views.2" {
+		t.Errorf("conflict triggered by %+v, want the announcement of route-views.2", ref)
+	}
+	if c := out.conflicts[0].Conflicts; len(c) != 1 || c[0].Collector != "rrc00" {
+		t.Errorf("conflicting announcements %+v, want the one of rrc00", c)
+	}
+	if out.closed[0].Ended != 1600000300 || out.closed[0].EndReason != "withdrawal" {
+		t.Errorf("ended conflict %+v, want the end by the withdrawal of rrc00", out.closed[0])
+	}
+}
+
+func TestUpdatesInWindow(t *testing.T) {
+	a := &collectorArchive{}
+	for _, time := range []string{"20200913.0800", "20200913.0815", "20200913.0830", "20200913.0845"} {
+		a.updates = append(a.updates, archiveFile{path: time, time: time})
+		a.ribs = append(a.ribs, archiveFile{path: time, time: time, isRIB: true})
+	}
+	tests := []struct {
+		start string
+		end   string
+		want  []string
+	}{
+		{"0000.0000", "", []string{"20200913.0800", "20200913.0815", "20200913.0830", "20200913.0845"}},
+		{"20200913.0815", "", []string{"20200913.0815", "20200913.0830", "20200913.0845"}},
+		{"20200913.0820", "", []string{"20200913.0815", "20200913.0830", "20200913.0845"}}, //the file of 08:15 covers 08:20
+		{"20200913.0815", "20200913.0830", []string{"20200913.0815", "20200913.0830"}},
+		{"20200913.0815", "20200913.0829", []string{"20200913.0815"}},
+		{"20200913.0900", "", []string{"20200913.0845"}},
+		{"0000.0000", "20200913.0759", []string{}},
+	}
+	for _, tt := range tests {
+		got := a.updatesInWindow(tt.start, tt.end)
+		if len(got) != len(tt.want) {
+			t.Errorf("window %s to %s: %v, want %v", tt.start, tt.end, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("window %s to %s: %v, want %v", tt.start, tt.end, got, tt.want)
+				break
+			}
+		}
+	}
+
+	for notAfter, want := range map[string]string{"": "20200913.0845", "20200913.0829": "20200913.0815", "20200913.0830": "20200913.0830"} {
+		if f, ok := a.selectRIB(notAfter); !ok || f.time != want {
+			t.Errorf("RIB not after %q: %s, want %s", notAfter, f.time, want)
+		}
+	}
+	if f, ok := a.selectRIB("20200913.0759"); ok {
+		t.Errorf("RIB not after 07:59: %s, want none", f.time)
+	}
+}
+
+func TestProcessBGPFilesTimeWindow(t *testing.T) {
+	out := useTestDetector(t)
+	root := t.TempDir()
+	//the entries of the RIBs are from 1600000000 (12:26:40)
+	writeArchiveFile(t, root, "rrc00/2020.09/bview.20200913.0800", tableDumpV1("233.252.0.0/24", 64496, 64505))
+	writeArchiveFile(t, root, "rrc00/2020.09/bview.20200913.1200", tableDumpV1("192.0.2.0/24", 64496, 64500))
+	writeArchiveFile(t, root, "rrc00/2020.09/bview.20200913.1300", tableDumpV1("198.51.100.0/24", 64496, 64506))
+	writeArchiveFile(t, root, "rrc00/2020.09/updates.20200913.1145", //before the RIB
+		bgp4mpAddPath(1599997500, addPathUpdate(1, "203.0.113.0/24", false, 64496, 64502)))
+	writeArchiveFile(t, root, "rrc00/2020.09/updates.20200913.1200",
+		bgp4mpAddPath(1600000100, addPathUpdate(1, "192.0.2.0/25", false, 64496, 64501)), //before -from
+		bgp4mpAddPath(1600000300, addPathUpdate(1, "192.0.2.128/25", false, 64496, 64503)))
+	writeArchiveFile(t, root, "rrc00/2020.09/updates.20200913.1300", //after -until
+		bgp4mpAddPath(1600002000, addPathUpdate(1, "198.51.100.0/25", false, 64496, 64504)))
+	useInputDirectory(t, root)
+	var err error
+	if fromT, err = parseTime("2020-09-13 12:30"); err != nil {
+		t.Fatal(err)
+	}
+	if untilT, err = parseTime("2020-09-13 12:50"); err != nil {
+		t.Fatal(err)
+	}
+
+	processBGPFiles()
+	for subnet, want := range map[string]int{"192.0.2.0/24": 1, "192.0.2.0/25": 1, "192.0.2.128/25": 1,
+		"233.252.0.0/24": 0, "198.51.100.0/24": 0, "203.0.113.0/24": 0, "198.51.100.0/25": 0} {
+		if origins := activeOrigins(subnet); len(origins) != want {
+			t.Errorf("origins of %s: %v, want %d", subnet, origins, want)
+		}
+	}
+	if conflicts, _ := out.counts(); conflicts != 1 || out.conflicts[0].ReferenceAnnouncement.Subnet != "192.0.2.128/25" {
+		t.Errorf("conflicts %+v, want only the one after -from", out.conflicts)
+	}
+}
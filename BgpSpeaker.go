@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osrg/gobgp/pkg/packet/bgp"
+)
+
+/*
+In the BGP speaker mode Hijackdetector accepts BGP sessions (iBGP or eBGP) of configured neighbors, e.g. of a route reflector.
+It is passive in every respect: it never connects to a neighbor and it never advertises any route, it only sends
+OPEN, KEEPALIVE and NOTIFICATION messages. Every UPDATE of a neighbor is inserted with the neighbor as peer.
+*/
+
+const bgpHoldTime = 90 //seconds, the neighbor may propose a smaller one
+
+var countBGPUpdates int
+var countBGPSessions int
+
+// bgpNeighbors contains the configured neighbors (IP address -> AS). An AS of 0 accepts any AS of the neighbor
+var bgpNeighbors map[string]uint32
+
+// bgpEstablished contains the neighbors with an established session. A neighbor may only have one session at a time
+var bgpEstablished map[string]bool
+var bgpEstablishedLock sync.Mutex
+
+// bgpEvent is an update of a neighbor, or (if update is nil) the end of the session with the neighbor
+type bgpEvent struct {
+	neighbor  string
+	as        uint32
+	timestamp uint32
+	update    *bgp.BGPUpdate
+	err       error
+}
+
+// parseBGPNeighbors parses the neighbors in the format ip=as,ip=as (the AS is optional)
+func parseBGPNeighbors(s string) (map[string]uint32, error) {
+	result := make(map[string]uint32)
+	for _, n := range strings.Split(s, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		parts := strings.SplitN(n, "=", 2)
+		ip := net.ParseIP(strings.TrimSpace(parts[0]))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address of neighbor: %s", parts[0])
+		}
+		as := uint64(0)
+		if len(parts) == 2 {
+			var err error
+			as, err = strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(parts[1])), "AS"), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid AS of neighbor %s: %s", parts[0], parts[1])
+			}
+		}
+		result[ip.String()] = uint32(as)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no neighbors configured")
+	}
+	return result, nil
+}
+
+// runBGPSpeaker accepts the BGP sessions of the neighbors. The updates of all sessions are inserted one after another by the calling goroutine
+func runBGPSpeaker() {
+	var err error
+	bgpNeighbors, err = parseBGPNeighbors(bgpNeighborsString)
+	if err != nil {
+		fmt.Println(Red("could not parse -bgpneighbors: ", err))
+		return
+	}
+	if bgpLocalAS == 0 {
+		fmt.Println(Red("-bgpas is needed for the BGP speaker"))
+		return
+	}
+	if net.ParseIP(bgpRouterID).To4() == nil {
+		fmt.Println(Red("-bgprouterid has to be an IPv4 address, got: ", bgpRouterID))
+		return
+	}
+	bgpEstablished = make(map[string]bool)
+	listener, err := net.Listen("tcp", bgpListenAddress)
+	if err != nil {
+		fmt.Println(Red("could not listen for BGP sessions: ", err))
+		return
+	}
+	fmt.Println(Teal("Listening for BGP sessions on ", listener.Addr(), " (AS ", bgpLocalAS, ", router ID ", bgpRouterID, ", ", len(bgpNeighbors), " neighbors)"))
+	events := make(chan bgpEvent, buffer)
+	setInputSource("bgp", func() int { return len(events) })
+	go acceptBGP(listener, events)
+	for e := range events {
+		handleBGP(e)
+	}
+}
+
+func acceptBGP(listener net.Listener, events chan bgpEvent) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Println(Red("could not accept BGP session: ", err))
+			time.Sleep(time.Second)
+			continue
+		}
+		neighbor, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		if ip := net.ParseIP(neighbor); ip != nil {
+			neighbor = ip.String()
+		}
+		if _, ok := bgpNeighbors[neighbor]; !ok {
+			fmt.Println(Red("rejected BGP session of ", neighbor, ": not a configured neighbor"))
+			conn.Close()
+			continue
+		}
+		go runBGPSession(conn, neighbor, events)
+	}
+}
+
+// runBGPSession runs the (passive) finite state machine for one neighbor: OPEN, KEEPALIVE, and then UPDATEs until the session ends
+func runBGPSession(conn net.Conn, neighbor string, events chan bgpEvent) {
+	defer conn.Close()
+	bgpEstablishedLock.Lock()
+	if bgpEstablished[neighbor] {
+		bgpEstablishedLock.Unlock()
+		fmt.Println(Red("rejected BGP session of ", neighbor, ": there is already a session with this neighbor"))
+		writeBGPMessage(conn, bgp.NewBGPNotificationMessage(bgp.BGP_ERROR_CEASE, bgp.BGP_ERROR_SUB_CONNECTION_COLLISION_RESOLUTION, nil))
+		return
+	}
+	bgpEstablished[neighbor] = true
+	bgpEstablishedLock.Unlock()
+	defer func() {
+		bgpEstablishedLock.Lock()
+		delete(bgpEstablished, neighbor)
+		bgpEstablishedLock.Unlock()
+	}()
+
+	conn.SetDeadline(time.Now().Add(bgpHoldTime * time.Second))
+	peerAS, holdTime, err := openBGPSession(conn, neighbor)
+	if err != nil {
+		fmt.Println(Red("could not establish BGP session with ", neighbor, ": ", err))
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	bgpEstablishedLock.Lock()
+	countBGPSessions++
+	bgpEstablishedLock.Unlock()
+	fmt.Println(Green("BGP session with ", neighbor, " (AS ", peerAS, ") established, hold time ", holdTime))
+
+	var writeLock sync.Mutex
+	done := make(chan struct{})
+	defer close(done)
+	if holdTime > 0 {
+		go sendBGPKeepalives(conn, &writeLock, holdTime/3, done)
+	}
+
+	err = nil
+	for err == nil {
+		if holdTime > 0 {
+			conn.SetReadDeadline(time.Now().Add(holdTime))
+		}
+		var msg *bgp.BGPMessage
+		msg, err = readBGPMessage(conn)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				writeLock.Lock()
+				writeBGPMessage(conn, bgp.NewBGPNotificationMessage(bgp.BGP_ERROR_HOLD_TIMER_EXPIRED, 0, nil))
+				writeLock.Unlock()
+			}
+			break
+		}
+		switch body := msg.Body.(type) {
+		case *bgp.BGPKeepAlive: //the read deadline is already extended
+		case *bgp.BGPUpdate:
+			events <- bgpEvent{neighbor: neighbor, as: peerAS, timestamp: uint32(time.Now().Unix()), update: body}
+		case *bgp.BGPNotification:
+			err = fmt.Errorf("notification received (code %d, subcode %d)", body.ErrorCode, body.ErrorSubcode)
+		case *bgp.BGPRouteRefresh: //we have nothing to advertise
+		default:
+			err = fmt.Errorf("unexpected message of type %d", msg.Header.Type)
+		}
+	}
+	events <- bgpEvent{neighbor: neighbor, as: peerAS, timestamp: uint32(time.Now().Unix()), err: err}
+}
+
+// openBGPSession exchanges the OPEN messages and returns the AS of the neighbor and the negotiated hold time
+func openBGPSession(conn net.Conn, neighbor string) (uint32, time.Duration, error) {
+	msg, err := readBGPMessage(conn)
+	if err != nil {
+		return 0, 0, err
+	}
+	open, ok := msg.Body.(*bgp.BGPOpen)
+	if !ok {
+		return 0, 0, fmt.Errorf("expected OPEN, got message of type %d", msg.Header.Type)
+	}
+	peerAS := uint32(open.MyAS)
+	for _, p := range open.OptParams {
+		if c, ok := p.(*bgp.OptionParameterCapability); ok {
+			for _, capability := range c.Capability {
+				if as4, ok := capability.(*bgp.CapFourOctetASNumber); ok {
+					peerAS = as4.CapValue
+				}
+			}
+		}
+	}
+	if expected := bgpNeighbors[neighbor]; expected != 0 && expected != peerAS {
+		writeBGPMessage(conn, bgp.NewBGPNotificationMessage(bgp.BGP_ERROR_OPEN_MESSAGE_ERROR, bgp.BGP_ERROR_SUB_BAD_PEER_AS, nil))
+		return 0, 0, fmt.Errorf("neighbor has AS %d, expected AS %d", peerAS, expected)
+	}
+	holdTime := uint16(bgpHoldTime)
+	if open.HoldTime < holdTime {
+		holdTime = open.HoldTime
+	}
+	if holdTime == 1 || holdTime == 2 { //RFC 4271: the hold time must be either zero or at least three seconds
+		writeBGPMessage(conn, bgp.NewBGPNotificationMessage(bgp.BGP_ERROR_OPEN_MESSAGE_ERROR, bgp.BGP_ERROR_SUB_UNACCEPTABLE_HOLD_TIME, nil))
+		return 0, 0, fmt.Errorf("unacceptable hold time %d", open.HoldTime)
+	}
+
+	myAS := uint16(bgp.AS_TRANS)
+	if bgpLocalAS <= 0xFFFF {
+		myAS = uint16(bgpLocalAS)
+	}
+	capabilities := []bgp.ParameterCapabilityInterface{
+		bgp.NewCapMultiProtocol(bgp.RF_IPv4_UC),
+		bgp.NewCapMultiProtocol(bgp.RF_IPv6_UC),
+		bgp.NewCapRouteRefresh(),
+		bgp.NewCapFourOctetASNumber(bgpLocalAS),
+	}
+	err = writeBGPMessage(conn, bgp.NewBGPOpenMessage(myAS, uint16(bgpHoldTime), bgpRouterID, []bgp.OptionParameterInterface{bgp.NewOptionParameterCapability(capabilities)}))
+	if err != nil {
+		return 0, 0, err
+	}
+	err = writeBGPMessage(conn, bgp.NewBGPKeepAliveMessage())
+	return peerAS, time.Duration(holdTime) * time.Second, err
+}
+
+func sendBGPKeepalives(conn net.Conn, writeLock *sync.Mutex, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			writeLock.Lock()
+			err := writeBGPMessage(conn, bgp.NewBGPKeepAliveMessage())
+			writeLock.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readBGPMessage(conn net.Conn) (*bgp.BGPMessage, error) {
+	header := make([]byte, bgp.BGP_HEADER_LENGTH)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := int(header[16])<<8 | int(header[17])
+	if length < bgp.BGP_HEADER_LENGTH || length > 65535 {
+		return nil, fmt.Errorf("invalid length of BGP message: %d", length)
+	}
+	data := make([]byte, length)
+	copy(data, header)
+	if _, err := io.ReadFull(conn, data[bgp.BGP_HEADER_LENGTH:]); err != nil {
+		return nil, err
+	}
+	return bgp.ParseBGPMessage(data)
+}
+
+func writeBGPMessage(conn net.Conn, msg *bgp.BGPMessage) error {
+	data, err := msg.Serialize()
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Write(data)
+	return err
+}
+
+func handleBGP(e bgpEvent) {
+	peerID := det.Peer(e.neighbor, e.as, "")
+	if e.update == nil {
+		fmt.Println(Red("BGP session with ", e.neighbor, " ended: ", e.err))
+		det.FlushPeer(peerID, e.timestamp)
+		return
+	}
+	countBGPUpdates++
+	var localOrigin uint32
+	if e.as == bgpLocalAS { //iBGP: routes of our own AS have an empty AS path
+		localOrigin = bgpLocalAS
+	}
+	subnetsAnouncments, subnetsWithdrawls := extractPrefixes(e.update)
+	insertPrefixesOfUpdate(peerID, e.timestamp, subnetsAnouncments, subnetsWithdrawls, e.update.PathAttributes, localOrigin, true) //End-of-RIB markers contain no prefixes at all
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/osrg/gobgp/pkg/packet/bgp"
+)
+
+// startBGPSpeaker accepts sessions of the neighbor 127.0.0.1 with the given AS like runBGPSpeaker. The events are not
+// handled, so that the test can check the state in between
+func startBGPSpeaker(t *testing.T, neighborAS uint32) (string, chan bgpEvent) {
+	bgpNeighbors = map[string]uint32{"127.0.0.1": neighborAS}
+	bgpEstablishedLock.Lock() //the sessions of earlier tests may still end
+	bgpEstablished = make(map[string]bool)
+	bgpEstablishedLock.Unlock()
+	bgpLocalAS = 64512
+	bgpRouterID = "192.0.2.254"
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan bgpEvent, 100)
+	go acceptBGP(listener, events)
+	return listener.Addr().String(), events
+}
+
+// connectBGP opens a session as neighbor with the given AS and returns the connection and the first answer to our OPEN
+func connectBGP(t *testing.T, address string, as uint32, holdTime uint16) (net.Conn, *bgp.BGPMessage) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	myAS := uint16(bgp.AS_TRANS)
+	if as <= 0xFFFF {
+		myAS = uint16(as)
+	}
+	capabilities := []bgp.ParameterCapabilityInterface{bgp.NewCapMultiProtocol(bgp.RF_IPv4_UC), bgp.NewCapFourOctetASNumber(as)}
+	if err := writeBGPMessage(conn, bgp.NewBGPOpenMessage(myAS, holdTime, "192.0.2.1", []bgp.OptionParameterInterface{bgp.NewOptionParameterCapability(capabilities)})); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := readBGPMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, msg
+}
+
+func handleBGPEvents(t *testing.T, events chan bgpEvent, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case e := <-events:
+			handleBGP(e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d BGP events received", i, n)
+		}
+	}
+}
+
+func TestBGPOpen(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured uint32
+		as         uint32
+		holdTime   uint16
+		subcode    uint8 //0 if the session has to be accepted
+	}{
+		{"expected AS", 64496, 64496, 90, 0},
+		{"any AS", 0, 64497, 90, 0},
+		{"four octet AS", 4200000000, 4200000000, 90, 0},
+		{"wrong AS", 64496, 64497, 90, bgp.BGP_ERROR_SUB_BAD_PEER_AS},
+		{"wrong four octet AS", 4200000000, 4200000001, 90, bgp.BGP_ERROR_SUB_BAD_PEER_AS},
+		{"hold time too small", 64496, 64496, 2, bgp.BGP_ERROR_SUB_UNACCEPTABLE_HOLD_TIME},
+	}
+	for _, test := range tests {
+		address, _ := startBGPSpeaker(t, test.configured)
+		conn, msg := connectBGP(t, address, test.as, test.holdTime)
+		if test.subcode != 0 {
+			notification, ok := msg.Body.(*bgp.BGPNotification)
+			if !ok || notification.ErrorCode != bgp.BGP_ERROR_OPEN_MESSAGE_ERROR || notification.ErrorSubcode != test.subcode {
+				t.Errorf("%s: got %+v, want a notification with subcode %d", test.name, msg.Body, test.subcode)
+			}
+			continue
+		}
+		open, ok := msg.Body.(*bgp.BGPOpen)
+		if !ok || open.MyAS != 64512 || open.HoldTime != bgpHoldTime || open.ID.String() != "192.0.2.254" {
+			t.Errorf("%s: got %+v, want our OPEN", test.name, msg.Body)
+			continue
+		}
+		if msg, err := readBGPMessage(conn); err != nil || msg.Header.Type != bgp.BGP_MSG_KEEPALIVE {
+			t.Errorf("%s: no KEEPALIVE after the OPEN: %v", test.name, err)
+		}
+	}
+}
+
+func TestBGPSession(t *testing.T) {
+	out := useTestDetector(t)
+	address, events := startBGPSpeaker(t, 64496)
+	conn, msg := connectBGP(t, address, 64496, 90)
+	if msg.Header.Type != bgp.BGP_MSG_OPEN {
+		t.Fatalf("session not accepted: %+v", msg.Body)
+	}
+
+	send := func(msg *bgp.BGPMessage) {
+		if err := writeBGPMessage(conn, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(bgp.NewBGPKeepAliveMessage())
+	send(testUpdate([]string{"192.0.2.0/24"}, nil, 64496, 64500))
+	send(testUpdate([]string{"192.0.2.0/25"}, nil, 64496, 64501))
+	handleBGPEvents(t, events, 2)
+	if origins := activeOrigins("192.0.2.0/24"); len(origins) != 1 || origins[0] != 64500 {
+		t.Errorf("origins of 192.0.2.0/24: %v, want [64500]", origins)
+	}
+	if conflicts, _ := out.counts(); conflicts != 1 {
+		t.Errorf("%d conflicts, want 1", conflicts)
+	}
+	peers := det.Peers()
+	if len(peers) != 1 || peers[0].IP != "127.0.0.1" || peers[0].AS != 64496 {
+		t.Errorf("peers %+v, want the neighbor", peers)
+	}
+
+	send(testUpdate(nil, []string{"192.0.2.0/25"}))
+	handleBGPEvents(t, events, 1)
+	if _, closed := out.counts(); closed != 1 || out.closed[0].EndReason != "withdrawal" {
+		t.Errorf("%d ended conflicts after the withdrawal, want 1", closed)
+	}
+
+	conn.Close()
+	handleBGPEvents(t, events, 1) //the end of the session
+	if origins := activeOrigins("192.0.2.0/24"); len(origins) != 0 {
+		t.Errorf("the routes of origins %v are still active after the session dropped", origins)
+	}
+}
+
+func TestBGPSessionIBGP(t *testing.T) {
+	useTestDetector(t)
+	address, events := startBGPSpeaker(t, 64512)
+	conn, msg := connectBGP(t, address, 64512, 90)
+	if msg.Header.Type != bgp.BGP_MSG_OPEN {
+		t.Fatalf("session not accepted: %+v", msg.Body)
+	}
+	send := func(msg *bgp.BGPMessage) {
+		if err := writeBGPMessage(conn, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mpReach := func(subnet string, path ...uint32) *bgp.BGPMessage {
+		_, ipnet, _ := net.ParseCIDR(subnet)
+		length, _ := ipnet.Mask.Size()
+		return bgp.NewBGPUpdateMessage(nil, []bgp.PathAttributeInterface{
+			bgp.NewPathAttributeOrigin(bgp.BGP_ORIGIN_ATTR_TYPE_IGP),
+			bgp.NewPathAttributeAsPath([]bgp.AsPathParamInterface{bgp.NewAs4PathParam(bgp.BGP_ASPATH_ATTR_TYPE_SEQ, path)}),
+			bgp.NewPathAttributeMpReachNLRI("192.0.2.254", []bgp.AddrPrefixInterface{bgp.NewIPAddrPrefix(uint8(length), ipnet.IP.String())}),
+		}, nil)
+	}
+	mpUnreach := func(subnet string) *bgp.BGPMessage {
+		_, ipnet, _ := net.ParseCIDR(subnet)
+		length, _ := ipnet.Mask.Size()
+		return bgp.NewBGPUpdateMessage(nil, []bgp.PathAttributeInterface{
+			bgp.NewPathAttributeMpUnreachNLRI([]bgp.AddrPrefixInterface{bgp.NewIPAddrPrefix(uint8(length), ipnet.IP.String())}),
+		}, nil)
+	}
+
+	send(bgp.NewBGPKeepAliveMessage())
+	send(testUpdate([]string{"198.51.100.0/24"}, nil)) //originated in our own AS
+	send(mpReach("203.0.113.0/24", 64500))
+	handleBGPEvents(t, events, 2)
+	if origins := activeOrigins("198.51.100.0/24"); len(origins) != 1 || origins[0] != 64512 {
+		t.Errorf("origins of 198.51.100.0/24: %v, want the local AS 64512", origins)
+	}
+	if origins := activeOrigins("203.0.113.0/24"); len(origins) != 1 || origins[0] != 64500 {
+		t.Errorf("origins of 203.0.113.0/24 from MP_REACH_NLRI: %v, want [64500]", origins)
+	}
+
+	send(mpUnreach("203.0.113.0/24"))
+	handleBGPEvents(t, events, 1)
+	if origins := activeOrigins("203.0.113.0/24"); len(origins) != 0 {
+		t.Errorf("origins of 203.0.113.0/24 after MP_UNREACH_NLRI: %v, want none", origins)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/osrg/gobgp/pkg/packet/bgp"
+	"github.com/osrg/gobgp/pkg/packet/bmp"
+)
+
+/*
+The BGP Monitoring Protocol (RFC 7854) lets routers export the routes they receive from their peers. Hijackdetector listens
+for BMP sessions of routers and inserts the Route Monitoring messages like the updates of a route collector. The monitored
+router takes the role of the route collector: its address is used as the collector of all of its peers.
+*/
+
+var countBMPMessages int
+var countBMPSessions int
+
+// bmpPeersBySession contains all peers which have been reported in a BMP session, so that they can be flushed if the session
+// ends. It is keyed by the session and not by the router, as a router may already have reconnected when its old session ends
+var bmpPeersBySession map[uint64]map[uint16]bool
+
+// bmpEvent is a BMP message of a router, or (if msg is nil) the end of the BMP session of the router
+type bmpEvent struct {
+	session uint64 //counts the accepted connections
+	router  string
+	msg     *bmp.BMPMessage
+	err     error
+}
+
+// runBMP accepts BMP sessions of routers. The messages of all sessions are inserted one after another by the calling goroutine
+func runBMP() {
+	listener, err := net.Listen("tcp", bmpAddress)
+	if err != nil {
+		fmt.Println(Red("could not listen for BMP sessions: ", err))
+		return
+	}
+	fmt.Println(Teal("Listening for BMP sessions on ", listener.Addr()))
+	bmpPeersBySession = make(map[uint64]map[uint16]bool)
+	events := make(chan bmpEvent, buffer)
+	setInputSource("bmp", func() int { return len(events) })
+	go acceptBMP(listener, events)
+	for e := range events {
+		handleBMP(e)
+	}
+}
+
+func acceptBMP(listener net.Listener, events chan bmpEvent) {
+	var session uint64
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Println(Red("could not accept BMP session: ", err))
+			time.Sleep(time.Second)
+			continue
+		}
+		session++
+		go readBMP(conn, session, events)
+	}
+}
+
+// readBMP splits the byte stream of one router into BMP messages until the session ends
+func readBMP(conn net.Conn, session uint64, events chan bmpEvent) {
+	defer conn.Close()
+	router, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		router = conn.RemoteAddr().String()
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(bmp.SplitBMP)
+	for scanner.Scan() {
+		msg, err := bmp.ParseBMPMessage(scanner.Bytes())
+		if err != nil {
+			countParseError("bmp_message")
+			fmt.Println(Red("could not parse BMP message of router ", router, ": ", err))
+			continue
+		}
+		events <- bmpEvent{session: session, router: router, msg: msg}
+	}
+	events <- bmpEvent{session: session, router: router, err: scanner.Err()}
+}
+
+func handleBMP(e bmpEvent) {
+	if e.msg == nil {
+		fmt.Println(Red("BMP session of router ", e.router, " ended: ", e.err))
+		flushPeersOfSession(e.session, uint32(time.Now().Unix()))
+		return
+	}
+	countBMPMessages++
+	header := e.msg.PeerHeader
+	timestamp := uint32(header.Timestamp)
+	if timestamp == 0 { //the timestamp is optional for some message types
+		timestamp = uint32(time.Now().Unix())
+	}
+
+	switch body := e.msg.Body.(type) {
+	case *bmp.BMPInitiation:
+		countBMPSessions++
+		fmt.Println(Teal("BMP session of router ", e.router, " initiated"))
+	case *bmp.BMPPeerUpNotification:
+		if !useBMPPeer(header) {
+			return
+		}
+		findBMPPeer(e, header)
+		if verbose {
+			fmt.Println(Teal("BMP peer up at router ", e.router, ": ", header.PeerAddress, " (AS ", header.PeerAS, ")"))
+		}
+	case *bmp.BMPPeerDownNotification:
+		if !useBMPPeer(header) {
+			return
+		}
+		peerID := findBMPPeer(e, header)
+		det.FlushPeer(peerID, timestamp)
+		delete(bmpPeersBySession[e.session], peerID)
+	case *bmp.BMPRouteMonitoring:
+		if !useBMPPeer(header) || header.IsPostPolicy() != bmpPostPolicy {
+			return
+		}
+		update, ok := body.BGPUpdate.Body.(*bgp.BGPUpdate)
+		if !ok {
+			return
+		}
+		peerID := findBMPPeer(e, header)
+		subnetsAnouncments, subnetsWithdrawls := extractPrefixes(update)
+		insertPrefixesOfUpdate(peerID, timestamp, subnetsAnouncments, subnetsWithdrawls, update.PathAttributes, 0, true) //End-of-RIB markers contain no prefixes at all
+	}
+}
+
+// useBMPPeer returns false for peers of VRFs and for the Loc-RIB, as their routes are not part of the global routing table
+func useBMPPeer(header bmp.BMPPeerHeader) bool {
+	return header.PeerType == bmp.BMP_PEER_TYPE_GLOBAL
+}
+
+// findBMPPeer returns the ID of the peer in the peer header. The monitored router is the collector of the peer
+func findBMPPeer(e bmpEvent, header bmp.BMPPeerHeader) uint16 {
+	peerID := det.Peer(header.PeerAddress.String(), header.PeerAS, e.router)
+	peers, ok := bmpPeersBySession[e.session]
+	if !ok {
+		peers = make(map[uint16]bool)
+		bmpPeersBySession[e.session] = peers
+	}
+	peers[peerID] = true
+	return peerID
+}
+
+// flushPeersOfSession removes the routes of all peers of a BMP session which ended, as we do not learn about changes anymore.
+// Peers which are also reported in another session (of the reconnected router) are kept
+func flushPeersOfSession(session uint64, timestamp uint32) {
+	peers := bmpPeersBySession[session]
+	delete(bmpPeersBySession, session)
+	for peerID := range peers {
+		if !reportedInOtherSession(peerID) {
+			det.FlushPeer(peerID, timestamp)
+		}
+	}
+}
+
+func reportedInOtherSession(peerID uint16) bool {
+	for _, peers := range bmpPeersBySession {
+		if peers[peerID] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/osrg/gobgp/pkg/packet/bgp"
+	"github.com/osrg/gobgp/pkg/packet/bmp"
+)
+
+// testUpdate returns a BGP UPDATE which announces or withdraws IPv4 prefixes (e.g. "192.0.2.0/24") with the given AS path
+func testUpdate(announced []string, withdrawn []string, path ...uint32) *bgp.BGPMessage {
+	toPrefixes := func(subnets []string) []*bgp.IPAddrPrefix {
+		result := make([]*bgp.IPAddrPrefix, 0, len(subnets))
+		for _, s := range subnets {
+			_, ipnet, _ := net.ParseCIDR(s)
+			length, _ := ipnet.Mask.Size()
+			result = append(result, bgp.NewIPAddrPrefix(uint8(length), ipnet.IP.String()))
+		}
+		return result
+	}
+	var attributes []bgp.PathAttributeInterface
+	if len(announced) > 0 {
+		var segments []bgp.AsPathParamInterface //an empty AS path has no segment at all
+		if len(path) > 0 {
+			segments = []bgp.AsPathParamInterface{bgp.NewAs4PathParam(bgp.BGP_ASPATH_ATTR_TYPE_SEQ, path)}
+		}
+		attributes = []bgp.PathAttributeInterface{
+			bgp.NewPathAttributeOrigin(bgp.BGP_ORIGIN_ATTR_TYPE_IGP),
+			bgp.NewPathAttributeAsPath(segments),
+			bgp.NewPathAttributeNextHop("192.0.2.254"),
+		}
+	}
+	return bgp.NewBGPUpdateMessage(toPrefixes(withdrawn), attributes, toPrefixes(announced))
+}
+
+func bmpPeerHeader(address string, as uint32, postPolicy bool) bmp.BMPPeerHeader {
+	var flags uint8
+	if postPolicy {
+		flags = bmp.BMP_PEER_FLAG_POST_POLICY
+	}
+	return *bmp.NewBMPPeerHeader(bmp.BMP_PEER_TYPE_GLOBAL, flags, 0, address, as, address, 1600000000)
+}
+
+// bmpSender is a router which exports its routes to us
+type bmpSender struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func newBMPSender(t *testing.T, address string) *bmpSender {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s := &bmpSender{t: t, conn: conn}
+	s.send(bmp.NewBMPInitiation([]bmp.BMPInfoTLVInterface{bmp.NewBMPInfoTLVString(bmp.BMP_INIT_TLV_TYPE_SYS_NAME, "router")}))
+	return s
+}
+
+func (s *bmpSender) send(msg *bmp.BMPMessage) {
+	data, err := msg.Serialize()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if _, err := s.conn.Write(data); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *bmpSender) peerUp(header bmp.BMPPeerHeader) {
+	open := bgp.NewBGPOpenMessage(64496, 90, "192.0.2.254", nil)
+	s.send(bmp.NewBMPPeerUpNotification(header, "192.0.2.254", 179, 50000, open, open))
+}
+
+// startBMP listens for BMP sessions like runBMP. The events are not handled, so that the test can check the state in between
+func startBMP(t *testing.T) (string, chan bmpEvent) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bmpPeersBySession = make(map[uint64]map[uint16]bool)
+	events := make(chan bmpEvent, 100)
+	go acceptBMP(listener, events)
+	return listener.Addr().String(), events
+}
+
+// handleBMPEvents handles the next n events
+func handleBMPEvents(t *testing.T, events chan bmpEvent, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case e := <-events:
+			handleBMP(e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d BMP events received", i, n)
+		}
+	}
+}
+
+func activeOrigins(subnet string) []int {
+	_, ipnet, _ := net.ParseCIDR(subnet)
+	var origins []int
+	for _, m := range det.Announcements(*ipnet) {
+		origins = append(origins, m.OriginAS)
+	}
+	return origins
+}
+
+func TestBMPSession(t *testing.T) {
+	for _, postPolicy := range []bool{false, true} {
+		out := useTestDetector(t)
+		bmpPostPolicy = postPolicy
+		address, events := startBMP(t)
+		router := newBMPSender(t, address)
+
+		peerA := bmpPeerHeader("192.0.2.1", 64496, false)
+		peerB := bmpPeerHeader("192.0.2.2", 64497, false)
+		router.peerUp(peerA)
+		router.peerUp(peerB)
+		router.send(bmp.NewBMPRouteMonitoring(peerA, testUpdate([]string{"192.0.2.0/24"}, nil, 64496, 64500)))
+		router.send(bmp.NewBMPRouteMonitoring(peerB, testUpdate([]string{"192.0.2.0/24"}, nil, 64497, 64501)))
+		//after the policy of the router only the route of peer A is left, with another origin
+		router.send(bmp.NewBMPRouteMonitoring(bmpPeerHeader("192.0.2.1", 64496, true), testUpdate([]string{"192.0.2.0/24"}, nil, 64496, 64502)))
+		handleBMPEvents(t, events, 6)
+
+		conflicts, _ := out.counts()
+		origins := activeOrigins("192.0.2.0/24")
+		if !postPolicy && (conflicts != 1 || len(origins) != 2) {
+			t.Errorf("pre-policy: %d conflicts, origins %v, want 1 conflict between 64500 and 64501", conflicts, origins)
+		}
+		if postPolicy && (conflicts != 0 || len(origins) != 1 || origins[0] != 64502) {
+			t.Errorf("post-policy: %d conflicts, origins %v, want only 64502", conflicts, origins)
+		}
+
+		router.send(bmp.NewBMPPeerDownNotification(peerB, bmp.BMP_PEER_DOWN_REASON_REMOTE_NO_NOTIFICATION, nil, nil))
+		handleBMPEvents(t, events, 1)
+		_, closed := out.counts()
+		if !postPolicy && (closed != 1 || out.closed[0].EndReason != "peer-down") {
+			t.Errorf("pre-policy: %d ended conflicts after the peer down of peer B, want 1 ended by peer-down", closed)
+		}
+
+		router.conn.Close()
+		handleBMPEvents(t, events, 1) //the end of the session
+		if origins := activeOrigins("192.0.2.0/24"); len(origins) != 0 {
+			t.Errorf("the routes of origins %v are still active after the BMP session ended", origins)
+		}
+	}
+	bmpPostPolicy = false
+}
+
+func TestBMPReconnectKeepsPeersOfNewSession(t *testing.T) {
+	useTestDetector(t)
+	address, events := startBMP(t)
+	peer := bmpPeerHeader("192.0.2.1", 64496, false)
+
+	oldSession := newBMPSender(t, address)
+	oldSession.peerUp(peer)
+	oldSession.send(bmp.NewBMPRouteMonitoring(peer, testUpdate([]string{"192.0.2.0/24"}, nil, 64496, 64500)))
+	handleBMPEvents(t, events, 3)
+
+	//the router reconnects from the same address before we notice that the old session ended
+	newSession := newBMPSender(t, address)
+	newSession.peerUp(peer)
+	newSession.send(bmp.NewBMPRouteMonitoring(peer, testUpdate([]string{"198.51.100.0/24"}, nil, 64496, 64501)))
+	handleBMPEvents(t, events, 3)
+	oldSession.conn.Close()
+	handleBMPEvents(t, events, 1)
+
+	if origins := activeOrigins("198.51.100.0/24"); len(origins) != 1 {
+		t.Errorf("the end of the old session flushed the routes of the new session")
+	}
+	if len(bmpPeersBySession) != 1 {
+		t.Errorf("%d sessions are tracked, want 1", len(bmpPeersBySession))
+	}
+}
@@ -1,42 +1,38 @@
 package main
 
-import "net"
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
 
-func convertIPtoBits(ipNet net.IPNet) []uint8 {
-	var ip = ipNet.IP
-	var asField []uint8
-	length, _ := ipNet.Mask.Size()
-	if ip.To4() != nil {
-		for i := 0; i < 4; i++ {
-			x := int(ip.To4()[i])
-			for j := 7; j >= 0; j-- {
-				if x >= 1<<j {
-					asField = append(asField, 1)
-					x = x - (1 << j)
-				} else {
-					asField = append(asField, 0)
-				}
-			}
-		}
-	} else {
-		for i := 0; i < 16; i++ {
-
-			x := int(ip.To16()[i])
-			for j := 7; j >= 0; j-- {
-				if x >= 1<<j {
-					asField = append(asField, 1)
-					x = x - (1 << j)
+// timeLayouts are the accepted formats of -from and -until. Times without a time zone are UTC
+var timeLayouts = []string{
+	"20060102.1504",
+	"20060102",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
 
-				} else {
-					asField = append(asField, 0)
-				}
-			}
+// parseTime converts a point in time given as one of the timeLayouts or as unix timestamp (seconds)
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, s, time.UTC)
+		if err == nil {
+			return t.UTC(), nil
 		}
-
 	}
-	if length > cap(asField) {
-		length = cap(asField)
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
 	}
+	return time.Time{}, fmt.Errorf("unknown time format: %s (expected e.g. 20220826.1800, 2022-08-26T18:00:00Z, 2022-08-26 18:00 or a unix timestamp)", s)
+}
 
-	return asField[0:length]
+// convertTimeToFileTime converts a point in time into the time format of the RIB and updates file names (YYYYMMDD.HHMM, UTC)
+func convertTimeToFileTime(t time.Time) string {
+	return t.UTC().Format("20060102.1504")
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+The HTTP API answers queries about the current state of the detector with JSON:
+	/api/prefix?q=192.0.2.0/24   covering and more specific announcements of a prefix or an IP address, with origins, paths and peers
+	/api/conflicts[?prefix=...]  all conflicts which are still ongoing, optionally only those overlapping with a prefix
+	/api/asn/64496               the counters of an origin AS involved in conflicts
+	/api/peers                   all known peers
+	/api/statistics              the counters of the detector
+	/metrics                     the metrics in the Prometheus text format (see Metrics.go)
+The server runs next to any input mode and only reads the state.
+*/
+
+func runHTTPAPI() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/prefix", handlePrefixQuery)
+	mux.HandleFunc("/api/conflicts", handleConflictsQuery)
+	mux.HandleFunc("/api/asn/", handleASNQuery)
+	mux.HandleFunc("/api/peers", func(w http.ResponseWriter, r *http.Request) {
+		writeHTTPJson(w, http.StatusOK, det.Peers())
+	})
+	mux.HandleFunc("/api/statistics", func(w http.ResponseWriter, r *http.Request) {
+		writeHTTPJson(w, http.StatusOK, det.Statistics())
+	})
+	mux.HandleFunc("/metrics", handleMetrics)
+	fmt.Println(Teal("HTTP API listening on ", httpAddress))
+	if err := http.ListenAndServe(httpAddress, mux); err != nil {
+		fmt.Println(Red("HTTP API stopped: ", err))
+	}
+}
+
+// parseSubnetOrIP accepts a prefix in CIDR notation or a single IP address, which is treated as /32 or /128
+func parseSubnetOrIP(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, subnet, err := net.ParseCIDR(s)
+		return subnet, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("neither a prefix nor an IP address: %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func handlePrefixQuery(w http.ResponseWriter, r *http.Request) {
+	subnet, err := parseSubnetOrIP(r.URL.Query().Get("q"))
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeHTTPJson(w, http.StatusOK, det.Lookup(*subnet))
+}
+
+func handleConflictsQuery(w http.ResponseWriter, r *http.Request) {
+	var subnet *net.IPNet
+	if q := r.URL.Query().Get("prefix"); q != "" {
+		var err error
+		subnet, err = parseSubnetOrIP(q)
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	writeHTTPJson(w, http.StatusOK, det.ActiveConflicts(subnet))
+}
+
+func handleASNQuery(w http.ResponseWriter, r *http.Request) {
+	s := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/asn/"), "AS")
+	asn, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid ASN: %q", s))
+		return
+	}
+	origin, ok := det.Origin(uint32(asn))
+	if !ok {
+		writeHTTPError(w, http.StatusNotFound, fmt.Errorf("AS%d was not involved in any conflict", asn))
+		return
+	}
+	writeHTTPJson(w, http.StatusOK, origin)
+}
+
+func writeHTTPJson(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	writeHTTPJson(w, status, map[string]string{"error": err.Error()})
+}
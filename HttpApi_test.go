@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseSubnetOrIP(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string //"" if the query has to be rejected
+	}{
+		{"192.0.2.0/24", "192.0.2.0/24"},
+		{"192.0.2.77/24", "192.0.2.0/24"},
+		{"192.0.2.77", "192.0.2.77/32"},
+		{"::ffff:192.0.2.77", "192.0.2.77/32"},
+		{"2001:db8::/32", "2001:db8::/32"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"192.0.2.0/33", ""},
+		{"AS64500", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		subnet, err := parseSubnetOrIP(tt.query)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", tt.query, subnet)
+			}
+			continue
+		}
+		if err != nil || subnet.String() != tt.want {
+			t.Errorf("%q: got %v (%v), want %s", tt.query, subnet, err, tt.want)
+		}
+	}
+}
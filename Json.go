@@ -1,62 +1,197 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"bgp-hijack-detection/detector"
 )
 
-type messageJSON struct {
-	Subnet    string `json:"subnet"`
-	OriginAS  int    `json:"origin"`
-	Timestamp int    `json:"timestamp"`
-	Aspath    []int  `json:"aspath"`
+/*
+Found and ended conflicts are written as NDJSON (one JSON object per line). Every record carries the version of its schema
+and its type, so that consumers can tell the records of both files and of future versions apart:
+	{"schemaVersion":1,"type":"conflict","referenceAnnouncement":{...},"conflicts":[{...,"conflictId":"..."}]}
+	{"schemaVersion":1,"type":"conflictEnded","conflictId":"...","subnetA":...}
+	{"schemaVersion":1,"type":"alert","category":"watchlist","reason":"unexpected-origin","announcement":{...}}
+Alerts are written to the file for found conflicts. The conflictId of a conflict is the same in both files. The files can be rotated by time and/or size and compressed with gzip.
+*/
+
+const conflictsSchemaVersion = 1
+
+type conflictRecordJSON struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Type          string `json:"type"`
+	detector.ConflictJSON
+}
+
+type alertRecordJSON struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Type          string `json:"type"`
+	detector.AlertJSON
+}
+
+type closedConflictRecordJSON struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Type          string `json:"type"`
+	detector.ClosedConflictJSON
+}
+
+// ndjsonFile writes one record per line to baseName.ndjson (or .ndjson.gz). With rotation, the files are named
+// baseName.YYYYMMDD.HHMMSS.mmm.ndjson (UTC) after the time they were started, so that sorting them by name sorts them by time
+type ndjsonFile struct {
+	lock       sync.Mutex
+	baseName   string
+	rotate     time.Duration //0: no rotation by time
+	rotateSize int64         //in bytes of uncompressed data, 0: no rotation by size
+	compress   bool
+	f          *os.File
+	gz         *gzip.Writer
+	w          *bufio.Writer
+	openedT    time.Time
+	written    int64
+	closed     bool
+}
+
+var conflictsFile *ndjsonFile
+var closedConflictsFile *ndjsonFile
+
+func newNdjsonFile(baseName string, rotate time.Duration, rotateSize int64, compress bool) (*ndjsonFile, error) {
+	n := &ndjsonFile{baseName: baseName, rotate: rotate, rotateSize: rotateSize, compress: compress}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n, n.open()
 }
 
-type ConflictJSON struct {
-	ReferenceAnnouncement messageJSON   `json:"referenceAnnouncement"`
-	Conflicts             []messageJSON `json:"conflicts"`
+func (n *ndjsonFile) fileName() string {
+	name := n.baseName
+	if n.rotate > 0 || n.rotateSize > 0 {
+		name = name + "." + n.openedT.UTC().Format("20060102.150405.000")
+	}
+	name = name + ".ndjson"
+	if n.compress {
+		name = name + ".gz"
+	}
+	return name
 }
 
-func convertMessageForJSON(m message) messageJSON {
-	mesJSON := messageJSON{
-		Subnet:    m.subnet.String(),
-		OriginAS:  int(m.origin),
-		Timestamp: int(m.timestamp),
-		Aspath:    aspathtoIntSlice(m.aspath),
+// open closes the current file (if any) and starts a new one
+func (n *ndjsonFile) open() error {
+	n.closeFile()
+	n.openedT = time.Now()
+	name := n.fileName()
+	for (n.rotate > 0 || n.rotateSize > 0) && exists(name) { //a rotation must never overwrite the previous file
+		n.openedT = n.openedT.Add(time.Millisecond)
+		name = n.fileName()
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	n.f = f
+	n.written = 0
+	if n.compress {
+		n.gz = gzip.NewWriter(f)
+		n.w = bufio.NewWriter(n.gz)
+	} else {
+		n.w = bufio.NewWriter(f)
 	}
-	return mesJSON
+	return nil
 }
 
-func convertMessagesForJSON(m []message) []messageJSON {
-	messages := make([]messageJSON, len(m))
-	for i := 0; i < len(m); i++ {
-		messages[i] = convertMessageForJSON(m[i])
+func (n *ndjsonFile) closeFile() {
+	if n.f == nil {
+		return
+	}
+	err := n.w.Flush()
+	if err == nil && n.gz != nil {
+		err = n.gz.Close()
+	}
+	errClose := n.f.Close()
+	if err == nil {
+		err = errClose
 	}
-	return messages
+	if err != nil {
+		fmt.Println(Red("could not close ", n.f.Name(), ": ", err))
+	}
+	n.f = nil
+	n.gz = nil
+	n.w = nil
 }
 
-func prepareJSON(c conflicts) {
-	conflicts := convertMessagesForJSON(c.conflictingMessages)
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
 
-	conJSON := ConflictJSON{
-		ReferenceAnnouncement: convertMessageForJSON(c.referenceAnnouncement),
-		Conflicts:             conflicts,
+// write appends one record. Without compression every line is flushed immediately, so that the file can be followed
+func (n *ndjsonFile) write(record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.closed {
+		return nil
 	}
-	writeJson(conJSON)
+	if (n.rotate > 0 && time.Since(n.openedT) >= n.rotate) || (n.rotateSize > 0 && n.written >= n.rotateSize) {
+		if err = n.open(); err != nil {
+			n.closed = true //the previous file is already closed, all further records are dropped
+			return err
+		}
+	}
+	n.w.Write(data)
+	err = n.w.WriteByte('\n')
+	n.written = n.written + int64(len(data)) + 1
+	if err == nil && !n.compress {
+		err = n.w.Flush()
+	}
+	return err
 }
 
-func writeJson(c ConflictJSON) {
+// close writes the end of the current file. Records written afterwards are dropped
+func (n *ndjsonFile) close() {
+	if n == nil {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.closeFile()
+	n.closed = true
+}
 
-	data, err := json.Marshal(c)
+func writeJson(c detector.ConflictJSON) {
+	err := conflictsFile.write(conflictRecordJSON{SchemaVersion: conflictsSchemaVersion, Type: "conflict", ConflictJSON: c})
 	if err != nil {
-		fmt.Println(Red("Could not marshal the following conflict(s) as JSON: ", c))
+		fmt.Println(Red("Could not write to the JSON file for found conflicts: ", c))
+		fmt.Println(Red(err))
+		fmt.Println()
 	}
-	_, err = conflictsFile.Write(data)
+}
+
+func writeClosedConflictJson(c detector.ClosedConflictJSON) {
+	if closedConflictsFile == nil {
+		return
+	}
+	err := closedConflictsFile.write(closedConflictRecordJSON{SchemaVersion: conflictsSchemaVersion, Type: "conflictEnded", ClosedConflictJSON: c})
 	if err != nil {
-		fmt.Println(Red("Could not write to the JSON file for found conflicts: ", string(data)))
+		fmt.Println(Red("Could not write to the JSON file for closed conflicts: ", c))
 		fmt.Println(Red(err))
 		fmt.Println()
-	} else {
 	}
+}
 
+func writeAlertJson(a detector.AlertJSON) {
+	dispatchAlert(a)
+	err := conflictsFile.write(alertRecordJSON{SchemaVersion: conflictsSchemaVersion, Type: "alert", AlertJSON: a})
+	if err != nil {
+		fmt.Println(Red("Could not write alert to the JSON file for found conflicts: ", a))
+		fmt.Println(Red(err))
+		fmt.Println()
+	}
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// readNdjsonFiles returns the number of lines of each file of the base name, sorted by name
+func readNdjsonFiles(t *testing.T, baseName string) []int {
+	names, err := filepath.Glob(baseName + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	result := make([]int, 0, len(names))
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if filepath.Ext(name) == ".gz" {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gz
+		}
+		lines := 0
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines++
+		}
+		if err := scanner.Err(); err != nil {
+			t.Errorf("%s: %v", name, err) //e.g. a gzip stream which was not closed
+		}
+		f.Close()
+		result = append(result, lines)
+	}
+	return result
+}
+
+func TestNdjsonFileRotation(t *testing.T) {
+	record := map[string]int{"record": 1} //13 bytes with the newline
+	tests := []struct {
+		name       string
+		rotate     time.Duration
+		rotateSize int64
+		compress   bool
+		pause      time.Duration //between the records
+		want       []int         //lines per file
+	}{
+		{"no rotation", 0, 0, false, 0, []int{5}},
+		{"by size", 0, 26, false, 0, []int{2, 2, 1}},
+		{"every record, within the same millisecond", 0, 1, false, 0, []int{1, 1, 1, 1, 1}},
+		{"by size with gzip", 0, 26, true, 0, []int{2, 2, 1}},
+		{"by time", 30 * time.Millisecond, 0, false, 20 * time.Millisecond, []int{2, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseName := filepath.Join(t.TempDir(), "conflicts")
+			n, err := newNdjsonFile(baseName, tt.rotate, tt.rotateSize, tt.compress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 5; i++ {
+				if err := n.write(record); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(tt.pause)
+			}
+			n.close()
+			if err := n.write(record); err != nil {
+				t.Errorf("write after close: %v", err)
+			}
+			got := readNdjsonFiles(t, baseName)
+			if len(got) != len(tt.want) {
+				t.Fatalf("lines per file %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("lines per file %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestNdjsonFileRotationFails(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "output")
+	if err := os.Mkdir(directory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	n, err := newNdjsonFile(filepath.Join(directory, "conflicts"), 0, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.write(map[string]int{"record": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(directory); err != nil { //the next file can not be created
+		t.Fatal(err)
+	}
+	if err := n.write(map[string]int{"record": 2}); err == nil {
+		t.Error("the failed rotation was not reported")
+	}
+	if err := n.write(map[string]int{"record": 3}); err != nil {
+		t.Errorf("records after a failed rotation have to be dropped, got %v", err)
+	}
+	n.close()
+}
@@ -9,14 +9,22 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io"
+	"io/ioutil"
+	"math/rand"
 	"net"
-	"net/http"
+	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"bgp-hijack-detection/detector"
+	"github.com/gorilla/websocket"
 )
 
-var resp *http.Response
+var countReconnects int64
+var blindTime int64 //sum of all time windows in which we were not connected to RIS Live in nanoseconds, accessed atomically
 
 // risLive is a struct to hold basic data used in connecting to the RIS Live service and managing data output/collection for the calling client.
 type risLive struct {
@@ -24,6 +32,30 @@ type risLive struct {
 	ua      string
 	records int64
 	c       chan RisMessage
+
+	minBackoff   time.Duration //waiting time before the first reconnect. Doubled with every failed attempt up to maxBackoff
+	maxBackoff   time.Duration
+	pingInterval time.Duration //interval in which we send pings to RIS Live
+	readTimeout  time.Duration //if we do not receive anything for this long, the connection is considered dead
+
+	lastMessageT float64 //timestamp of the last message received, used to report the blind window after a reconnect
+}
+
+// risSubscription is the data part of a ris_subscribe message. Empty fields are not sent and hence not filtered by RIS Live
+type risSubscription struct {
+	Host         string   `json:"host,omitempty"`
+	Type         string   `json:"type,omitempty"`
+	Require      string   `json:"require,omitempty"`
+	Peer         string   `json:"peer,omitempty"`
+	Path         string   `json:"path,omitempty"`
+	Prefix       []string `json:"prefix,omitempty"`
+	MoreSpecific *bool    `json:"moreSpecific,omitempty"`
+	LessSpecific *bool    `json:"lessSpecific,omitempty"`
+}
+
+type risClientMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
 }
 
 // RisMessage is a single ris_message json message from the ris firehose.
@@ -34,17 +66,19 @@ type RisMessage struct {
 
 // RisMessageData is the BGP oriented content of the single RisMessage message type.
 type risMessageData struct {
-	Timestamp float64 `json:"timestamp"`
-	Peer      string  `json:"peer"`
-	PeerASN   string  `json:"peer_asn,omitempty"`
-	ID        string  `json:"id"`
-	//Host          string        `json:"host"`
-	Type         string        `json:"type"`
-	Path         []interface{} `json:"path"`
-	DigestedPath []uint32
+	Timestamp     float64       `json:"timestamp"`
+	Peer          string        `json:"peer"`
+	PeerASN       string        `json:"peer_asn,omitempty"`
+	ID            string        `json:"id"`
+	Host          string        `json:"host"` // the route collector, e.g. rrc00
+	Type          string        `json:"type"`
+	Path          []interface{} `json:"path"`
+	DigestedPath  []uint32
+	OriginIsASSet bool `json:"-"` // true if the last element of Path is an AS_SET
 	//Community     [][]int32          `json:"community"`
 	Origin      string   `json:"origin"`
 	Withdrawals []string `json:"withdrawals"`
+	State       string   `json:"state"` // only for RIS_PEER_STATE messages: "connected" or "down"
 
 	Announcements []*risAnnouncement `json:"announcements"`
 
@@ -53,7 +87,7 @@ type risMessageData struct {
 
 func (r *risMessageData) toString() string {
 	result := ""
-	result = result + "timestamp: " + strconv.Itoa(int(r.Timestamp)) + ", peer: " + r.Peer + " (AS = " + r.PeerASN + "), id: " + r.ID + ", type:" + r.Type + "\n"
+	result = result + "timestamp: " + strconv.Itoa(int(r.Timestamp)) + ", collector: " + r.Host + ", peer: " + r.Peer + " (AS = " + r.PeerASN + "), id: " + r.ID + ", type:" + r.Type + "\n"
 	result = result + "path: ["
 	for i := 0; i < len(r.DigestedPath); i++ {
 		result = result + strconv.Itoa(int(r.DigestedPath[i])) + " "
@@ -88,13 +122,20 @@ func NewRisLive(url, ua string, buffer int) *risLive {
 		ua:      ua,
 		records: 0,
 		c:       make(chan (RisMessage), buffer),
+
+		minBackoff:   time.Second,
+		maxBackoff:   2 * time.Minute,
+		pingInterval: 30 * time.Second,
+		readTimeout:  90 * time.Second,
 	}
 }
 
 func digestPath(m *risMessageData) error {
 	m.DigestedPath = []uint32{}
+	m.OriginIsASSet = false
 	for _, p := range m.Path {
 		var o uint32
+		m.OriginIsASSet = false
 		switch v := p.(type) {
 		case int:
 			o = uint32(v)
@@ -109,6 +150,7 @@ func digestPath(m *risMessageData) error {
 			for _, e := range listSlice {
 				m.DigestedPath = append(m.DigestedPath, uint32(e.(float64)))
 			}
+			m.OriginIsASSet = len(listSlice) > 0
 			continue
 		default:
 			return fmt.Errorf("failed to decode path element: %v as %v", p, reflect.TypeOf(p))
@@ -118,70 +160,266 @@ func digestPath(m *risMessageData) error {
 	return nil
 }
 
-// Listen connects to the RisLive service, parses the stream into structs
+// Listen connects to the RisLive WebSocket, subscribes to the needed messages, parses the stream into structs
 // and makes the data stream available for analysis through the RisLive.Chan channel.
+// If the connection fails, we reconnect with exponential backoff (and jitter) and report how long we were blind.
 func (r *risLive) Listen() {
-restart:
+	backoff := r.minBackoff
+	var disconnectedT time.Time
 	for {
-		var body io.ReadCloser
-
 		fmt.Println(Teal("Connecting..."))
-		client := &http.Client{}
-		req, err := http.NewRequest("GET", r.url, nil)
+		conn, err := r.connect()
 		if err != nil {
-			fmt.Println(Red("failed to create new request to ris-live: %v\n", err))
+			fmt.Println(Red("failed to connect to ris-live: ", err))
+			if disconnectedT.IsZero() {
+				disconnectedT = time.Now()
+			}
+			wait := withJitter(backoff)
+			fmt.Println(Red("next attempt to connect in ", wait))
+			time.Sleep(wait)
+			backoff = r.doubleBackoff(backoff)
+			continue
+		}
+		fmt.Println(Teal("Live Connection established..."))
+		lastMessageBeforeGap := r.lastMessageT
+		gapReported := disconnectedT.IsZero()
+
+		//only a connection which delivers messages counts as successful, RIS Live may also close it right after the subscription
+		err = r.receive(conn, func(firstMessageT float64) {
+			backoff = r.minBackoff
+			if !gapReported {
+				r.reportGap(disconnectedT, lastMessageBeforeGap, firstMessageT)
+				gapReported = true
+			}
+		})
+		conn.Close()
+		if gapReported { //otherwise we are still blind since the last disconnect
+			disconnectedT = time.Now()
 		}
-		//req.Header.Set("User-Agent", r.ua)
-		filter := "{\"type\": \"UPDATE\""
-		filter = filter + "}"
+		fmt.Println(Red("lost connection to ris-live: ", err))
+		time.Sleep(withJitter(backoff))
+		backoff = r.doubleBackoff(backoff)
+	}
+}
 
-		req.Header.Add("X-RIS-Subscribe", filter) //Some times the RIPE RIS livestream has problems when answering if there is this header present
-		//todo check if needed
+// doubleBackoff returns the waiting time before the next attempt to connect, at most maxBackoff
+func (r *risLive) doubleBackoff(backoff time.Duration) time.Duration {
+	backoff = backoff * 2
+	if backoff > r.maxBackoff {
+		return r.maxBackoff
+	}
+	return backoff
+}
 
-		//fmt.Println(Teal("Request Header: ", req.Header))
-		resp, err = client.Do(req)
+// connect opens the WebSocket and sends our subscriptions
+func (r *risLive) connect() (*websocket.Conn, error) {
+	u, err := url.Parse(r.url)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	if q.Get("client") == "" {
+		q.Set("client", r.ua)
+		u.RawQuery = q.Encode()
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: 30 * time.Second}
+	conn, _, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range r.subscriptions() {
+		err = conn.WriteJSON(risClientMessage{Type: "ris_subscribe", Data: sub})
 		if err != nil {
-			fmt.Println(Red("failed to open the http client for action: %v", err))
-			return
+			conn.Close()
+			return nil, err
 		}
-		//fmt.Println(Teal("Response Header: ", resp.Header))
-		//fmt.Println()
-		fmt.Println(Teal("Live Connection established..."))
+	}
+	return conn, nil
+}
 
-		defer resp.Body.Close()
-		body = resp.Body
-
-		dec := json.NewDecoder(body)
-		for {
-			var rm RisMessage
-			err := dec.Decode(&rm)
-			switch {
-			case err != nil && err != io.EOF:
-				fmt.Println(Red(err))
-				fmt.Println(Red("bad json content: \n", rm.Data))
-
-				continue restart
-			case err == io.EOF:
-				close(r.c)
-				return
+// subscriptions returns the ris_subscribe messages to send after connecting. We need the updates and the state of the peers.
+// The updates can be restricted with the subscription flags, so that only a slice of the firehose is received
+func (r *risLive) subscriptions() []risSubscription {
+	updates := risSubscription{
+		Host:    routeCollector,
+		Type:    "UPDATE",
+		Require: subscribeRequire,
+		Peer:    subscribePeer,
+		Path:    subscribePath,
+	}
+	if subscribePrefixes != "" {
+		for _, p := range strings.Split(subscribePrefixes, ",") {
+			updates.Prefix = append(updates.Prefix, strings.TrimSpace(p))
+		}
+		moreSpecific := subscribeMoreSpecific
+		lessSpecific := subscribeLessSpecific
+		updates.MoreSpecific = &moreSpecific
+		updates.LessSpecific = &lessSpecific
+	}
+	peerState := risSubscription{
+		Host: routeCollector,
+		Type: "RIS_PEER_STATE",
+		Peer: subscribePeer,
+	}
+	return []risSubscription{updates, peerState}
+}
+
+// subscriptionFile is the content of the file given with -subscriptionfile
+type subscriptionFile struct {
+	Subscription *risSubscription `json:"subscription"`
+}
+
+// readSubscriptionFile sets the subscription options from the "subscription" section of a JSON file. Options which were
+// given on the command line (setOnCommandLine, by flag name) take precedence over the file
+func readSubscriptionFile(fileName string, setOnCommandLine map[string]bool) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	var file subscriptionFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	sub := file.Subscription
+	if sub == nil {
+		return fmt.Errorf("no \"subscription\" section in %s", fileName)
+	}
+	if sub.Type != "" {
+		return fmt.Errorf("\"type\" can not be set, the updates and the state of the peers are always subscribed")
+	}
+	if !setOnCommandLine["routecollector"] {
+		routeCollector = sub.Host
+	}
+	if !setOnCommandLine["subscribepeer"] {
+		subscribePeer = sub.Peer
+	}
+	if !setOnCommandLine["subscribeprefix"] {
+		subscribePrefixes = strings.Join(sub.Prefix, ",")
+	}
+	if !setOnCommandLine["morespecific"] && sub.MoreSpecific != nil {
+		subscribeMoreSpecific = *sub.MoreSpecific
+	}
+	if !setOnCommandLine["lessspecific"] && sub.LessSpecific != nil {
+		subscribeLessSpecific = *sub.LessSpecific
+	}
+	if !setOnCommandLine["subscribepath"] {
+		subscribePath = sub.Path
+	}
+	if !setOnCommandLine["subscriberequire"] {
+		subscribeRequire = sub.Require
+	}
+	return nil
+}
+
+// receive reads messages until the connection fails. onFirst is called with the timestamp of the first BGP message received
+func (r *risLive) receive(conn *websocket.Conn, onFirst func(float64)) error {
+	conn.SetReadDeadline(time.Now().Add(r.readTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(r.readTimeout))
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go r.ping(conn, done)
+
+	first := true
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(r.readTimeout))
+
+		var rm RisMessage
+		err = json.Unmarshal(data, &rm)
+		if err != nil {
+			countParseError("ris_json")
+			fmt.Println(Red(err))
+			fmt.Println(Red("bad json content: \n", string(data)))
+			continue
+		}
+		switch rm.Type {
+		case "ris_message":
+		case "ris_error":
+			fmt.Println(Red("ris-live reported an error: ", string(data)))
+			continue
+		default: //e.g. pong
+			continue
+		}
+		if rm.Data == nil {
+			continue
+		}
+		if recorder != nil {
+			recorder.write(data)
+		}
+		err = digestPath(rm.Data)
+		if err != nil {
+			countParseError("ris_path")
+			fmt.Printf(Red("decoding the message data path(%v) failed: %v\n", rm.Data.Path, err))
+		}
+		if first {
+			onFirst(rm.Data.Timestamp)
+			first = false
+		}
+		r.lastMessageT = rm.Data.Timestamp
+		r.records++
+		r.c <- rm
+		//the send blocks while the buffer is full. This time does not count as silence of RIS Live
+		conn.SetReadDeadline(time.Now().Add(r.readTimeout))
+	}
+}
+
+// ping sends a RIS Live ping and a WebSocket ping in every interval until done is closed
+func (r *risLive) ping(conn *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(r.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+			if err == nil {
+				err = conn.WriteJSON(risClientMessage{Type: "ping"})
 			}
-			err = digestPath(rm.Data)
 			if err != nil {
-				fmt.Printf(Red("decoding the message data path(%v) failed: %v\n", rm.Data.Path, err))
+				fmt.Println(Red("could not send ping to ris-live: ", err))
+				return
 			}
-			r.records++
-			r.c <- rm
 		}
 	}
 }
 
+// reportGap prints how long we were not connected and which time window of BGP messages we missed
+func (r *risLive) reportGap(disconnectedT time.Time, lastMessageBeforeGap float64, firstMessageAfterGap float64) {
+	atomic.AddInt64(&countReconnects, 1)
+	gap := time.Since(disconnectedT)
+	atomic.AddInt64(&blindTime, int64(gap))
+	result := "reconnected to ris-live after " + gap.Round(time.Millisecond).String()
+	if lastMessageBeforeGap > 0 && firstMessageAfterGap > lastMessageBeforeGap {
+		blindWindow := time.Duration((firstMessageAfterGap - lastMessageBeforeGap) * float64(time.Second))
+		result = result + ". Blind window: " + blindWindow.Round(time.Millisecond).String() +
+			" (last message before at " + time.Unix(int64(lastMessageBeforeGap), 0).UTC().String() +
+			", first message after at " + time.Unix(int64(firstMessageAfterGap), 0).UTC().String() + ")"
+	}
+	fmt.Println(Red(result))
+}
+
+// withJitter returns a random duration between d/2 and d, so that many clients do not reconnect at the same time
+func withJitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
 // Get collects messages from the RisLive.Chan channel
 func (r *risLive) Get() *risMessageData {
 	for rm := range r.c {
 
 		rmd := rm.Data
 
-		if rmd.Type == "UPDATE" {
+		if rmd.Type == "UPDATE" || rmd.Type == "RIS_PEER_STATE" {
 			return rmd
 
 		}
@@ -192,56 +430,66 @@ func (r *risLive) Get() *risMessageData {
 func handle(r *risMessageData) {
 	//fmt.Println(r.toString())
 
-	var m message
-	m.timestamp = uint32(r.Timestamp)
+	timestamp := uint32(r.Timestamp)
 	peerip := r.Peer
 	peerAs, err := strconv.Atoi(r.PeerASN)
 	if err != nil {
 		fmt.Println(Red("Could not parse PeerASN as string to int"))
 	}
-	m.peerID = findPeerIDbyIP(peerip, uint32(peerAs))
+	peerID := det.Peer(peerip, uint32(peerAs), r.Host)
+
+	if r.Type == "RIS_PEER_STATE" {
+		if r.State != "connected" { //the session of the peer to the route collector went down, all of its routes are gone
+			det.FlushPeer(peerID, timestamp)
+		}
+		return
+	}
 
 	complLength := len(r.Withdrawals)
 	if len(r.Announcements) > 0 {
 		complLength = complLength + len(r.Announcements[0].Prefixes)
 	}
 	for i := 0; i < complLength; i++ {
-		var ipnet *net.IPNet
-		var err error
 		if i < len(r.Withdrawals) {
-			m.isAnnouncement = false
-			_, ipnet, err = net.ParseCIDR(r.Withdrawals[i])
-		} else {
-			m.isAnnouncement = true
-			_, ipnet, err = net.ParseCIDR(r.Announcements[0].Prefixes[i-len(r.Withdrawals)])
-			m.aspath = r.DigestedPath
-			if len(r.DigestedPath) > 0 {
-				m.origin = r.DigestedPath[len(r.DigestedPath)-1]
-			} else {
-				fmt.Println(Red("Digested Path length was 0!"))
-				fmt.Println(Red(r.toString()))
-				return
+			_, ipnet, err := net.ParseCIDR(r.Withdrawals[i])
+			if err != nil {
+				fmt.Println(Red("Could not parse to subnet", err))
+				continue
 			}
+			withdraw(detector.Withdrawal{Subnet: *ipnet, Peer: peerID, Timestamp: timestamp})
+			continue
 		}
+		_, ipnet, err := net.ParseCIDR(r.Announcements[0].Prefixes[i-len(r.Withdrawals)])
 		if err != nil {
 			fmt.Println(Red("Could not parse to subnet", err))
+			continue
 		}
-		m.subnet = *ipnet
-		if m.subnet.IP.To4() != nil {
-			m.subnetAsBits = convertIPtoBits(m.subnet)
-
-			insertAndFindConflicts(m, true)
-
+		if len(r.DigestedPath) == 0 {
+			fmt.Println(Red("Digested Path length was 0!"))
+			fmt.Println(Red(r.toString()))
+			return
 		}
+		announce(detector.Announcement{
+			Subnet:        *ipnet,
+			Peer:          peerID,
+			Timestamp:     timestamp,
+			Origin:        r.DigestedPath[len(r.DigestedPath)-1],
+			ASPath:        r.DigestedPath,
+			OriginIsASSet: r.OriginIsASSet,
+		}, true)
 	}
 }
 
 func runLivestream() {
 	r := NewRisLive(liveStream, risClient, buffer)
+	setInputSource("rislive", func() int { return len(r.c) })
 	go r.Listen()
 
 	for true {
 		result := r.Get()
+		if result == nil {
+			return
+		}
 		if len(result.Announcements) > 1 {
 			if result.Announcements[0].Prefixes[0] != result.Announcements[1].Prefixes[0] {
 				fmt.Println(Red("we have received a message with multiple announcements (regarding json format) with two different values as first prefix: \n", result.toString()))
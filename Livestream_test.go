@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// risLiveServer is a local stand-in for RIS Live. handle is called for every accepted connection (counted from 1)
+type risLiveServer struct {
+	*httptest.Server
+	connections int32
+	pings       int32 //RIS Live pings ({"type": "ping"}) received
+	lock        sync.Mutex
+	subscribed  []risClientMessage
+}
+
+func newRisLiveServer(t *testing.T, handle func(s *risLiveServer, n int, conn *websocket.Conn)) *risLiveServer {
+	s := &risLiveServer{}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		n := int(atomic.AddInt32(&s.connections, 1))
+		s.readSubscriptions(t, conn)
+		handle(s, n, conn)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// readSubscriptions reads the two ris_subscribe messages the client sends after connecting
+func (s *risLiveServer) readSubscriptions(t *testing.T, conn *websocket.Conn) {
+	for i := 0; i < 2; i++ {
+		var m risClientMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Error(err)
+			return
+		}
+		s.lock.Lock()
+		s.subscribed = append(s.subscribed, m)
+		s.lock.Unlock()
+	}
+}
+
+// readUntilClosed reads until the connection is closed. WebSocket pings are answered by the default handler while reading
+func (s *risLiveServer) readUntilClosed(conn *websocket.Conn) {
+	for {
+		var m risClientMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			return
+		}
+		if m.Type == "ping" {
+			atomic.AddInt32(&s.pings, 1)
+			conn.WriteJSON(risClientMessage{Type: "pong"})
+		}
+	}
+}
+
+func (s *risLiveServer) url() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func risUpdate(timestamp float64, prefix string, path ...interface{}) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type": "ris_message",
+		"data": map[string]interface{}{
+			"timestamp":     timestamp,
+			"peer":          "192.0.2.1",
+			"peer_asn":      "64496",
+			"host":          "rrc00",
+			"type":          "UPDATE",
+			"path":          path,
+			"announcements": []map[string]interface{}{{"next_hop": "192.0.2.1", "prefixes": []string{prefix}}},
+		},
+	})
+	return data
+}
+
+func newTestRisLive(url string, buffer int) *risLive {
+	r := NewRisLive(url, "test", buffer)
+	r.minBackoff = 10 * time.Millisecond
+	r.maxBackoff = 50 * time.Millisecond
+	r.pingInterval = time.Hour
+	r.readTimeout = time.Second
+	return r
+}
+
+func getWithTimeout(t *testing.T, r *risLive) *risMessageData {
+	select {
+	case rm := <-r.c:
+		return rm.Data
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestRisLiveSubscribe(t *testing.T) {
+	routeCollector = "rrc00"
+	subscribePrefixes = "192.0.2.0/24"
+	defer func() { routeCollector, subscribePrefixes = "", "" }()
+
+	s := newRisLiveServer(t, func(s *risLiveServer, n int, conn *websocket.Conn) {
+		conn.WriteMessage(websocket.TextMessage, risUpdate(1600000000, "192.0.2.0/24", 64496, 3356, []interface{}{64500, 64501}))
+		s.readUntilClosed(conn)
+	})
+	r := newTestRisLive(s.url(), 10)
+	conn, err := r.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.receive(conn, func(float64) {})
+	defer conn.Close()
+
+	m := getWithTimeout(t, r)
+	if len(m.DigestedPath) != 4 || m.DigestedPath[3] != 64501 || !m.OriginIsASSet {
+		t.Errorf("path %v (AS_SET origin: %v), want [64496 3356 64500 64501] ending in an AS_SET", m.DigestedPath, m.OriginIsASSet)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.subscribed) != 2 {
+		t.Fatalf("got %d subscriptions, want 2", len(s.subscribed))
+	}
+	types := []string{"UPDATE", "RIS_PEER_STATE"}
+	for i, m := range s.subscribed {
+		data, _ := json.Marshal(m.Data)
+		var sub risSubscription
+		json.Unmarshal(data, &sub)
+		if m.Type != "ris_subscribe" || sub.Type != types[i] || sub.Host != "rrc00" {
+			t.Errorf("subscription %d: %s %s", i, m.Type, data)
+		}
+	}
+	data, _ := json.Marshal(s.subscribed[0].Data)
+	if !strings.Contains(string(data), `"prefix":["192.0.2.0/24"]`) {
+		t.Errorf("the prefix filter is missing in %s", data)
+	}
+}
+
+func TestRisLivePingKeepsConnectionAlive(t *testing.T) {
+	s := newRisLiveServer(t, func(s *risLiveServer, n int, conn *websocket.Conn) {
+		s.readUntilClosed(conn) //no BGP messages at all, only the answers to the pings
+	})
+	r := newTestRisLive(s.url(), 10)
+	r.pingInterval = 50 * time.Millisecond
+	r.readTimeout = 200 * time.Millisecond
+	conn, err := r.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() { result <- r.receive(conn, func(float64) {}) }()
+
+	select {
+	case err := <-result:
+		t.Fatalf("connection closed although the pings were answered: %v", err)
+	case <-time.After(time.Second):
+	}
+	if atomic.LoadInt32(&s.pings) == 0 {
+		t.Error("no RIS Live ping received")
+	}
+	conn.Close()
+	<-result
+}
+
+func TestRisLiveReconnectsAndReportsGap(t *testing.T) {
+	reconnectsBefore := atomic.LoadInt64(&countReconnects)
+	s := newRisLiveServer(t, func(s *risLiveServer, n int, conn *websocket.Conn) {
+		if n == 1 { //the first connection breaks after one message
+			conn.WriteMessage(websocket.TextMessage, risUpdate(1600000000, "192.0.2.0/24", 64496, 64500))
+			time.Sleep(50 * time.Millisecond)
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, risUpdate(1600000060, "198.51.100.0/24", 64496, 64501))
+		s.readUntilClosed(conn)
+	})
+	r := newTestRisLive(s.url(), 10)
+	go r.Listen()
+
+	first := getWithTimeout(t, r)
+	second := getWithTimeout(t, r)
+	if first.Timestamp != 1600000000 || second.Timestamp != 1600000060 {
+		t.Errorf("got the messages of %v and %v", first.Timestamp, second.Timestamp)
+	}
+	if got := atomic.LoadInt32(&s.connections); got != 2 {
+		t.Errorf("connections = %d, want 2", got)
+	}
+	if got := atomic.LoadInt64(&countReconnects) - reconnectsBefore; got != 1 {
+		t.Errorf("reported gaps = %d, want 1", got)
+	}
+}
+
+func TestRisLiveGapIncludesConnectionsWithoutMessages(t *testing.T) {
+	reconnectsBefore := atomic.LoadInt64(&countReconnects)
+	blindTimeBefore := atomic.LoadInt64(&blindTime)
+	s := newRisLiveServer(t, func(s *risLiveServer, n int, conn *websocket.Conn) {
+		switch n {
+		case 1:
+			conn.WriteMessage(websocket.TextMessage, risUpdate(1600000000, "192.0.2.0/24", 64496, 64500))
+			time.Sleep(50 * time.Millisecond)
+		case 2: //accepted, but closed before any message
+			time.Sleep(300 * time.Millisecond)
+		default:
+			conn.WriteMessage(websocket.TextMessage, risUpdate(1600000060, "198.51.100.0/24", 64496, 64501))
+			s.readUntilClosed(conn)
+		}
+	})
+	r := newTestRisLive(s.url(), 10)
+	go r.Listen()
+
+	getWithTimeout(t, r)
+	getWithTimeout(t, r)
+	if got := atomic.LoadInt64(&countReconnects) - reconnectsBefore; got != 1 {
+		t.Errorf("reported gaps = %d, want 1", got)
+	}
+	if got := time.Duration(atomic.LoadInt64(&blindTime) - blindTimeBefore); got < 300*time.Millisecond {
+		t.Errorf("blind time = %v, want the time since the first connection broke", got)
+	}
+}
+
+func TestRisLiveFullBufferDoesNotTimeOut(t *testing.T) {
+	s := newRisLiveServer(t, func(s *risLiveServer, n int, conn *websocket.Conn) {
+		conn.WriteMessage(websocket.TextMessage, risUpdate(1600000000, "192.0.2.0/24", 64496, 64500))
+		conn.WriteMessage(websocket.TextMessage, risUpdate(1600000001, "192.0.2.0/24", 64496, 64500))
+		time.Sleep(100 * time.Millisecond) //the third message has to be read from the connection again
+		conn.WriteMessage(websocket.TextMessage, risUpdate(1600000002, "192.0.2.0/24", 64496, 64500))
+		s.readUntilClosed(conn)
+	})
+	r := newTestRisLive(s.url(), 1)
+	r.readTimeout = 200 * time.Millisecond
+	conn, err := r.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	result := make(chan error, 1)
+	go func() { result <- r.receive(conn, func(float64) {}) }()
+
+	time.Sleep(3 * r.readTimeout) //nobody reads, so the client waits for space in the buffer
+	for i := 0; i < 3; i++ {
+		select {
+		case rm := <-r.c:
+			if rm.Data.Timestamp != float64(1600000000+i) {
+				t.Errorf("message %d has timestamp %v", i, rm.Data.Timestamp)
+			}
+		case err := <-result:
+			t.Fatalf("the time waiting for the buffer counted as silence of RIS Live: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message received")
+		}
+	}
+}
+
+func TestReadSubscriptionFile(t *testing.T) {
+	defer func() {
+		routeCollector, subscribePeer, subscribePrefixes, subscribePath, subscribeRequire = "", "", "", "", ""
+		subscribeMoreSpecific, subscribeLessSpecific = true, false
+	}()
+	fileName := filepath.Join(t.TempDir(), "subscription.json")
+	err := ioutil.WriteFile(fileName, []byte(`{"subscription": {"host": "rrc00", "peer": "192.0.2.1", "prefix": ["192.0.2.0/24", "2001:db8::/32"],
+		"moreSpecific": false, "lessSpecific": true, "path": "64496$", "require": "announcements"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routeCollector = "rrc01" //given on the command line
+	if err := readSubscriptionFile(fileName, map[string]bool{"routecollector": true}); err != nil {
+		t.Fatal(err)
+	}
+	if routeCollector != "rrc01" || subscribePeer != "192.0.2.1" || subscribePrefixes != "192.0.2.0/24,2001:db8::/32" ||
+		subscribeMoreSpecific || !subscribeLessSpecific || subscribePath != "64496$" || subscribeRequire != "announcements" {
+		t.Errorf("got %s %s %s %v %v %s %s", routeCollector, subscribePeer, subscribePrefixes, subscribeMoreSpecific,
+			subscribeLessSpecific, subscribePath, subscribeRequire)
+	}
+
+	if err := ioutil.WriteFile(fileName, []byte(`{"subscription": {"type": "UPDATE"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := readSubscriptionFile(fileName, nil); err == nil {
+		t.Error("a subscription with a type was accepted")
+	}
+}
@@ -3,33 +3,63 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime/pprof"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"bgp-hijack-detection/detector"
 )
 
 //input
 var inputDirectory string //  our initial Routing Information Base and update files
 var rib string
 var findConflictsInRib bool
-var prefixesFileName string
-var prefixesFile *os.File
+var watchlistFileName string
+var vrpFileName string
+var allowlistFileName string
+var rpkiInvalidOnly bool
+var fromString string
+var untilString string
+var fromT time.Time  //messages before this time are inserted, but do not trigger conflicts
+var untilT time.Time //we stop at the first message after this time
 
 //output
 var memProfileFile string
 var cpuProfileFile string
 var conflictsFileName string
-var conflictsFile *os.File
+var closedConflictsFileName string
+var conflictsRotate int
+var conflictsRotateSize int
+var conflictsGzip bool
 var originsFileName string
-var originsFile *os.File
+var recentFilesCounter = 1
 var verbose bool
 
+//snapshot
+var snapshotFileName string
+var snapshotInterval int
+var restoreFileName string
+
+//as metadata
+var delegatedFileNames string
+var as2orgFileName string
+var asMetadataCache string
+var asnLookup bool
+
+//alerts
+var alertSinksFileName string
+
+//http api
+var httpAddress string
+
 //live
 var liveMode bool
 var endLiveStream string
@@ -37,47 +67,149 @@ var liveStream string
 var risClient string
 var buffer int
 var writeInterval int
-
-//var routeCollector string
+var routeCollector string
+var subscribePeer string
+var subscribePrefixes string
+var subscribeMoreSpecific bool
+var subscribeLessSpecific bool
+var subscribePath string
+var subscribeRequire string
+var subscriptionFileName string
+
+//record and replay
+var recordDirectory string
+var recordRotate int
+var replayInput string
+var replaySpeed float64
+
+//bmp
+var bmpAddress string
+var bmpPostPolicy bool
+
+//bgp speaker
+var bgpListenAddress string
+var bgpLocalAS uint32
+var bgpRouterID string
+var bgpNeighborsString string
 
 //internal
 var flagsString string
-var ipv4T ipv4trieRoot
-var countInserted int
-var countInserted100000 int
+var det *detector.Detector //all inputs are inserted into this detector
 var startT time.Time
 var stopT time.Time
-var mutex *sync.Mutex
+var cleanupOnce sync.Once
+var stopRequested int32                    //set to 1 on SIGTERM. The goroutine inserting messages then stops at the next message
+var stopAcknowledged = make(chan struct{}) //closed by the goroutine inserting messages as soon as it stopped
+var stopAcknowledgedOnce sync.Once
 
-var countConflictTriggers int
-var countConflictTriggers1000 int
-var countConflicts int
+const stopTimeout = 10 * time.Second //how long we wait for the goroutine inserting messages to stop
 
 func parseFlags() {
 
 	//input
-	flag.StringVar(&inputDirectory, "input", "", "If specified, directory containing initial routing information files. Expected filenames: [rib|updates].YYYYMMDD.HHMM{.bz2|.gz}")
-	flag.StringVar(&rib, "rib", "", "If specified, we read the specified RIB and all following update files. If not specified the newest RIB in the input directory is used. Expected format: rib.YYYYMMDD.HHMM{.bz2|.gz}")
+	flag.StringVar(&inputDirectory, "input", "", "If specified, directory (or comma separated directories) containing routing information files of one or several collectors, also in the layout of the Routeviews and RIS archives. Expected filenames: [rib|bview|updates].YYYYMMDD.HHMM{.bz2|.gz}")
+	flag.StringVar(&rib, "rib", "", "If specified, we read the RIB of each collector at this time and all following update files. If not specified the newest RIB of each collector is used. Expected format: [rib|bview].YYYYMMDD.HHMM{.bz2|.gz}")
 	flag.BoolVar(&findConflictsInRib, "ribconflicts", false, "If set to true a specified RIB will directly be analysed for conflicts. If set to false (default) only updates (from updates files or from a live feed can trigger conflicts")
-	flag.StringVar(&prefixesFileName, "prefixesfile", "input/prefixes", "If specified, a file in which a number of prefixes is listed, for which a conflict needs to be printed out immediately.")
+	flag.StringVar(&watchlistFileName, "watchlist", "input/prefixes", "If specified, a watchlist of our own prefixes with expected origins, allowed neighbors, max length and tag, and of our own ASNs (.json, .yaml/.yml), or one prefix or ASN per line. Unexpected announcements raise alerts, conflicts involving these prefixes are printed immediately")
+	flag.StringVar(&watchlistFileName, "prefixesfile", "input/prefixes", "Deprecated name of -watchlist")
+	flag.StringVar(&vrpFileName, "vrpfile", "", "If specified, a file with Validated ROA Payloads (JSON or CSV export of rpki-client or Routinator). Announcements in conflicts are then validated with RPKI")
+	flag.StringVar(&allowlistFileName, "allowlist", "", "If specified, a file with known benign conflicts (prefix/origin sets, AS pairs, more specifics of an AS under a prefix), which are tagged or suppressed. Reloaded on SIGHUP")
+	flag.StringVar(&fromString, "from", "", "If specified, the RIB of each collector closest before this time (UTC) is read and only messages from this time on trigger conflicts. Formats: YYYYMMDD.HHMM, YYYY-MM-DDTHH:MM:SSZ, YYYY-MM-DD HH:MM, YYYY-MM-DD or unix timestamp")
+	flag.StringVar(&untilString, "until", "", "If specified, we stop at the first message with a timestamp after this time (UTC). Same formats as -from")
+	flag.BoolVar(&rpkiInvalidOnly, "rpkiinvalidonly", false, "If set to true only conflicts in which at least one announcement is RPKI-invalid are reported. Requires -vrpfile")
 
 	//output
 	flag.StringVar(&cpuProfileFile, "cpuprofile", "output/cp", "Specifies the file to which a CPU profile shall be written to")
 	flag.StringVar(&memProfileFile, "memprofile", "output/mp", "Specifies the file to which a memory profile shall be written to")
-	flag.StringVar(&conflictsFileName, "conflictsfile", "output/conflicts", "Specifies the file to which found results shall be written to (in Json)")
+	flag.StringVar(&conflictsFileName, "conflictsfile", "output/conflicts", "Specifies the file to which found results shall be written to (in NDJSON, .ndjson is appended)")
+	flag.StringVar(&closedConflictsFileName, "closedconflictsfile", "output/closedconflicts", "Specifies the file to which ended conflicts together with their duration shall be written to (in NDJSON, .ndjson is appended)")
+	flag.IntVar(&conflictsRotate, "conflictsrotate", 0, "If > 0, new conflicts files are started after this interval (in minutes)")
+	flag.IntVar(&conflictsRotateSize, "conflictsrotatesize", 0, "If > 0, new conflicts files are started as soon as a file reached this size (in MB, uncompressed)")
+	flag.BoolVar(&conflictsGzip, "conflictsgzip", false, "If true the conflicts files are compressed with gzip")
 	flag.StringVar(&originsFileName, "originsfile", "output/origins", "Specifies the file to which frequencies of origin ASes shall be written to (in CSV)")
 	flag.BoolVar(&verbose, "verbose", false, "If true we print out found conflicts directly. Defaults to false")
 	flag.IntVar(&writeInterval, "interval", 20, "Specifies the interval after which a new originsfile gets written")
 
+	//snapshot
+	flag.StringVar(&snapshotFileName, "snapshot", "", "If specified, the state (trie, peers and origin counters) is periodically written to this file, and once more when stopping")
+	flag.IntVar(&snapshotInterval, "snapshotinterval", 30, "Specifies the interval (in minutes) after which a new snapshot gets written")
+	flag.StringVar(&restoreFileName, "restore", "", "If specified, the state is restored from this snapshot file before any other input is read")
+
+	//as metadata
+	flag.StringVar(&delegatedFileNames, "delegated", "", "If specified, RIR delegated(-extended) statistics file (or comma separated files, also .gz or .bz2) from which registry, country and allocation date of the ASes are read")
+	flag.StringVar(&as2orgFileName, "as2org", "", "If specified, a CAIDA AS Organizations file (as-org2info.txt, also .gz or .bz2) from which the organisation names of the ASes are read")
+	flag.StringVar(&asMetadataCache, "asmetacache", "output/asmetadata", "Specifies the file in which the metadata of all ASes involved in conflicts is kept between runs")
+	flag.BoolVar(&asnLookup, "asnlookup", false, "If true ASes without metadata in the files are looked up over the network in the background")
+
+	//alerts
+	flag.StringVar(&alertSinksFileName, "alertsinks", "", "If specified, a JSON file configuring the sinks (webhook, syslog, smtp, exec) to which the alerts of the watchlist are delivered")
+
+	//http api
+	flag.StringVar(&httpAddress, "http", "", "If specified, we answer queries about the current routing state and conflicts with JSON on this address (e.g. :8080)")
+
 	//live
 	flag.BoolVar(&liveMode, "live", true, "Indicates if we work in live mode. If in Live mode, input stream has to be specified. If not in live mode, update file has to be specified. Defaults to true")
-	flag.StringVar(&liveStream, "stream", "https://ris-live.ripe.net/v1/stream/?format=json", "RIS Live firehose url")
+	flag.StringVar(&liveStream, "stream", "wss://ris-live.ripe.net/v1/ws/", "RIS Live WebSocket url")
 	flag.IntVar(&buffer, "buffer", 10000, "Max depth of Ris messages to queue.")
 	flag.StringVar(&risClient, "risclient", "Analysis tool for BGP Hijacks for Summer of Code project of BND", "RIS Live client description")
 	flag.StringVar(&endLiveStream, "endlive", "", "If specified, we end the livestream at this time. Expected format: YYYYMMDD.HHMM")
-	//flag.StringVar(&routeCollector, "routecollector", "", "If specified only use live stream data from collector with this ID. (expected format: rrcXX). If none is specified, all collectors are included.")
+	flag.StringVar(&routeCollector, "routecollector", "", "If specified only use live stream data from collector with this ID. (expected format: rrcXX). If none is specified, all collectors are included.")
+	flag.StringVar(&subscribePeer, "subscribepeer", "", "If specified only use live stream data from the peer with this IP address")
+	flag.StringVar(&subscribePrefixes, "subscribeprefix", "", "If specified only use live stream data for these prefixes (comma separated, e.g. 192.0.2.0/24,2001:db8::/32)")
+	flag.BoolVar(&subscribeMoreSpecific, "morespecific", true, "If true (default) also use live stream data for prefixes more specific than -subscribeprefix")
+	flag.BoolVar(&subscribeLessSpecific, "lessspecific", false, "If true also use live stream data for prefixes less specific than -subscribeprefix")
+	flag.StringVar(&subscribePath, "subscribepath", "", "If specified only use live stream data whose AS path matches (RIS Live syntax, e.g. \"64496$\" for the origin or \"^64497,64498\")")
+	flag.StringVar(&subscribeRequire, "subscriberequire", "", "If specified only use live stream data that contains this key (e.g. \"withdrawals\")")
+	flag.StringVar(&subscriptionFileName, "subscriptionfile", "", "If specified, the subscription options above are read from the \"subscription\" section of this JSON file. Options given on the command line take precedence")
+
+	//record and replay
+	flag.StringVar(&recordDirectory, "record", "", "If specified, all raw messages of the livestream are recorded to gzip compressed NDJSON files in this directory")
+	flag.IntVar(&recordRotate, "recordrotate", 60, "Specifies the interval (in minutes) after which a new recording file is started")
+	flag.StringVar(&replayInput, "replay", "", "If specified, recorded livestream messages are read from this file, comma separated files or directory instead of connecting to RIS Live")
+	flag.Float64Var(&replaySpeed, "replayspeed", 0, "Speed multiplier for -replay relative to the original timestamps (e.g. 10 replays one hour in 6 minutes). 0 (default) replays as fast as possible")
+
+	//bmp
+	flag.StringVar(&bmpAddress, "bmp", "", "If specified, we listen on this address (e.g. :11019) for BMP sessions of routers instead of connecting to RIS Live")
+	flag.BoolVar(&bmpPostPolicy, "bmppostpolicy", false, "If true the post-policy Adj-RIB-In of the BMP peers is used. If false (default) the pre-policy Adj-RIB-In is used")
+
+	//bgp speaker
+	var localAS uint
+	flag.StringVar(&bgpListenAddress, "bgplisten", "", "If specified, we listen on this address (e.g. :179) for BGP sessions of the neighbors in -bgpneighbors instead of connecting to RIS Live. No routes are ever advertised")
+	flag.UintVar(&localAS, "bgpas", 0, "Our AS for the BGP sessions (use the AS of the neighbor for iBGP)")
+	flag.StringVar(&bgpRouterID, "bgprouterid", "", "Our BGP identifier (an IPv4 address) for the BGP sessions")
+	flag.StringVar(&bgpNeighborsString, "bgpneighbors", "", "Neighbors which may open a BGP session, comma separated in the format ip=as (e.g. 192.0.2.1=64496,2001:db8::1=64496). Without =as any AS is accepted")
 
 	flag.Parse()
+	bgpLocalAS = uint32(localAS)
+
+	if subscriptionFileName != "" {
+		setOnCommandLine := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { setOnCommandLine[f.Name] = true })
+		if err := readSubscriptionFile(subscriptionFileName, setOnCommandLine); err != nil {
+			fmt.Println(Red("-subscriptionfile: ", err))
+			os.Exit(1)
+		}
+	}
+
+	var err error
+	if fromString != "" {
+		fromT, err = parseTime(fromString)
+		if err != nil {
+			fmt.Println(Red("-from: ", err))
+			os.Exit(1)
+		}
+	}
+	if untilString != "" {
+		untilT, err = parseTime(untilString)
+		if err != nil {
+			fmt.Println(Red("-until: ", err))
+			os.Exit(1)
+		}
+	}
+	if !fromT.IsZero() && !untilT.IsZero() && untilT.Before(fromT) {
+		fmt.Println(Red("-until is before -from"))
+		os.Exit(1)
+	}
 
 	if endLiveStream != "" {
 		s := strings.Split(endLiveStream, ".")
@@ -101,29 +233,87 @@ func parseFlags() {
 		"Flags parsed:\n input = " + inputDirectory +
 		",\n rib = " + rib +
 		",\n findConflicts = " + strconv.FormatBool(findConflictsInRib) +
-		",\n prefixesFile = " + prefixesFileName +
+		",\n watchlist = " + watchlistFileName +
+		",\n vrpFile = " + vrpFileName +
+		",\n rpkiInvalidOnly = " + strconv.FormatBool(rpkiInvalidOnly) +
+		",\n allowlist = " + allowlistFileName +
+		",\n from = " + fromString +
+		",\n until = " + untilString +
 		"\n" +
 		"\n cpuprofile = " + cpuProfileFile +
 		",\n memprofile = " + memProfileFile +
 		",\n verbose = " + strconv.FormatBool(verbose) +
 		",\n conflictsFile = " + conflictsFileName +
+		",\n closedConflictsFile = " + closedConflictsFileName +
+		",\n conflictsrotate = " + strconv.Itoa(conflictsRotate) +
+		",\n conflictsrotatesize = " + strconv.Itoa(conflictsRotateSize) +
+		",\n conflictsgzip = " + strconv.FormatBool(conflictsGzip) +
 		",\n originsFile = " + originsFileName +
 		",\n interval = " + strconv.Itoa(writeInterval) +
 		",\n" +
+		"\n snapshot = " + snapshotFileName +
+		",\n snapshotinterval = " + strconv.Itoa(snapshotInterval) +
+		",\n restore = " + restoreFileName +
+		",\n" +
+		"\n delegated = " + delegatedFileNames +
+		",\n as2org = " + as2orgFileName +
+		",\n asmetacache = " + asMetadataCache +
+		",\n asnlookup = " + strconv.FormatBool(asnLookup) +
+		",\n" +
+		"\n alertsinks = " + alertSinksFileName +
+		",\n" +
+		"\n http = " + httpAddress +
+		",\n" +
 		"\n live = " + strconv.FormatBool(liveMode) +
 		",\n endlive = " + endLiveStream +
 		",\n stream = " + liveStream +
 		",\n risclient = " + risClient +
-		//", routecollector= " + routeCollector+
-		",\n buffer = " + strconv.Itoa(buffer) + "\n" +
+		",\n routecollector = " + routeCollector +
+		",\n subscribepeer = " + subscribePeer +
+		",\n subscribeprefix = " + subscribePrefixes +
+		",\n morespecific = " + strconv.FormatBool(subscribeMoreSpecific) +
+		",\n lessspecific = " + strconv.FormatBool(subscribeLessSpecific) +
+		",\n subscribepath = " + subscribePath +
+		",\n subscriberequire = " + subscribeRequire +
+		",\n subscriptionfile = " + subscriptionFileName +
+		",\n buffer = " + strconv.Itoa(buffer) +
+		",\n" +
+		"\n record = " + recordDirectory +
+		",\n recordrotate = " + strconv.Itoa(recordRotate) +
+		",\n replay = " + replayInput +
+		",\n replayspeed = " + strconv.FormatFloat(replaySpeed, 'f', -1, 64) +
+		",\n" +
+		"\n bmp = " + bmpAddress +
+		",\n bmppostpolicy = " + strconv.FormatBool(bmpPostPolicy) +
+		",\n" +
+		"\n bgplisten = " + bgpListenAddress +
+		",\n bgpas = " + strconv.Itoa(int(bgpLocalAS)) +
+		",\n bgprouterid = " + bgpRouterID +
+		",\n bgpneighbors = " + bgpNeighborsString + "\n" +
 		"----------------------------------------------------------------------------------------------------------------------------------\n"
 
 	fmt.Println(Teal(flagsString))
 }
 
+// cleanup writes all final outputs and ends the program. Only the first call does something, all further calls block until the program ended
 func cleanup() {
+	cleanupOnce.Do(doCleanup)
+	select {}
+}
+
+func doCleanup() {
 	fmt.Println(Teal("\n\n----------------------------------------------------------------------------------------------------------------------------------"))
 	fmt.Println(Teal("Stopping of program was initiated\n"))
+	if recorder != nil {
+		recorder.close()
+	}
+	det.WriteSnapshot()
+	if err := det.WriteASMetadataCache(); err != nil {
+		fmt.Println(Red("could not write AS metadata cache: ", err))
+	}
+	conflictsFile.close()
+	closedConflictsFile.close()
+	closeAlertSinks(10 * time.Second)
 	//PrintMemUsage()
 
 	if memProfileFile != "" {
@@ -146,17 +336,38 @@ func cleanup() {
 	if cpuProfileFile != "" {
 		pprof.StopCPUProfile()
 	}
+	stats := det.Statistics()
 	fmt.Println(Teal("Program ran from ", startT, " till ", time.Now()))
-	fmt.Println(Green("Inserted messages in total: ", countInserted))
-	fmt.Println(Yellow("Peers added in total: ", highestPeerId))
-	fmt.Println(White("Messages triggering conflicts: " + strconv.Itoa(countConflictTriggers)))
-	fmt.Println(White("Conflicts found: " + strconv.Itoa(countConflicts)))
+	fmt.Println(Green("Inserted messages in total: ", stats.Inserted))
+	fmt.Println(Yellow("Peers added in total: ", stats.Peers))
+	fmt.Println(Yellow("Peer sessions gone down: ", stats.PeerDowns, " (announcements removed: ", stats.FlushedRoutes, ")"))
+	fmt.Println(White("Messages triggering conflicts: " + strconv.Itoa(stats.ConflictTriggers)))
+	fmt.Println(White("Conflicts found: " + strconv.Itoa(stats.Conflicts)))
+	if allowlistFileName != "" {
+		fmt.Println(White("Conflicts matching the allowlist: " + strconv.Itoa(stats.AllowlistedConflicts)))
+	}
+	if bgpListenAddress != "" {
+		fmt.Println(White("BGP sessions: ", countBGPSessions, ", BGP updates received: ", countBGPUpdates))
+	} else if bmpAddress != "" {
+		fmt.Println(White("BMP sessions: ", countBMPSessions, ", BMP messages received: ", countBMPMessages))
+	} else if replayInput != "" {
+		fmt.Println(White("Messages replayed: ", countReplayed))
+	} else if liveMode {
+		fmt.Println(White("Reconnects to RIS Live: ", atomic.LoadInt64(&countReconnects), " (time without connection: ", time.Duration(atomic.LoadInt64(&blindTime)).Round(time.Second), ")"))
+	}
+	if stats.Alerts > 0 {
+		fmt.Println(Magenta("Watchlist alerts: " + strconv.Itoa(stats.Alerts)))
+	}
+	if stats.ASNAlerts > 0 {
+		fmt.Println(Magenta("ASN watchlist alerts: " + strconv.Itoa(stats.ASNAlerts)))
+	}
+	fmt.Println(White("Conflicts ended: " + strconv.Itoa(stats.ClosedConflicts) + ", still ongoing: " + strconv.Itoa(stats.ActiveConflicts)))
 
 	fmt.Println()
-	printShortSummary()
+	det.PrintShortSummary()
 	fmt.Println()
 	fmt.Println(Teal("Writing summary file..."))
-	writeOriginFrequencies()
+	writeOriginsFile(originsFileName+".csv", det.WriteOriginFrequencies)
 	os.Exit(1)
 
 }
@@ -164,82 +375,151 @@ func cleanup() {
 func initialize() {
 	fmt.Println(Teal("Initializing IDP BGP Hijack Detection"))
 
-	ourPeers = make([]peer, 0)
-	peermapByID = make(map[uint16]*peer)
-	peermapByIP = make(map[string]*peer)
-	originCounters = make(map[uint32]*originCounter)
-	ipv4T = ipv4trieRoot{
-		childZero: &ipv4trie{value: 0, representedNet: []uint8{0}},
-		childOne:  &ipv4trie{value: 1, representedNet: []uint8{1}},
+	if watchlistFileName == "" {
+		fmt.Println(Teal("No watchlist provided."))
+	}
+	if vrpFileName == "" {
+		fmt.Println(Teal("No VRP file provided. Continuing without RPKI route origin validation."))
 	}
-	readSpecialPrefixes()
+	var delegatedFiles []string
+	if delegatedFileNames != "" {
+		delegatedFiles = strings.Split(delegatedFileNames, ",")
+	}
+	det = detector.New(detector.Options{
+		WatchlistFile:    watchlistFileName,
+		VRPFile:          vrpFileName,
+		RPKIInvalidOnly:  rpkiInvalidOnly,
+		SnapshotFile:     snapshotFileName,
+		SnapshotInterval: time.Minute * time.Duration(snapshotInterval),
+		DelegatedFiles:   delegatedFiles,
+		AS2OrgFile:       as2orgFileName,
+		ASMetadataCache:  asMetadataCache,
+		LookupASNs:       asnLookup,
+		AllowlistFile:    allowlistFileName,
+		Verbose:          verbose,
+		OnConflict:       writeJson,
+		OnConflictEnded:  writeClosedConflictJson,
+		OnAlert:          writeAlertJson,
+	})
 	fmt.Println(Teal("Initialization finished at ", time.Now()))
 
 }
 
+// beforeInsert ends the program if a stop was requested, or if the end of -until or -endlive is reached.
+// It returns false for messages before -from, as they only build up the state and may not trigger conflicts
+func beforeInsert(timestamp uint32) bool {
+	if atomic.LoadInt32(&stopRequested) == 1 {
+		stopAcknowledgedOnce.Do(func() { close(stopAcknowledged) })
+		cleanup()
+	}
+	if !untilT.IsZero() && int64(timestamp) > untilT.Unix() { //the time window given with -until ended
+		fmt.Println(Teal("Reached the end of the time window (", untilT, ")"))
+		cleanup()
+	}
+	if !stopT.IsZero() && !time.Now().Before(stopT) {
+		cleanup()
+	}
+	return fromT.IsZero() || int64(timestamp) >= fromT.Unix()
+}
+
+// announce inserts an announcement of any input into the detector
+func announce(a detector.Announcement, findConflicts bool) {
+	findConflicts = beforeInsert(a.Timestamp) && findConflicts
+	start := time.Now()
+	det.Announce(a, findConflicts)
+	countInsertedMessage("announcement", time.Since(start))
+}
+
+// withdraw inserts a withdrawal of any input into the detector
+func withdraw(w detector.Withdrawal) {
+	beforeInsert(w.Timestamp)
+	start := time.Now()
+	det.Withdraw(w)
+	countInsertedMessage("withdrawal", time.Since(start))
+}
+
+// checkForTimeIntervall writes the origin ASes involved in conflicts during the last interval to a new file after each interval
+func checkForTimeIntervall(intervall int) {
+	for range time.Tick(time.Minute * time.Duration(intervall)) {
+		fmt.Println(Teal("Printing next rough overview of origin AS activity in the last ", strconv.Itoa(intervall), " minutes. [", time.Now().String(), "]"))
+		writeOriginsFile(originsFileName+strconv.Itoa(recentFilesCounter)+".csv", det.WriteRecentOriginFrequencies)
+		recentFilesCounter++
+		det.ResetRecentOriginCounters()
+		fmt.Println(Teal("Finished printing of rough overview of most recent origin ASes activities. [", time.Now().String(), "]"))
+
+	}
+}
+
+func writeOriginsFile(fileName string, write func(w io.Writer) error) {
+	originsFile, err := os.Create(fileName)
+	if err != nil {
+		fmt.Println(Red("could not create csv file for frequencies of origin ASes"))
+		return
+	}
+	defer originsFile.Close()
+	if err = write(originsFile); err != nil {
+		fmt.Println(Red("Could not write to originsFile"))
+	}
+}
+
 func processBGPFiles() {
-	files, err := ioutil.ReadDir(inputDirectory) //returnes all files sorted by filename
+	archives, err := findArchives(inputDirectory)
 	if err != nil {
 		fmt.Println(Red("could not read specified input directory: ", err))
 		return
 	}
 	fmt.Println(Teal("\n\n----------------------------------------------------------------------------------------------------------------------------------"))
+	fmt.Println(Teal("Found files of ", len(archives), " collector(s)"))
 
-	//no RIB was specified, hence we use the newest one (if a RIB file is there)
-	if rib == "" {
-		fmt.Println(Teal("No RIB specified. Searching for newest RIB"))
-		for i := len(files) - 1; i >= 0; i-- {
-			if strings.Contains(files[i].Name(), "rib") {
-				fmt.Println(Green("Found newest RIB: ", files[i].Name()))
-				rib = files[i].Name()
-				break
-			}
-		}
+	//if a RIB or a start time was specified we use the RIB of each collector at (or closest before) this time, otherwise the newest RIB of each collector
+	ribTime := ""
+	untilFileTime := ""
+	if !untilT.IsZero() {
+		untilFileTime = convertTimeToFileTime(untilT)
 	}
-
-	//if there is a relevant RIB (the one specified or the newest one) we read the RIB and all following update files
-	//if there is no RIB we read all update files
-	dateAndTimeStartReading := "0000.0000"
-	if rib != "" {
-		fmt.Println(Teal("Reading RIB: ", rib))
-		e := bgpd.parseRIBAndInsert(inputDirectory + "/" + rib)
-		if e != nil {
-			fmt.Println(Red("Error while parsing RIB: ", e))
+	if !fromT.IsZero() {
+		ribTime = convertTimeToFileTime(fromT)
+	} else if rib != "" {
+		_, t, ok := parseArchiveFileName(filepath.Base(rib))
+		if !ok {
+			fmt.Println(Red("RIB file in wrong format. Expected format: [rib|bview].YYYYMMDD.HHMM{.bz2|.gz}"))
 			return
 		}
-		fmt.Println(Teal("Finished parsing the RIB\n\n"))
-		s := strings.Split(rib, ".")
-		if !(len(s) > 1) {
-			fmt.Println(Red("RIB file in wrong format. Expected format: rib.YYYYMMDD.HHMM{.bz2|.gz}"))
-			return
-		}
-		dateAndTimeStartReading = s[1] + "." + s[2]
-		fmt.Println(Teal("Searching for update files representing time intervals after the RIB..."))
-	} else {
-		fmt.Println(Teal("No RIB found at all. We will now read and parse all updates files"))
+		ribTime = t
 	}
-	fmt.Println(Teal("\n\n----------------------------------------------------------------------------------------------------------------------------------"))
 
-	for i := 0; i < len(files); i++ {
-		if strings.Contains(files[i].Name(), "updates") {
-			s := strings.Split(files[i].Name(), ".")
-			if !(len(s) > 1) {
-				fmt.Println(Red("Updates file in wrong format. Expected format: updates.YYYYMMDD.HHMM{.bz2|.gz}. Got: ", files[i].Name()))
-				continue
-			}
-			dateAndTimeOfFile := s[1] + "." + s[2]
-			fmt.Println(Teal("\nFound update File " + dateAndTimeOfFile))
-			fmt.Println(Teal("      -->will be inserted: ", dateAndTimeOfFile >= dateAndTimeStartReading))
-			if dateAndTimeOfFile >= dateAndTimeStartReading {
-				e := messages.parseUpdatesAndInsert(inputDirectory+"/"+files[i].Name(), true)
-				if e != nil {
-					fmt.Println(Red(e))
-				}
-				fmt.Println(Teal("Finished parsing and processing of update file ", files[i].Name()))
-				fmt.Println()
+	//if there is a relevant RIB of a collector we read the RIB and all following update files of this collector
+	//if there is no RIB we read all update files of the collector
+	streams := make([]*mrtUpdateStream, 0, len(archives))
+	for _, a := range archives {
+		name := a.collector
+		if name == "" {
+			name = "(unknown collector)"
+		}
+		dateAndTimeStartReading := "0000.0000"
+		ribFile, ok := a.selectRIB(ribTime)
+		if ok {
+			fmt.Println(Teal("Reading RIB of ", name, ": ", ribFile.path))
+			e := bgpd.parseRIBAndInsert(ribFile.path, a.collector)
+			if e != nil {
+				fmt.Println(Red("Error while parsing RIB: ", e))
+				return
 			}
+			fmt.Println(Teal("Finished parsing the RIB\n\n"))
+			dateAndTimeStartReading = ribFile.time
+		} else {
+			fmt.Println(Teal("No RIB found for ", name, ". We will read and parse all of its updates files"))
 		}
+		updates := a.updatesInWindow(dateAndTimeStartReading, untilFileTime)
+		fmt.Println(Teal("Update files of ", name, " representing time intervals after the RIB: ", len(updates)))
+		streams = append(streams, newMrtUpdateStream(a.collector, updates))
 	}
+	fmt.Println(Teal("\n\n----------------------------------------------------------------------------------------------------------------------------------"))
+
+	//the updates of all collectors are merged by their timestamps
+	messages.parseUpdatesAndInsert(streams, true)
+	fmt.Println(Teal("Finished parsing and processing of update files"))
+	fmt.Println()
 }
 
 func main() {
@@ -265,37 +545,102 @@ func main() {
 	fmt.Println(Teal("\nStarting Initialization..."))
 	initialize()
 
-	c := make(chan os.Signal)
+	if restoreFileName != "" {
+		fmt.Println(Teal("\nRestoring state from snapshot ", restoreFileName, "..."))
+		if err := det.RestoreSnapshot(restoreFileName); err != nil {
+			fmt.Println(Red("could not restore snapshot: ", err))
+			return
+		}
+	}
+
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		cleanup()
+		//the goroutine inserting messages stops by itself, so that the state is not changed while we write the final outputs
+		atomic.StoreInt32(&stopRequested, 1)
+		select {
+		case <-stopAcknowledged: //it writes the final outputs itself
+		case <-time.After(stopTimeout):
+			fmt.Println(Red("No message was inserted within ", stopTimeout, ". Stopping anyway"))
+			cleanup()
+		}
+	}()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := det.ReloadAllowlist(); err != nil {
+				fmt.Println(Red("could not reload allowlist, keeping the previous one: ", err))
+			}
+		}
 	}()
 
 	var err error
-	conflictsFile, err = os.Create(conflictsFileName + ".json")
+	rotate := time.Minute * time.Duration(conflictsRotate)
+	rotateSize := int64(conflictsRotateSize) * 1024 * 1024
+	conflictsFile, err = newNdjsonFile(conflictsFileName, rotate, rotateSize, conflictsGzip)
 	if err != nil {
 		fmt.Println(Red("could not create JSON file for found conflicts"))
 		return
 	}
-	defer conflictsFile.Close()
+	closedConflictsFile, err = newNdjsonFile(closedConflictsFileName, rotate, rotateSize, conflictsGzip)
+	if err != nil {
+		fmt.Println(Red("could not create JSON file for ended conflicts"))
+		return
+	}
 
-	mutex = &sync.Mutex{}
+	if alertSinksFileName != "" {
+		if err := readAlertSinks(alertSinksFileName); err != nil {
+			fmt.Println(Red("could not read alert sinks: ", err))
+			return
+		}
+	}
+
+	if httpAddress != "" {
+		go runHTTPAPI()
+	}
 
 	if inputDirectory != "" {
 		fmt.Println(Teal("\nStarted parsing of Routeviews..."))
 		processBGPFiles()
 	}
 
-	if liveMode {
+	if bgpListenAddress != "" {
 		fmt.Println(Teal("----------------------------------------------------------------------------------------------------------------------------------\n"))
 
-		fmt.Println(Teal("Started Connection to RIPE RIS...\n"))
+		fmt.Println(Teal("Started BGP speaker...\n"))
+
+		go checkForTimeIntervall(writeInterval)
+		runBGPSpeaker()
+	} else if bmpAddress != "" {
+		fmt.Println(Teal("----------------------------------------------------------------------------------------------------------------------------------\n"))
+
+		fmt.Println(Teal("Started BMP listener...\n"))
+
+		go checkForTimeIntervall(writeInterval)
+		runBMP()
+	} else if replayInput != "" {
+		fmt.Println(Teal("----------------------------------------------------------------------------------------------------------------------------------\n"))
+
+		fmt.Println(Teal("Started replay of recorded livestream...\n"))
 
 		go checkForTimeIntervall(writeInterval)
-		for {
-			runLivestream()
+		runReplay()
+	} else if liveMode {
+		if recordDirectory != "" {
+			recorder, err = newRisRecorder(recordDirectory, time.Minute*time.Duration(recordRotate))
+			if err != nil {
+				fmt.Println(Red("could not create directory for recording: ", err))
+				return
+			}
 		}
+		fmt.Println(Teal("----------------------------------------------------------------------------------------------------------------------------------\n"))
+
+		fmt.Println(Teal("Started Connection to RIPE RIS...\n"))
+
+		go checkForTimeIntervall(writeInterval)
+		runLivestream()
 	}
 
 	cleanup()
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+The metrics are exposed in the Prometheus text format on /metrics of the HTTP API (-http). The counters of the detector
+itself are read at every scrape, the counters of the inputs are collected here.
+*/
+
+var metricsLock sync.Mutex
+var inputSource = "rib"                     //the input whose messages are currently inserted: rib, updates, rislive, replay, bmp or bgp
+var insertedMessages = make(map[string]int) //by input source and message type, key is source + " " + type
+var parseErrors = make(map[string]int)      //by category
+var alertDeliveries = make(map[string]int)  //by alert sink and result, key is sink + " " + result
+var processingSeconds = map[string]*histogram{"announcement": newHistogram(), "withdrawal": newHistogram()}
+var inputQueueLength func() int //number of messages waiting in the channel of the current input, nil if there is none
+
+var latencyBuckets = []float64{0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.05, 0.1, 1}
+
+type histogram struct {
+	counts []int //cumulative per bucket
+	count  int
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range latencyBuckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum = h.sum + v
+}
+
+// countInsertedMessage records an inserted message of the current input together with the time it took to process it
+func countInsertedMessage(messageType string, duration time.Duration) {
+	metricsLock.Lock()
+	insertedMessages[inputSource+" "+messageType]++
+	processingSeconds[messageType].observe(duration.Seconds())
+	metricsLock.Unlock()
+}
+
+func countParseError(category string) {
+	metricsLock.Lock()
+	parseErrors[category]++
+	metricsLock.Unlock()
+}
+
+func countAlertDelivery(sink string, result string) {
+	metricsLock.Lock()
+	alertDeliveries[sink+" "+result]++
+	metricsLock.Unlock()
+}
+
+func setInputSource(source string, queueLength func() int) {
+	metricsLock.Lock()
+	inputSource = source
+	inputQueueLength = queueLength
+	metricsLock.Unlock()
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w)
+}
+
+func writeMetrics(w io.Writer) {
+	stats := det.Statistics()
+	metric(w, "hijackdetector_conflict_triggers_total", "counter", "Announcements which triggered conflicts", stats.ConflictTriggers)
+	metric(w, "hijackdetector_conflicts_total", "counter", "Conflicts found (one announcement can trigger several conflicts)", stats.Conflicts)
+	metric(w, "hijackdetector_conflicts_allowlisted_total", "counter", "Conflicts matching the allowlist (tagged or suppressed)", stats.AllowlistedConflicts)
+	metric(w, "hijackdetector_alerts_total", "counter", "Alerts of the watchlist of prefixes", stats.Alerts)
+	metric(w, "hijackdetector_asn_alerts_total", "counter", "Alerts of the watchlist of ASNs", stats.ASNAlerts)
+	metric(w, "hijackdetector_conflicts_active", "gauge", "Conflicts which are still ongoing", stats.ActiveConflicts)
+	metric(w, "hijackdetector_conflicts_closed_total", "counter", "Conflicts which ended", stats.ClosedConflicts)
+	metric(w, "hijackdetector_trie_nodes", "gauge", "Nodes of the IPv4 and IPv6 tries", stats.TrieNodes)
+	metric(w, "hijackdetector_announcements_active", "gauge", "Active announcements stored in the tries", stats.ActiveAnnouncements)
+	metric(w, "hijackdetector_peers", "gauge", "Peers known to the detector", stats.Peers)
+	metric(w, "hijackdetector_peer_downs_total", "counter", "Peer sessions which went down", stats.PeerDowns)
+	metric(w, "hijackdetector_flushed_announcements_total", "counter", "Announcements removed because their peer went down", stats.FlushedRoutes)
+	metric(w, "hijackdetector_origin_ases", "gauge", "Origin ASes involved in conflicts", stats.OriginASes)
+	metric(w, "hijackdetector_reconnects_total", "counter", "Reconnects to RIS Live", int(atomic.LoadInt64(&countReconnects)))
+
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	if inputQueueLength != nil {
+		metric(w, "hijackdetector_input_queue_length", "gauge", "Messages waiting in the queue of the input", inputQueueLength())
+		metric(w, "hijackdetector_input_queue_capacity", "gauge", "Capacity of the queue of the input (-buffer)", buffer)
+	}
+
+	fmt.Fprintln(w, "# HELP hijackdetector_messages_inserted_total Messages inserted into the detector by input source and type")
+	fmt.Fprintln(w, "# TYPE hijackdetector_messages_inserted_total counter")
+	for _, key := range sortedKeys(insertedMessages) {
+		var source, messageType string
+		fmt.Sscan(key, &source, &messageType)
+		fmt.Fprintf(w, "hijackdetector_messages_inserted_total{source=%q,type=%q} %d\n", source, messageType, insertedMessages[key])
+	}
+
+	fmt.Fprintln(w, "# HELP hijackdetector_parse_errors_total Input messages which could not be parsed or were not usable, by category")
+	fmt.Fprintln(w, "# TYPE hijackdetector_parse_errors_total counter")
+	for _, category := range sortedKeys(parseErrors) {
+		fmt.Fprintf(w, "hijackdetector_parse_errors_total{category=%q} %d\n", category, parseErrors[category])
+	}
+
+	fmt.Fprintln(w, "# HELP hijackdetector_alert_deliveries_total Alerts delivered to the alert sinks, by sink and result (delivered, retried, failed or dropped)")
+	fmt.Fprintln(w, "# TYPE hijackdetector_alert_deliveries_total counter")
+	for _, key := range sortedKeys(alertDeliveries) {
+		var sink, result string
+		fmt.Sscan(key, &sink, &result)
+		fmt.Fprintf(w, "hijackdetector_alert_deliveries_total{sink=%q,result=%q} %d\n", sink, result, alertDeliveries[key])
+	}
+
+	fmt.Fprintln(w, "# HELP hijackdetector_message_processing_seconds Time to insert a message and find its conflicts")
+	fmt.Fprintln(w, "# TYPE hijackdetector_message_processing_seconds histogram")
+	for _, messageType := range []string{"announcement", "withdrawal"} {
+		h := processingSeconds[messageType]
+		for i, b := range latencyBuckets {
+			fmt.Fprintf(w, "hijackdetector_message_processing_seconds_bucket{type=%q,le=\"%s\"} %d\n", messageType, strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(w, "hijackdetector_message_processing_seconds_bucket{type=%q,le=\"+Inf\"} %d\n", messageType, h.count)
+		fmt.Fprintf(w, "hijackdetector_message_processing_seconds_sum{type=%q} %s\n", messageType, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "hijackdetector_message_processing_seconds_count{type=%q} %d\n", messageType, h.count)
+	}
+}
+
+func metric(w io.Writer, name string, metricType string, help string, value int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, metricType, name, value)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
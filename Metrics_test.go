@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestMetrics starts the collected metrics from zero for the duration of the test
+func useTestMetrics(t *testing.T) {
+	metricsLock.Lock()
+	source, messages, errorsBefore, deliveries, seconds, queue := inputSource, insertedMessages, parseErrors, alertDeliveries, processingSeconds, inputQueueLength
+	inputSource, inputQueueLength = "rib", nil
+	insertedMessages, parseErrors, alertDeliveries = make(map[string]int), make(map[string]int), make(map[string]int)
+	processingSeconds = map[string]*histogram{"announcement": newHistogram(), "withdrawal": newHistogram()}
+	metricsLock.Unlock()
+	t.Cleanup(func() {
+		metricsLock.Lock()
+		inputSource, insertedMessages, parseErrors, alertDeliveries, processingSeconds, inputQueueLength = source, messages, errorsBefore, deliveries, seconds, queue
+		metricsLock.Unlock()
+	})
+}
+
+var sampleLine = regexp.MustCompile(`^([a-z_]+)(\{[a-z]+="[^"]*"(,[a-z]+="[^"]*")*\})? (\S+)$`)
+var histogramSuffix = regexp.MustCompile(`_(bucket|sum|count)$`)
+
+// parseExposition checks the Prometheus text format: every sample belongs to a metric family announced by HELP and TYPE
+// before. It returns the values by metric name and labels
+func parseExposition(t *testing.T, text string) map[string]float64 {
+	samples := make(map[string]float64)
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 {
+				t.Errorf("invalid TYPE line %q", line)
+				continue
+			}
+			types[fields[2]] = fields[3]
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("invalid sample line %q", line)
+			continue
+		}
+		family := m[1]
+		if types[family] == "" {
+			family = histogramSuffix.ReplaceAllString(family, "")
+			if types[family] != "histogram" {
+				t.Errorf("sample %q without TYPE of its metric family", line)
+			}
+		}
+		value, err := strconv.ParseFloat(m[4], 64)
+		if err != nil {
+			t.Errorf("invalid value in %q", line)
+		}
+		samples[m[1]+m[2]] = value
+	}
+	return samples
+}
+
+func TestWriteMetrics(t *testing.T) {
+	useTestDetector(t)
+	useTestMetrics(t)
+	setInputSource("rislive", func() int { return 3 })
+	countInsertedMessage("announcement", 30*time.Microsecond)
+	countInsertedMessage("announcement", 2*time.Millisecond)
+	countInsertedMessage("announcement", 2*time.Second)
+	countInsertedMessage("withdrawal", 10*time.Microsecond)
+	countParseError("ris_json")
+	countParseError("ris_json")
+	countAlertDelivery("noc", "delivered")
+
+	var buffer bytes.Buffer
+	writeMetrics(&buffer)
+	samples := parseExposition(t, buffer.String())
+
+	want := map[string]float64{
+		`hijackdetector_input_queue_length`:                                                  3,
+		`hijackdetector_messages_inserted_total{source="rislive",type="announcement"}`:       3,
+		`hijackdetector_messages_inserted_total{source="rislive",type="withdrawal"}`:         1,
+		`hijackdetector_parse_errors_total{category="ris_json"}`:                             2,
+		`hijackdetector_alert_deliveries_total{sink="noc",result="delivered"}`:               1,
+		`hijackdetector_message_processing_seconds_bucket{type="announcement",le="1e-05"}`:   0,
+		`hijackdetector_message_processing_seconds_bucket{type="announcement",le="2.5e-05"}`: 0,
+		`hijackdetector_message_processing_seconds_bucket{type="announcement",le="5e-05"}`:   1,
+		`hijackdetector_message_processing_seconds_bucket{type="announcement",le="0.001"}`:   1,
+		`hijackdetector_message_processing_seconds_bucket{type="announcement",le="0.0025"}`:  2,
+		`hijackdetector_message_processing_seconds_bucket{type="announcement",le="1"}`:       2,
+		`hijackdetector_message_processing_seconds_bucket{type="announcement",le="+Inf"}`:    3,
+		`hijackdetector_message_processing_seconds_count{type="announcement"}`:               3,
+		`hijackdetector_message_processing_seconds_bucket{type="withdrawal",le="1e-05"}`:     1,
+		`hijackdetector_message_processing_seconds_count{type="withdrawal"}`:                 1,
+	}
+	for name, value := range want {
+		if got, ok := samples[name]; !ok || got != value {
+			t.Errorf("%s = %v, want %v", name, got, value)
+		}
+	}
+	if sum := samples[`hijackdetector_message_processing_seconds_sum{type="announcement"}`]; math.Abs(sum-2.00203) > 1e-9 {
+		t.Errorf("sum of the announcement processing times = %v, want 2.00203", sum)
+	}
+
+	//the buckets are cumulative
+	for _, messageType := range []string{"announcement", "withdrawal"} {
+		previous := 0.0
+		buckets := append(append([]float64{}, latencyBuckets...), math.Inf(1))
+		for _, b := range buckets {
+			le := strconv.FormatFloat(b, 'g', -1, 64)
+			if math.IsInf(b, 1) {
+				le = "+Inf"
+			}
+			value, ok := samples[`hijackdetector_message_processing_seconds_bucket{type="`+messageType+`",le="`+le+`"}`]
+			if !ok || value < previous {
+				t.Errorf("bucket %s of %s: %v (present: %v), previous bucket %v", le, messageType, value, ok, previous)
+			}
+			previous = value
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/osrg/gobgp/pkg/packet/bgp"
+	"github.com/osrg/gobgp/pkg/packet/mrt"
+)
+
+/*
+Decoders for MRT records (RFC 6396, RFC 8050) which the mrt package of gobgp does not decode (correctly):
+  - TABLE_DUMP (v1), used by older Routeviews archives
+  - the RIB records of TABLE_DUMP_V2: gobgp expects a complete MP_REACH_NLRI attribute, but in RIBs it only contains the
+    next hop. Hence all IPv6 RIB entries failed to parse. We only need the AS path, so we only decode AS_PATH and AS4_PATH
+  - BGP4MP messages with Add-Path (RFC 7911): gobgp parses the BGP message without the path identifiers
+*/
+
+var errNotUnicast = errors.New("not a unicast RIB record")
+
+// ribRecord is one RIB record: a subnet together with one or several paths
+type ribRecord struct {
+	subnet  net.IPNet
+	entries []ribEntry
+}
+
+type ribEntry struct {
+	peerIndex      uint16 //TABLE_DUMP_V2: index in the peer index table
+	peerIP         string //TABLE_DUMP: the peer is contained in the entry itself
+	peerAS         uint32
+	originatedTime uint32
+	pathID         uint32                       //only for the Add-Path subtypes, 0 otherwise
+	attributes     []bgp.PathAttributeInterface //only AS_PATH and AS4_PATH
+}
+
+// decodeTableDumpV2Rib decodes the RIB subtypes of TABLE_DUMP_V2 (not the PEER_INDEX_TABLE). Multicast records return errNotUnicast
+func decodeTableDumpV2Rib(subType uint16, data []byte) (ribRecord, error) {
+	var record ribRecord
+	var afi uint16
+	isAddPath := false
+	switch mrt.MRTSubTypeTableDumpv2(subType) {
+	case mrt.RIB_IPV4_UNICAST_ADDPATH:
+		isAddPath = true
+		fallthrough
+	case mrt.RIB_IPV4_UNICAST:
+		afi = bgp.AFI_IP
+	case mrt.RIB_IPV6_UNICAST_ADDPATH:
+		isAddPath = true
+		fallthrough
+	case mrt.RIB_IPV6_UNICAST:
+		afi = bgp.AFI_IP6
+	case mrt.RIB_GENERIC_ADDPATH:
+		isAddPath = true
+		fallthrough
+	case mrt.RIB_GENERIC:
+		if len(data) < 7 {
+			return record, errors.New("RIB_GENERIC record too short")
+		}
+		afi = binary.BigEndian.Uint16(data[4:6])
+		if data[6] != bgp.SAFI_UNICAST || (afi != bgp.AFI_IP && afi != bgp.AFI_IP6) {
+			return record, errNotUnicast
+		}
+		data = append(data[:4:4], data[7:]...) //without AFI and SAFI it is the same as the other subtypes
+	case mrt.RIB_IPV4_MULTICAST, mrt.RIB_IPV6_MULTICAST, mrt.RIB_IPV4_MULTICAST_ADDPATH, mrt.RIB_IPV6_MULTICAST_ADDPATH:
+		return record, errNotUnicast
+	default:
+		return record, fmt.Errorf("unsupported TABLE_DUMP_V2 subtype %d", subType)
+	}
+
+	if len(data) < 5 {
+		return record, errors.New("RIB record too short")
+	}
+	data = data[4:] //sequence number
+	subnet, rest, err := decodeNLRIPrefix(afi, data)
+	if err != nil {
+		return record, err
+	}
+	record.subnet = subnet
+	data = rest
+	if len(data) < 2 {
+		return record, errors.New("RIB record without entry count")
+	}
+	count := int(binary.BigEndian.Uint16(data[:2]))
+	data = data[2:]
+	record.entries = make([]ribEntry, 0, count)
+	for i := 0; i < count; i++ {
+		var e ribEntry
+		header := 8
+		if isAddPath {
+			header = 12
+		}
+		if len(data) < header {
+			return record, errors.New("RIB entry too short")
+		}
+		e.peerIndex = binary.BigEndian.Uint16(data[0:2])
+		e.originatedTime = binary.BigEndian.Uint32(data[2:6])
+		if isAddPath {
+			e.pathID = binary.BigEndian.Uint32(data[6:10])
+		}
+		attributesLength := int(binary.BigEndian.Uint16(data[header-2 : header]))
+		data = data[header:]
+		if len(data) < attributesLength {
+			return record, errors.New("RIB entry attributes too short")
+		}
+		e.attributes, err = decodeASPathAttributes(data[:attributesLength])
+		if err != nil {
+			return record, err
+		}
+		data = data[attributesLength:]
+		record.entries = append(record.entries, e)
+	}
+	return record, nil
+}
+
+// decodeTableDumpRib decodes a TABLE_DUMP (v1) record. It always contains exactly one entry
+func decodeTableDumpRib(subType uint16, data []byte) (ribRecord, error) {
+	var record ribRecord
+	addressLength := 4
+	if subType == bgp.AFI_IP6 {
+		addressLength = 16
+	} else if subType != bgp.AFI_IP {
+		return record, fmt.Errorf("unsupported TABLE_DUMP subtype %d", subType)
+	}
+	//view number (2), sequence number (2), prefix, prefix length (1), status (1), originated time (4), peer IP, peer AS (2), attribute length (2)
+	if len(data) < 4+addressLength+2+4+addressLength+2+2 {
+		return record, errors.New("TABLE_DUMP record too short")
+	}
+	data = data[4:]
+	length := int(data[addressLength])
+	if length > addressLength*8 {
+		return record, fmt.Errorf("invalid prefix length %d", length)
+	}
+	record.subnet = net.IPNet{IP: net.IP(append([]byte(nil), data[:addressLength]...)), Mask: net.CIDRMask(length, addressLength*8)}
+	record.subnet.IP = record.subnet.IP.Mask(record.subnet.Mask)
+	data = data[addressLength+2:]
+
+	var e ribEntry
+	e.originatedTime = binary.BigEndian.Uint32(data[0:4])
+	e.peerIP = net.IP(data[4 : 4+addressLength]).String()
+	data = data[4+addressLength:]
+	e.peerAS = uint32(binary.BigEndian.Uint16(data[0:2]))
+	attributesLength := int(binary.BigEndian.Uint16(data[2:4]))
+	data = data[4:]
+	if len(data) < attributesLength {
+		return record, errors.New("TABLE_DUMP attributes too short")
+	}
+	var err error
+	e.attributes, err = decodeASPathAttributes(data[:attributesLength])
+	if err != nil {
+		return record, err
+	}
+	record.entries = []ribEntry{e}
+	return record, nil
+}
+
+// decodeNLRIPrefix decodes a prefix in the NLRI encoding (length in bits, followed by the needed bytes)
+func decodeNLRIPrefix(afi uint16, data []byte) (net.IPNet, []byte, error) {
+	addressLength := 4
+	if afi == bgp.AFI_IP6 {
+		addressLength = 16
+	}
+	length := int(data[0])
+	byteLength := (length + 7) / 8
+	if length > addressLength*8 || len(data) < 1+byteLength {
+		return net.IPNet{}, nil, fmt.Errorf("invalid prefix length %d", length)
+	}
+	ip := make(net.IP, addressLength)
+	copy(ip, data[1:1+byteLength])
+	mask := net.CIDRMask(length, addressLength*8)
+	return net.IPNet{IP: ip.Mask(mask), Mask: mask}, data[1+byteLength:], nil
+}
+
+// decodeASPathAttributes decodes the AS_PATH and AS4_PATH attributes and skips all others
+func decodeASPathAttributes(data []byte) ([]bgp.PathAttributeInterface, error) {
+	var result []bgp.PathAttributeInterface
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, errors.New("path attribute too short")
+		}
+		flags := bgp.BGPAttrFlag(data[0])
+		attributeType := bgp.BGPAttrType(data[1])
+		headerLength := 3
+		length := int(data[2])
+		if flags&bgp.BGP_ATTR_FLAG_EXTENDED_LENGTH != 0 {
+			if len(data) < 4 {
+				return nil, errors.New("path attribute too short")
+			}
+			headerLength = 4
+			length = int(binary.BigEndian.Uint16(data[2:4]))
+		}
+		if len(data) < headerLength+length {
+			return nil, errors.New("path attribute too short")
+		}
+		if attributeType == bgp.BGP_ATTR_TYPE_AS_PATH || attributeType == bgp.BGP_ATTR_TYPE_AS4_PATH {
+			p, err := bgp.GetPathAttribute(data)
+			if err != nil {
+				return nil, err
+			}
+			if err = p.DecodeFromBytes(data[:headerLength+length]); err != nil {
+				return nil, err
+			}
+			result = append(result, p)
+		}
+		data = data[headerLength+length:]
+	}
+	return result, nil
+}
+
+// isAddPathBGP4MP returns true for the BGP4MP subtypes of RFC 8050, whose BGP messages contain path identifiers
+func isAddPathBGP4MP(subType uint16) bool {
+	switch mrt.MRTSubTypeBGP4MP(subType) {
+	case mrt.MESSAGE_ADDPATH, mrt.MESSAGE_AS4_ADDPATH, mrt.MESSAGE_LOCAL_ADDPATH, mrt.MESSAGE_AS4_LOCAL_ADDPATH:
+		return true
+	}
+	return false
+}
+
+// decodeBGP4MPAddPath decodes a BGP4MP message of an Add-Path subtype, so that the prefixes contain their path identifiers
+func decodeBGP4MPAddPath(hdr *mrt.MRTHeader, data []byte) (*mrt.MRTMessage, error) {
+	subType := mrt.MRTSubTypeBGP4MP(hdr.SubType)
+	isAS4 := subType == mrt.MESSAGE_AS4_ADDPATH || subType == mrt.MESSAGE_AS4_LOCAL_ADDPATH
+	asLength := 2
+	if isAS4 {
+		asLength = 4
+	}
+	//peer AS, local AS, interface index (2), AFI (2), peer IP, local IP
+	if len(data) < 2*asLength+4 {
+		return nil, errors.New("BGP4MP message too short")
+	}
+	var peerAS, localAS uint32
+	if isAS4 {
+		peerAS = binary.BigEndian.Uint32(data[0:4])
+		localAS = binary.BigEndian.Uint32(data[4:8])
+	} else {
+		peerAS = uint32(binary.BigEndian.Uint16(data[0:2]))
+		localAS = uint32(binary.BigEndian.Uint16(data[2:4]))
+	}
+	data = data[2*asLength:]
+	interfaceIndex := binary.BigEndian.Uint16(data[0:2])
+	addressLength := 4
+	if binary.BigEndian.Uint16(data[2:4]) == bgp.AFI_IP6 {
+		addressLength = 16
+	}
+	data = data[4:]
+	if len(data) < 2*addressLength {
+		return nil, errors.New("BGP4MP message too short")
+	}
+	peerIP := net.IP(data[:addressLength]).String()
+	localIP := net.IP(data[addressLength : 2*addressLength]).String()
+	data = data[2*addressLength:]
+
+	option := &bgp.MarshallingOption{AddPath: map[bgp.RouteFamily]bgp.BGPAddPathMode{
+		bgp.RF_IPv4_UC: bgp.BGP_ADD_PATH_BOTH,
+		bgp.RF_IPv6_UC: bgp.BGP_ADD_PATH_BOTH,
+	}}
+	bgpMessage, err := bgp.ParseBGPMessage(data, option)
+	if err != nil {
+		return nil, err
+	}
+	return &mrt.MRTMessage{
+		Header: *hdr,
+		Body:   mrt.NewBGP4MPMessageAddPath(peerAS, localAS, interfaceIndex, peerIP, localIP, isAS4, bgpMessage),
+	}, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/osrg/gobgp/pkg/packet/bgp"
+	"github.com/osrg/gobgp/pkg/packet/mrt"
+)
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func concat(parts ...[]byte) []byte {
+	var result []byte
+	for _, p := range parts {
+		result = append(result, p...)
+	}
+	return result
+}
+
+// mrtRecord returns an MRT record with the common header
+func mrtRecord(timestamp uint32, recordType mrt.MRTType, subType uint16, body []byte) []byte {
+	return concat(be32(timestamp), be16(uint16(recordType)), be16(subType), be32(uint32(len(body))), body)
+}
+
+// asPathAttribute returns an AS_PATH attribute with one AS_SEQUENCE of 2 or 4 octet ASes
+func asPathAttribute(as4 bool, path ...uint32) []byte {
+	segment := []byte{bgp.BGP_ASPATH_ATTR_TYPE_SEQ, byte(len(path))}
+	for _, as := range path {
+		if as4 {
+			segment = append(segment, be32(as)...)
+		} else {
+			segment = append(segment, be16(uint16(as))...)
+		}
+	}
+	return concat([]byte{byte(bgp.BGP_ATTR_FLAG_TRANSITIVE), byte(bgp.BGP_ATTR_TYPE_AS_PATH), byte(len(segment))}, segment)
+}
+
+// nlri returns an IPv4 prefix in the NLRI encoding, with a path identifier if addPath is true
+func nlri(addPath bool, pathID uint32, subnet string) []byte {
+	_, ipnet, _ := net.ParseCIDR(subnet)
+	length, _ := ipnet.Mask.Size()
+	result := append([]byte{byte(length)}, ipnet.IP.To4()[:(length+7)/8]...)
+	if addPath {
+		result = append(be32(pathID), result...)
+	}
+	return result
+}
+
+// addPathUpdate returns a BGP UPDATE of a session with Add-Path, which withdraws or announces one IPv4 prefix
+func addPathUpdate(pathID uint32, subnet string, withdrawal bool, path ...uint32) []byte {
+	var withdrawn, attributes, announced []byte
+	if withdrawal {
+		withdrawn = nlri(true, pathID, subnet)
+	} else {
+		attributes = concat([]byte{byte(bgp.BGP_ATTR_FLAG_TRANSITIVE), byte(bgp.BGP_ATTR_TYPE_ORIGIN), 1, 0},
+			asPathAttribute(true, path...),
+			[]byte{byte(bgp.BGP_ATTR_FLAG_TRANSITIVE), byte(bgp.BGP_ATTR_TYPE_NEXT_HOP), 4, 192, 0, 2, 1})
+		announced = nlri(true, pathID, subnet)
+	}
+	body := concat(be16(uint16(len(withdrawn))), withdrawn, be16(uint16(len(attributes))), attributes, announced)
+	marker := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	return concat(marker, be16(uint16(bgp.BGP_HEADER_LENGTH+len(body))), []byte{bgp.BGP_MSG_UPDATE}, body)
+}
+
+// bgp4mpAddPath returns a BGP4MP MESSAGE_AS4_ADDPATH record of the peer 192.0.2.1 (AS 64496)
+func bgp4mpAddPath(timestamp uint32, update []byte) []byte {
+	body := concat(be32(64496), be32(64512), be16(0), be16(bgp.AFI_IP), []byte{192, 0, 2, 1}, []byte{192, 0, 2, 254}, update)
+	return mrtRecord(timestamp, mrt.BGP4MP, uint16(mrt.MESSAGE_AS4_ADDPATH), body)
+}
+
+// stateChange returns a BGP4MP STATE_CHANGE_AS4 record of the peer 192.0.2.1 (AS 64496)
+func stateChange(timestamp uint32, oldState mrt.BGPState, newState mrt.BGPState) []byte {
+	body := concat(be32(64496), be32(64512), be16(0), be16(bgp.AFI_IP), []byte{192, 0, 2, 1}, []byte{192, 0, 2, 254},
+		be16(uint16(oldState)), be16(uint16(newState)))
+	return mrtRecord(timestamp, mrt.BGP4MP, uint16(mrt.STATE_CHANGE_AS4), body)
+}
+
+// tableDumpV1 returns a TABLE_DUMP record for an IPv4 prefix, announced by the peer 192.0.2.1 (AS 64496)
+func tableDumpV1(subnet string, path ...uint32) []byte {
+	_, ipnet, _ := net.ParseCIDR(subnet)
+	length, _ := ipnet.Mask.Size()
+	attributes := asPathAttribute(false, path...)
+	body := concat(be16(0), be16(0), ipnet.IP.To4(), []byte{byte(length), 1}, be32(1600000000), []byte{192, 0, 2, 1}, be16(64496),
+		be16(uint16(len(attributes))), attributes)
+	return mrtRecord(1600000000, mrt.TABLE_DUMP, bgp.AFI_IP, body)
+}
+
+// peerIndexTable returns a TABLE_DUMP_V2 PEER_INDEX_TABLE with the peers 192.0.2.1 (AS 64496) and 192.0.2.2 (AS 64497)
+func peerIndexTable() []byte {
+	body := concat([]byte{192, 0, 2, 254}, be16(0), be16(2),
+		[]byte{2}, []byte{192, 0, 2, 1}, []byte{192, 0, 2, 1}, be32(64496), //peer type 2: IPv4 address and 4 octet AS
+		[]byte{2}, []byte{192, 0, 2, 2}, []byte{192, 0, 2, 2}, be32(64497))
+	return mrtRecord(1600000000, mrt.TABLE_DUMPv2, uint16(mrt.PEER_INDEX_TABLE), body)
+}
+
+// ribEntryV2 is one entry of a TABLE_DUMP_V2 RIB record
+type ribEntryV2 struct {
+	peerIndex uint16
+	pathID    uint32
+	origin    uint32
+}
+
+func tableDumpV2(subType mrt.MRTSubTypeTableDumpv2, subnet string, entries ...ribEntryV2) []byte {
+	addPath := subType == mrt.RIB_IPV4_UNICAST_ADDPATH
+	body := concat(be32(1), nlri(false, 0, subnet), be16(uint16(len(entries))))
+	for _, e := range entries {
+		attributes := asPathAttribute(true, 64496, e.origin)
+		body = append(body, concat(be16(e.peerIndex), be32(1600000000))...)
+		if addPath {
+			body = append(body, be32(e.pathID)...)
+		}
+		body = append(body, concat(be16(uint16(len(attributes))), attributes)...)
+	}
+	return mrtRecord(1600000000, mrt.TABLE_DUMPv2, uint16(subType), body)
+}
+
+func writeMRTFile(t *testing.T, records ...[]byte) string {
+	fileName := filepath.Join(t.TempDir(), "test.mrt")
+	if err := ioutil.WriteFile(fileName, concat(records...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// pathIDsOf returns the path identifiers of the active announcements of a subnet by their origin
+func pathIDsOf(subnet string) map[int]int {
+	_, ipnet, _ := net.ParseCIDR(subnet)
+	result := make(map[int]int)
+	for _, m := range det.Announcements(*ipnet) {
+		result[m.OriginAS] = m.PathID
+	}
+	return result
+}
+
+func TestParseRIBTableDumpV1(t *testing.T) {
+	useTestDetector(t)
+	truncated := tableDumpV1("198.51.100.0/24", 64496, 64501)
+	truncated = mrtRecord(1600000000, mrt.TABLE_DUMP, bgp.AFI_IP, truncated[mrt.MRT_COMMON_HEADER_LEN:20])
+	invalidLength := tableDumpV1("203.0.113.0/24", 64496, 64502)
+	invalidLength[mrt.MRT_COMMON_HEADER_LEN+8] = 33
+	fileName := writeMRTFile(t, tableDumpV1("192.0.2.0/24", 64496, 64500), truncated, invalidLength, tableDumpV1("192.0.2.0/25", 64496, 64503))
+
+	if err := bgpd.parseRIBAndInsert(fileName, "rrc00"); err != nil {
+		t.Fatal(err)
+	}
+	for subnet, origin := range map[string]int{"192.0.2.0/24": 64500, "192.0.2.0/25": 64503} {
+		if ids := pathIDsOf(subnet); len(ids) != 1 || ids[origin] != 0 {
+			t.Errorf("announcements of %s: %v, want only origin %d", subnet, ids, origin)
+		}
+	}
+	if ids := pathIDsOf("203.0.113.0/24"); len(ids) != 0 {
+		t.Errorf("the record with the invalid prefix length was inserted: %v", ids)
+	}
+	if peers := det.Peers(); len(peers) != 1 || peers[0].IP != "192.0.2.1" || peers[0].AS != 64496 || peers[0].Collector != "rrc00" {
+		t.Errorf("peers %+v, want the peer of the entries", peers)
+	}
+}
+
+func TestParseRIBTableDumpV2(t *testing.T) {
+	useTestDetector(t)
+	truncated := tableDumpV2(mrt.RIB_IPV4_UNICAST, "198.51.100.0/24", ribEntryV2{peerIndex: 0, origin: 64501}, ribEntryV2{peerIndex: 1, origin: 64502})
+	truncated = mrtRecord(1600000000, mrt.TABLE_DUMPv2, uint16(mrt.RIB_IPV4_UNICAST), truncated[mrt.MRT_COMMON_HEADER_LEN:len(truncated)-5])
+	fileName := writeMRTFile(t,
+		peerIndexTable(),
+		tableDumpV2(mrt.RIB_IPV4_UNICAST, "192.0.2.0/24", ribEntryV2{peerIndex: 0, origin: 64500}, ribEntryV2{peerIndex: 1, origin: 64501}),
+		truncated,
+		tableDumpV2(mrt.RIB_IPV4_UNICAST, "203.0.113.0/24", ribEntryV2{peerIndex: 7, origin: 64502}), //unknown peer index
+		tableDumpV2(mrt.RIB_IPV4_UNICAST_ADDPATH, "192.0.2.0/25", ribEntryV2{peerIndex: 0, pathID: 1, origin: 64503}, ribEntryV2{peerIndex: 0, pathID: 2, origin: 64504}),
+		tableDumpV2(mrt.RIB_IPV4_MULTICAST, "233.252.0.0/24", ribEntryV2{peerIndex: 0, origin: 64505}))
+
+	if err := bgpd.parseRIBAndInsert(fileName, "rrc00"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := pathIDsOf("192.0.2.0/24"); len(ids) != 2 {
+		t.Errorf("announcements of 192.0.2.0/24: %v, want the origins 64500 and 64501", ids)
+	}
+	if ids := pathIDsOf("192.0.2.0/25"); len(ids) != 2 || ids[64503] != 1 || ids[64504] != 2 {
+		t.Errorf("path IDs of 192.0.2.0/25 by origin: %v, want 64503 -> 1 and 64504 -> 2", ids)
+	}
+	for _, subnet := range []string{"198.51.100.0/24", "203.0.113.0/24", "233.252.0.0/24"} {
+		if ids := pathIDsOf(subnet); len(ids) != 0 {
+			t.Errorf("%s was inserted: %v", subnet, ids)
+		}
+	}
+}
+
+func TestParseUpdatesAddPath(t *testing.T) {
+	useTestDetector(t)
+	truncated := bgp4mpAddPath(1600000003, addPathUpdate(3, "192.0.2.0/24", false, 64496, 64502))
+	truncated = mrtRecord(1600000003, mrt.BGP4MP, uint16(mrt.MESSAGE_AS4_ADDPATH), truncated[mrt.MRT_COMMON_HEADER_LEN:mrt.MRT_COMMON_HEADER_LEN+10])
+	malformedUpdate := bgp4mpAddPath(1600000004, addPathUpdate(4, "192.0.2.0/24", false, 64496, 64503))
+	malformedUpdate = malformedUpdate[:len(malformedUpdate)-3] //the NLRI is cut off, the lengths are not adjusted
+	binary.BigEndian.PutUint32(malformedUpdate[8:12], uint32(len(malformedUpdate)-mrt.MRT_COMMON_HEADER_LEN))
+	fileName := writeMRTFile(t,
+		bgp4mpAddPath(1600000000, addPathUpdate(1, "192.0.2.0/24", false, 64496, 64500)),
+		bgp4mpAddPath(1600000001, addPathUpdate(2, "192.0.2.0/24", false, 64496, 64501)),
+		truncated,
+		malformedUpdate,
+		mrtRecord(1600000005, mrt.BGP4MP_ET, uint16(mrt.MESSAGE_AS4), []byte{0, 0}), //shorter than the microseconds
+		bgp4mpAddPath(1600000006, addPathUpdate(1, "192.0.2.0/24", true)),
+		mrtRecord(1600000007, mrt.BGP4MP, uint16(mrt.MESSAGE_AS4_ADDPATH), []byte{0, 0, 0})[:mrt.MRT_COMMON_HEADER_LEN+1]) //the file ends within a record
+
+	messages.parseUpdatesAndInsert([]*mrtUpdateStream{newMrtUpdateStream("rrc00", []string{fileName})}, true)
+	if ids := pathIDsOf("192.0.2.0/24"); len(ids) != 1 || ids[64501] != 2 {
+		t.Errorf("path IDs of 192.0.2.0/24 by origin: %v, want only 64501 -> 2 after the withdrawal of path 1", ids)
+	}
+}
+
+func TestParseUpdatesStateChange(t *testing.T) {
+	useTestDetector(t)
+	fileName := writeMRTFile(t,
+		bgp4mpAddPath(1600000000, addPathUpdate(1, "192.0.2.0/24", false, 64496, 64500)),
+		stateChange(1600000001, mrt.IDLE, mrt.CONNECT), //the session is not up yet, nothing to flush
+		bgp4mpAddPath(1600000002, addPathUpdate(1, "198.51.100.0/24", false, 64496, 64501)),
+		stateChange(1600000003, mrt.ESTABLISHED, mrt.IDLE),
+		bgp4mpAddPath(1600000004, addPathUpdate(1, "203.0.113.0/24", false, 64496, 64502)))
+
+	messages.parseUpdatesAndInsert([]*mrtUpdateStream{newMrtUpdateStream("rrc00", []string{fileName})}, true)
+	for subnet, want := range map[string]int{"192.0.2.0/24": 0, "198.51.100.0/24": 0, "203.0.113.0/24": 1} {
+		if ids := pathIDsOf(subnet); len(ids) != want {
+			t.Errorf("announcements of %s: %v, want %d", subnet, ids, want)
+		}
+	}
+	if s := det.Statistics(); s.PeerDowns != 1 || s.FlushedRoutes != 2 {
+		t.Errorf("peer downs = %d, flushed routes = %d, want 1 and 2", s.PeerDowns, s.FlushedRoutes)
+	}
+}
+
+func TestDecodeMalformedRecords(t *testing.T) {
+	validV1 := tableDumpV1("192.0.2.0/24", 64496, 64500)[mrt.MRT_COMMON_HEADER_LEN:]
+	validV2 := tableDumpV2(mrt.RIB_IPV4_UNICAST_ADDPATH, "192.0.2.0/24", ribEntryV2{pathID: 1, origin: 64500})[mrt.MRT_COMMON_HEADER_LEN:]
+	validBGP4MP := bgp4mpAddPath(1600000000, addPathUpdate(1, "192.0.2.0/24", false, 64496, 64500))[mrt.MRT_COMMON_HEADER_LEN:]
+	header := &mrt.MRTHeader{Type: mrt.BGP4MP, SubType: uint16(mrt.MESSAGE_AS4_ADDPATH)}
+
+	if _, err := decodeTableDumpRib(bgp.AFI_IP, validV1); err != nil {
+		t.Errorf("TABLE_DUMP: %v", err)
+	}
+	if _, err := decodeTableDumpV2Rib(uint16(mrt.RIB_IPV4_UNICAST_ADDPATH), validV2); err != nil {
+		t.Errorf("TABLE_DUMP_V2: %v", err)
+	}
+	if _, err := decodeBGP4MPAddPath(header, validBGP4MP); err != nil {
+		t.Errorf("BGP4MP: %v", err)
+	}
+	//every prefix of a record has to be rejected (or be a complete record by chance), but never panic
+	for i := 0; i < len(validV1); i++ {
+		decodeTableDumpRib(bgp.AFI_IP, validV1[:i])
+		decodeTableDumpRib(bgp.AFI_IP6, validV1[:i])
+	}
+	for i := 0; i < len(validV2); i++ {
+		if _, err := decodeTableDumpV2Rib(uint16(mrt.RIB_IPV4_UNICAST_ADDPATH), validV2[:i]); err == nil {
+			t.Errorf("TABLE_DUMP_V2 record truncated to %d bytes was accepted", i)
+		}
+		decodeTableDumpV2Rib(uint16(mrt.RIB_GENERIC), validV2[:i])
+	}
+	for i := 0; i < len(validBGP4MP); i++ {
+		if _, err := decodeBGP4MPAddPath(header, validBGP4MP[:i]); err == nil {
+			t.Errorf("BGP4MP record truncated to %d bytes was accepted", i)
+		}
+	}
+	if _, err := decodeASPathAttributes([]byte{byte(bgp.BGP_ATTR_FLAG_EXTENDED_LENGTH), byte(bgp.BGP_ATTR_TYPE_AS_PATH), 0}); err == nil {
+		t.Error("attribute with a truncated extended length was accepted")
+	}
+	if _, err := decodeASPathAttributes([]byte{0, byte(bgp.BGP_ATTR_TYPE_AS_PATH), 6, bgp.BGP_ASPATH_ATTR_TYPE_SEQ, 5, 0, 1}); err == nil {
+		t.Error("attribute longer than the data was accepted")
+	}
+}
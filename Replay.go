@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Recording writes the raw ris_message JSON of the livestream to NDJSON files (one message per line), compressed with gzip.
+A new file is started every recordRotate minutes. The files are named ris-live.YYYYMMDD.HHMM.ndjson.gz (UTC), so that
+sorting them by name sorts them by time. Replaying reads such files and feeds the messages through handle() again.
+*/
+
+var recorder *risRecorder
+var countReplayed int
+
+type risRecorder struct {
+	lock      sync.Mutex
+	directory string
+	rotate    time.Duration
+	f         *os.File
+	gz        *gzip.Writer
+	w         *bufio.Writer
+	openedT   time.Time
+	closed    bool
+}
+
+func newRisRecorder(directory string, rotate time.Duration) (*risRecorder, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &risRecorder{directory: directory, rotate: rotate}, nil
+}
+
+// write appends one raw message to the current file. A new file is opened if the current one is older than the rotation interval
+func (r *risRecorder) write(data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return
+	}
+	if r.f == nil || time.Since(r.openedT) >= r.rotate {
+		if err := r.open(); err != nil {
+			fmt.Println(Red("could not open new recording file: ", err))
+			return
+		}
+	}
+	r.w.Write(data)
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		r.w.WriteByte('\n')
+	}
+}
+
+// open closes the current file (if any) and starts a new one
+func (r *risRecorder) open() error {
+	r.closeFile()
+	r.openedT = time.Now()
+	name := filepath.Join(r.directory, "ris-live."+r.openedT.UTC().Format("20060102.1504")+".ndjson.gz")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644) //append: a restart within the same minute must not overwrite the file
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.gz = gzip.NewWriter(f)
+	r.w = bufio.NewWriter(r.gz)
+	fmt.Println(Teal("Recording livestream to ", name))
+	return nil
+}
+
+func (r *risRecorder) closeFile() {
+	if r.f == nil {
+		return
+	}
+	err := r.w.Flush()
+	if err == nil {
+		err = r.gz.Close()
+	}
+	errClose := r.f.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		fmt.Println(Red("could not close recording file ", r.f.Name(), ": ", err))
+	}
+	r.f = nil
+}
+
+// close writes the end of the current gzip stream. Messages received afterwards are not recorded anymore
+func (r *risRecorder) close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closeFile()
+	r.closed = true
+}
+
+// replayFiles returns all files to replay: a single file, a comma separated list of files, or all NDJSON files of a directory (sorted by name)
+func replayFiles(input string) ([]string, error) {
+	var result []string
+	for _, name := range strings.Split(input, ",") {
+		name = strings.TrimSpace(name)
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			result = append(result, name)
+			continue
+		}
+		files, err := ioutil.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		inDirectory := make([]string, 0, len(files))
+		for _, f := range files {
+			if strings.Contains(f.Name(), ".ndjson") {
+				inDirectory = append(inDirectory, filepath.Join(name, f.Name()))
+			}
+		}
+		sort.Strings(inDirectory)
+		result = append(result, inDirectory...)
+	}
+	return result, nil
+}
+
+// runReplay feeds recorded messages through handle(). With a replay speed of 0 the messages are processed as fast as possible,
+// otherwise the gaps between the original timestamps are reproduced, divided by the replay speed
+func runReplay() {
+	setInputSource("replay", nil)
+	files, err := replayFiles(replayInput)
+	if err != nil {
+		fmt.Println(Red("could not find files to replay: ", err))
+		return
+	}
+	var firstMessageT float64
+	var startReplayT time.Time
+	for _, file := range files {
+		fmt.Println(Teal("Replaying ", file))
+		scanner, closer, err := getRightScanner(file)
+		if err != nil {
+			fmt.Println(Red("could not open file to replay: ", err))
+			continue
+		}
+		scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var rm RisMessage
+			err = json.Unmarshal(scanner.Bytes(), &rm)
+			if err != nil {
+				countParseError("ris_json")
+				fmt.Println(Red("bad json content in ", file, ": ", err))
+				continue
+			}
+			if rm.Data == nil || (rm.Data.Type != "UPDATE" && rm.Data.Type != "RIS_PEER_STATE") {
+				continue
+			}
+			err = digestPath(rm.Data)
+			if err != nil {
+				countParseError("ris_path")
+				fmt.Printf(Red("decoding the message data path(%v) failed: %v\n", rm.Data.Path, err))
+			}
+			if replaySpeed > 0 {
+				if startReplayT.IsZero() {
+					firstMessageT = rm.Data.Timestamp
+					startReplayT = time.Now()
+				}
+				due := startReplayT.Add(time.Duration((rm.Data.Timestamp - firstMessageT) / replaySpeed * float64(time.Second)))
+				time.Sleep(time.Until(due))
+			}
+			countReplayed++
+			handle(rm.Data)
+		}
+		if err = scanner.Err(); err != nil {
+			fmt.Println(Red("could not read ", file, " completely: ", err))
+		}
+		closer.Close()
+	}
+	fmt.Println(Teal("Replay finished. Messages replayed: ", countReplayed))
+}
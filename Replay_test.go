@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"bgp-hijack-detection/detector"
+)
+
+// testOutput collects what the detector reports while a test feeds one of the inputs into it
+type testOutput struct {
+	lock      sync.Mutex
+	conflicts []detector.ConflictJSON
+	closed    []detector.ClosedConflictJSON
+}
+
+// useTestDetector replaces the global detector for the duration of the test
+func useTestDetector(t *testing.T) *testOutput {
+	out := &testOutput{}
+	before := det
+	det = detector.New(detector.Options{
+		Output: ioutil.Discard,
+		OnConflict: func(c detector.ConflictJSON) {
+			out.lock.Lock()
+			out.conflicts = append(out.conflicts, c)
+			out.lock.Unlock()
+		},
+		OnConflictEnded: func(c detector.ClosedConflictJSON) {
+			out.lock.Lock()
+			out.closed = append(out.closed, c)
+			out.lock.Unlock()
+		},
+	})
+	t.Cleanup(func() { det = before })
+	return out
+}
+
+func (o *testOutput) counts() (int, int) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return len(o.conflicts), len(o.closed)
+}
+
+func risWithdrawal(timestamp float64, prefix string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type": "ris_message",
+		"data": map[string]interface{}{
+			"timestamp":   timestamp,
+			"peer":        "192.0.2.1",
+			"peer_asn":    "64496",
+			"host":        "rrc00",
+			"type":        "UPDATE",
+			"withdrawals": []string{prefix},
+		},
+	})
+	return data
+}
+
+func TestReplay(t *testing.T) {
+	out := useTestDetector(t)
+	directory := t.TempDir()
+
+	//the fixture is written by the recorder, so that recording and replaying have to agree on the format
+	r, err := newRisRecorder(directory, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.write(risUpdate(1600000000, "192.0.2.0/24", 64496, 64500))
+	r.write([]byte("{not json"))
+	r.write([]byte(`{"type": "ris_message", "data": {"type": "OPEN", "timestamp": 1600000001}}`))
+	r.write(risUpdate(1600000002, "192.0.2.0/25", 64496, 64501)) //sub-prefix of another origin
+	r.write(risWithdrawal(1600000003, "192.0.2.0/25"))
+	r.close()
+
+	replayInput = directory
+	replaySpeed = 0
+	countReplayed = 0
+	defer func() { replayInput = "" }()
+	runReplay()
+
+	if countReplayed != 3 {
+		t.Errorf("replayed %d messages, want 3", countReplayed)
+	}
+	conflicts, closed := out.counts()
+	if conflicts != 1 || closed != 1 {
+		t.Fatalf("got %d conflicts and %d ended conflicts, want 1 and 1", conflicts, closed)
+	}
+	c := out.conflicts[0]
+	if c.ReferenceAnnouncement.Subnet != "192.0.2.0/25" || c.ReferenceAnnouncement.OriginAS != 64501 ||
+		len(c.Conflicts) != 1 || c.Conflicts[0].Subnet != "192.0.2.0/24" || c.Conflicts[0].OriginAS != 64500 {
+		t.Errorf("unexpected conflict %+v", c)
+	}
+	if e := out.closed[0]; e.EndReason != "withdrawal" || e.Ended != 1600000003 {
+		t.Errorf("conflict ended with %s at %d", e.EndReason, e.Ended)
+	}
+}
+
+func TestReplayFiles(t *testing.T) {
+	directory := t.TempDir()
+	for _, name := range []string{"ris-live.20200913.1300.ndjson.gz", "ris-live.20200913.1200.ndjson.gz", "other.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(directory, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := replayFiles(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != filepath.Join(directory, "ris-live.20200913.1200.ndjson.gz") {
+		t.Errorf("files to replay: %v", files)
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/osrg/gobgp/pkg/packet/bgp"
+	"io"
 	"net"
 
 	"github.com/osrg/gobgp/pkg/packet/mrt"
 	"log"
 	"time"
+
+	"bgp-hijack-detection/detector"
 )
 
 var bgpd BGPDump
@@ -16,17 +20,23 @@ type BGPDump struct {
 	Date time.Time
 }
 
-func (b *BGPDump) parseRIBAndInsert(ribFileName string) error {
-	scanner, err := getRightScanner(ribFileName)
+// parseRIBAndInsert inserts all entries of a RIB file. Supported are TABLE_DUMP (v1) and the unicast RIB subtypes of TABLE_DUMP_V2
+// (including the Add-Path subtypes of RFC 8050)
+func (b *BGPDump) parseRIBAndInsert(ribFileName string, collector string) error {
+	setInputSource("rib", nil)
+	scanner, closer, err := getRightScanner(ribFileName)
 	if err != nil {
 		return err
 	}
+	defer closer.Close()
 	scanner.Split(mrt.SplitMrt)
 
 	countSplitsInRIB := 0
 	countMrtRibs := 0
 	countSingleEntries := 0
+	countSkipped := 0
 	indexTableCount := 0
+	var peerIDs []uint16 //peer index in the RIB -> our peer ID
 entries:
 	for scanner.Scan() {
 		countSplitsInRIB++
@@ -35,7 +45,7 @@ entries:
 			fmt.Println("Too many entries. STOPPING....")
 			break
 		}
-		var m message
+		var a detector.Announcement
 
 		data := scanner.Bytes()
 		hdr := &mrt.MRTHeader{}
@@ -43,100 +53,131 @@ entries:
 		if errh != nil { //changed to errh (before there stood err (probably a mistake)
 			return errh
 		}
-		msg, err := mrt.ParseMRTBody(hdr, data[mrt.MRT_COMMON_HEADER_LEN:])
-		if err != nil {
-			log.Printf("could not parse mrt body: %v", err)
-			continue entries
-		}
-		if msg.Header.Type != mrt.TABLE_DUMPv2 {
-			return fmt.Errorf("unexpected message type: %d", msg.Header.Type)
-		}
+		body := data[mrt.MRT_COMMON_HEADER_LEN:]
 
-		//m.timestamp = msg.Header.Timestamp
-		m.isAnnouncement = true //RIBS are all announcements
-		//m.bgpsubtype = msg.Header.SubType
-
-		switch mtrBody := msg.Body.(type) {
-
-		case *mrt.PeerIndexTable:
+		var record ribRecord
+		switch {
+		case hdr.Type == mrt.TABLE_DUMP:
+			record, err = decodeTableDumpRib(hdr.SubType, body)
+		case hdr.Type == mrt.TABLE_DUMPv2 && mrt.MRTSubTypeTableDumpv2(hdr.SubType) == mrt.PEER_INDEX_TABLE:
 			indexTableCount++
-			peers = msg.Body.(*mrt.PeerIndexTable).Peers //added
 			if indexTableCount != 1 {
 				return fmt.Errorf("got > 1 PeerIndexTable")
 			}
-			insertPeers()
-
-		case *mrt.Rib:
-			countMrtRibs++
-			rib := msg.Body.(*mrt.Rib)
-			prefix := rib.Prefix
-
-			_, ipnet, err := net.ParseCIDR(prefix.String())
+			msg, err := mrt.ParseMRTBody(hdr, body)
 			if err != nil {
-				fmt.Println(Red("Could not parse to subnet", err))
-			}
-			m.subnet = *ipnet
-			if m.subnet.IP.To4() != nil {
-
-				m.subnetAsBits = convertIPtoBits(m.subnet)
+				return fmt.Errorf("could not parse peer index table: %v", err)
 			}
-			if len(rib.Entries) < 0 {
-				return fmt.Errorf("no entries")
+			//the RIB entries refer to the peers by their index in this table. Several RIBs (of different collectors) share our peer IDs
+			peers := msg.Body.(*mrt.PeerIndexTable).Peers
+			peerIDs = make([]uint16, len(peers))
+			for i, p := range peers {
+				peerIDs[i] = det.Peer(p.IpAddress.String(), p.AS, collector)
 			}
+			continue entries
+		case hdr.Type == mrt.TABLE_DUMPv2:
+			record, err = decodeTableDumpV2Rib(hdr.SubType, body)
+		default:
+			return fmt.Errorf("unexpected message type: %d", hdr.Type)
+		}
+		if err == errNotUnicast {
+			countSkipped++
+			continue entries
+		}
+		if err != nil {
+			countParseError("mrt_rib")
+			log.Printf("could not parse mrt body: %v", err)
+			continue entries
+		}
 
-			for i := 0; i < len(rib.Entries); i++ {
-				countSingleEntries++
-				m.timestamp = uint32(rib.Entries[i].OriginatedTime)
-				m.peerID = ourPeers[rib.Entries[i].PeerIndex].id //during the initialization PeerIndex should already be equal to id
-				setASpathInMessage(&m, rib.Entries[i].PathAttributes)
-
-				insertAndFindConflicts(m, findConflictsInRib)
+		countMrtRibs++
+		a.Subnet = record.subnet //RIBS are all announcements
+		for _, e := range record.entries {
+			countSingleEntries++
+			a.Timestamp = e.originatedTime
+			a.PathID = e.pathID
+			if hdr.Type == mrt.TABLE_DUMP { //TABLE_DUMP has no peer index table, every entry contains its peer
+				a.Peer = det.Peer(e.peerIP, e.peerAS, collector)
+			} else if int(e.peerIndex) < len(peerIDs) {
+				a.Peer = peerIDs[e.peerIndex]
+			} else {
+				fmt.Println(Red("RIB entry refers to unknown peer index ", e.peerIndex))
+				continue
 			}
+			setASpath(&a, e.attributes, 0)
 
-		default:
-			return fmt.Errorf("unsupported message %v %s", mtrBody)
+			announce(a, findConflictsInRib)
 		}
-
 	}
 
 	fmt.Println(Teal("items in RIB file, seperated by MRT splitting function: ", countSplitsInRIB))
-	fmt.Println(Teal("of these items, how many were of type mrt.Ribs:         ", countMrtRibs))
+	fmt.Println(Teal("of these items, how many were unicast RIB records:       ", countMrtRibs))
 	fmt.Println(Teal("all together there were how many single RIB entries:    ", countSingleEntries))
+	fmt.Println(Teal("skipped multicast RIB records:                           ", countSkipped))
 	fmt.Println(Teal("found peer index tables:                                 ", indexTableCount))
 	return nil
 }
 
 var messages BGPDump
 
-func (b *BGPDump) parseUpdatesAndInsert(inputFile string, findConflicts bool) error {
-	scanner, err := getRightScanner(inputFile)
-	if err != nil {
-		return err
-	}
-	scanner.Split(mrt.SplitMrt)
+// mrtUpdateStream reads the MRT records of all updates files of one collector one after another
+type mrtUpdateStream struct {
+	collector string
+	files     []string
+	scanner   *bufio.Scanner
+	closer    io.Closer       //closes the file of the scanner
+	next      *mrt.MRTMessage //the next record of this collector, nil if all files are read
+}
+
+func newMrtUpdateStream(collector string, files []string) *mrtUpdateStream {
+	s := &mrtUpdateStream{collector: collector, files: files}
+	s.advance()
+	return s
+}
 
-	//some non-essential logging variables to keep overview
-	countEntries := 0
-	countNotRelevantMRTBody := 0
-	countNotRelevantBGPMsgBody := 0
-	countNeitherUpdateNorWithdrawl := 0
-	//	countConflictTriggers := 0
+func (s *mrtUpdateStream) closeFile() {
+	s.closer.Close()
+	s.scanner = nil
+	s.closer = nil
+}
 
-entries:
-	for scanner.Scan() {
-		if countEntries > 100000000 { //for debugging. vary threshold to desired limit
-			fmt.Println(Red("Too many entries in updates. STOPPING..."))
-			break
+// advance reads the next parsable MRT record. If a file ends, the next file of the collector is opened
+func (s *mrtUpdateStream) advance() {
+	s.next = nil
+	for {
+		for s.scanner == nil {
+			if len(s.files) == 0 {
+				return
+			}
+			fmt.Println(Teal("\nReading update file ", s.files[0]))
+			scanner, closer, err := getRightScanner(s.files[0])
+			s.files = s.files[1:]
+			if err != nil {
+				fmt.Println(Red(err))
+				continue
+			}
+			scanner.Split(mrt.SplitMrt)
+			s.scanner = scanner
+			s.closer = closer
+		}
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				fmt.Println(Red("could not read update file completely: ", err))
+			}
+			s.closeFile()
+			continue
 		}
-		countEntries++
 		//extracting the bytes for the next MRT entry
-		data := scanner.Bytes()
+		data := s.scanner.Bytes()
 
 		//extracting the MRT header from the bytes
 		hdr := &mrt.MRTHeader{}
 		errh := hdr.DecodeFromBytes(data[:mrt.MRT_COMMON_HEADER_LEN])
 		if errh != nil {
-			return errh
+			countParseError("mrt_header")
+			fmt.Println(Red("could not parse mrt header: ", errh, ". Skipping the rest of the file"))
+			s.closeFile()
+			continue
 		}
 
 		/*
@@ -151,132 +192,199 @@ entries:
 			skip = 4              //we will later "jump over" the 4 bytes field containing the microseconds
 			hdr.Type = mrt.BGP4MP //we change the type indicator from BGP4MP_ET to BGP4MP
 		}
+		if hdr.Len < skip {
+			countParseError("mrt_body")
+			fmt.Println(Red("BGP4MP_ET record too short: ", hdr.Len, " bytes"))
+			continue
+		}
 
 		//extracting the MRT Message. The MRT Header is already extracted. The MRT Body is extracted in the following. Both are stored in msg
+		hdr.Len = hdr.Len - skip
 		var msg *mrt.MRTMessage
 		var err error
-		hdr.Len = hdr.Len - skip
-		msg, err = mrt.ParseMRTBody(hdr, data[mrt.MRT_COMMON_HEADER_LEN+skip:])
+		if hdr.Type == mrt.BGP4MP && isAddPathBGP4MP(hdr.SubType) { //the mrt-Module does not parse the path identifiers of Add-Path
+			msg, err = decodeBGP4MPAddPath(hdr, data[mrt.MRT_COMMON_HEADER_LEN+skip:])
+		} else {
+			msg, err = mrt.ParseMRTBody(hdr, data[mrt.MRT_COMMON_HEADER_LEN+skip:])
+		}
 		if err != nil {
+			countParseError("mrt_body")
 			log.Printf("could not parse mrt body: %v", err)
-			continue entries
+			continue
+		}
+		s.next = msg
+		return
+	}
+}
+
+// updatesStatistics are some non-essential logging variables to keep overview
+type updatesStatistics struct {
+	countEntries                   int
+	countNotRelevantMRTBody        int
+	countNotRelevantBGPMsgBody     int
+	countNeitherUpdateNorWithdrawl int
+	countStateChanges              int
+}
+
+// parseUpdatesAndInsert reads the updates of all collectors. The records of the collectors are merged, so that all of them are
+// inserted in the order of their timestamps
+func (b *BGPDump) parseUpdatesAndInsert(streams []*mrtUpdateStream, findConflicts bool) {
+	var stats updatesStatistics
+	setInputSource("updates", nil)
+	for {
+		if stats.countEntries > 100000000 { //for debugging. vary threshold to desired limit
+			fmt.Println(Red("Too many entries in updates. STOPPING..."))
+			break
+		}
+		var earliest *mrtUpdateStream
+		for _, s := range streams {
+			if s.next != nil && (earliest == nil || s.next.Header.Timestamp < earliest.next.Header.Timestamp) {
+				earliest = s
+			}
+		}
+		if earliest == nil {
+			break
 		}
+		stats.countEntries++
+		insertMRTUpdate(earliest.next, earliest.collector, findConflicts, &stats)
+		earliest.advance()
+	}
+	fmt.Println(Teal("read update entries:                                          ", stats.countEntries))
+	fmt.Println(Teal("not parsable MRT body (not of type BGP4MPMessage):            ", stats.countNotRelevantMRTBody))
+	fmt.Println(Teal("peer state changes (BGP4MP_STATE_CHANGE):                     ", stats.countStateChanges))
+	fmt.Println(Teal("not parsable BGP message body (not of type BGPUpdate):        ", stats.countNotRelevantBGPMsgBody))
+	fmt.Println(Teal("BGP update messages with neither announcements nor withdrawls:", stats.countNeitherUpdateNorWithdrawl))
+}
+
+func insertMRTUpdate(msg *mrt.MRTMessage, collector string, findConflicts bool, stats *updatesStatistics) {
+	switch msg.Body.(type) {
+	case *mrt.BGP4MPMessage: //we expect the type of the MRT Body to be of type BGP4MPMessage
+		bgp4msg := msg.Body.(*mrt.BGP4MPMessage)
+		//A BGP4MPMessage contains itself a BGPMessage consisting of a Body and a header
+		bgpmsg := bgp4msg.BGPMessage
+
+		switch bgpmsg.Body.(type) {
+		case *bgp.BGPUpdate: //we expect the body to be of type BGPUpdate
+			bgpmsgBody := bgpmsg.Body.(*bgp.BGPUpdate)
+
+			peerip := bgp4msg.PeerIpAddress.String()
+			peerAs := bgp4msg.PeerAS
 
-		switch msg.Body.(type) {
-		case *mrt.BGP4MPMessage: //we expect the type of the MRT Body to be of type BGP4MPMessage
-			bgp4msg := msg.Body.(*mrt.BGP4MPMessage)
-			//A BGP4MPMessage contains itself a BGPMessage consisting of a Body and a header
-			bgpmsg := bgp4msg.BGPMessage
-
-			switch bgpmsg.Body.(type) {
-			case *bgp.BGPUpdate: //we expect the body to be of type BGPUpdate
-				bgpmsgBody := bgpmsg.Body.(*bgp.BGPUpdate)
-
-				//we start with the creation of a new instance of our Message type with the already extracted attributes
-				var m message
-				m.timestamp = msg.Header.Timestamp
-				//m.bgpsubtype = msg.Header.SubType
-
-				peerip := bgp4msg.PeerIpAddress.String()
-				peerAs := bgp4msg.PeerAS
-
-				m.peerID = findPeerIDbyIP(peerip, peerAs)
-
-				//we make sure that we either have a BGP announcement or withdrawal
-				if len(bgpmsgBody.NLRI) == 0 && len(bgpmsgBody.WithdrawnRoutes) == 0 {
-					countNeitherUpdateNorWithdrawl++
-					fmt.Println(Red("BGP Message seems to be neither Announcement nor Withdrawal: ", bgpmsgBody))
-					continue entries
-				}
-				subnetsAnouncments := bgpmsgBody.NLRI           //if it is an announcement we extract the announced prefixes from NLRI and store them in subnets
-				subnetsWithdrawls := bgpmsgBody.WithdrawnRoutes //if it is a withdrawal we extract the withdrawn prefixes from WithdrawnRoutes and store them in subnets
-
-				for i := 0; i < len(subnetsAnouncments)+len(subnetsWithdrawls); i++ { //for each announced or withdrawn subnet we create a single instance of type message and insert it into our trie
-					var ipnet *net.IPNet
-					var err error
-					if i < len(subnetsAnouncments) {
-						m.isAnnouncement = true
-						_, ipnet, err = net.ParseCIDR(subnetsAnouncments[i].String())
-					} else {
-						m.isAnnouncement = false
-						_, ipnet, err = net.ParseCIDR(subnetsWithdrawls[i-len(subnetsAnouncments)].String())
-					}
-					if err != nil {
-						fmt.Println(Red("Could not parse to subnet", err))
-					}
-
-					m.subnet = *ipnet
-					if m.subnet.IP.To4() != nil {
-
-						m.subnetAsBits = convertIPtoBits(m.subnet)
-					}
-					//if we have an Announcement we also have to set the AS path (and AS4path) attributes
-					if m.isAnnouncement {
-						setASpathInMessage(&m, bgpmsgBody.PathAttributes)
-					}
-
-					//we insert the current message in the IPv4 or IPv6 trie
-					insertAndFindConflicts(m, findConflicts)
-				}
-
-			default:
-				countNotRelevantBGPMsgBody++
+			peerID := det.Peer(peerip, peerAs, collector)
+
+			//if it is an announcement we extract the announced prefixes from NLRI (IPv4) and MP_REACH_NLRI (IPv6)
+			//if it is a withdrawal we extract the withdrawn prefixes from WithdrawnRoutes (IPv4) and MP_UNREACH_NLRI (IPv6)
+			subnetsAnouncments, subnetsWithdrawls := extractPrefixes(bgpmsgBody)
+
+			//we make sure that we either have a BGP announcement or withdrawal
+			if len(subnetsAnouncments) == 0 && len(subnetsWithdrawls) == 0 {
+				stats.countNeitherUpdateNorWithdrawl++
+				countParseError("update_without_prefixes")
+				fmt.Println(Red("BGP Message seems to be neither Announcement nor Withdrawal: ", bgpmsgBody))
+				return
 			}
+
+			insertPrefixesOfUpdate(peerID, msg.Header.Timestamp, subnetsAnouncments, subnetsWithdrawls, bgpmsgBody.PathAttributes, 0, findConflicts)
+
 		default:
-			countNotRelevantMRTBody++
-			fmt.Println(Red("MRT Body is not of type BGP4MPMessage, but of: ", msg.Header.Type))
+			stats.countNotRelevantBGPMsgBody++
+			countParseError("bgp_message_not_update")
+		}
+	case *mrt.BGP4MPStateChange: //the BGP session to a peer changed its state
+		stateChange := msg.Body.(*mrt.BGP4MPStateChange)
+		stats.countStateChanges++
+		if stateChange.OldState == mrt.ESTABLISHED { //the peer left the Established state, all of its routes are gone
+			peerID := det.Peer(stateChange.PeerIpAddress.String(), stateChange.PeerAS, collector)
+			det.FlushPeer(peerID, msg.Header.Timestamp)
 		}
-		//fmt.Println(msg)
-		//fmt.Println()
+	default:
+		stats.countNotRelevantMRTBody++
+		countParseError("mrt_body_not_bgp4mp")
+		fmt.Println(Red("MRT Body is not of type BGP4MPMessage, but of: ", msg.Header.Type))
 	}
-	fmt.Println(Teal("read update entries:                                          ", countEntries))
-	fmt.Println(Teal("not parsable MRT body (not of type BGP4MPMessage):            ", countNotRelevantMRTBody))
-	fmt.Println(Teal("not parsable BGP message body (not of type BGPUpdate):        ", countNotRelevantBGPMsgBody))
-	fmt.Println(Teal("BGP update messages with neither announcements nor withdrawls:", countNeitherUpdateNorWithdrawl))
+}
 
-	return nil
+// insertPrefixesOfUpdate inserts each announced and withdrawn subnet of an update of the peer into the detector.
+// localOrigin is the origin of announcements with an empty AS path (see setASpath)
+func insertPrefixesOfUpdate(peerID uint16, timestamp uint32, subnetsAnouncments []bgp.AddrPrefixInterface, subnetsWithdrawls []bgp.AddrPrefixInterface, attributes []bgp.PathAttributeInterface, localOrigin uint32, findConflicts bool) {
+	for i := 0; i < len(subnetsAnouncments)+len(subnetsWithdrawls); i++ { //for each announced or withdrawn subnet we create a single announcement or withdrawal
+		isAnnouncement := i < len(subnetsAnouncments)
+		var subnet bgp.AddrPrefixInterface
+		if isAnnouncement {
+			subnet = subnetsAnouncments[i]
+		} else {
+			subnet = subnetsWithdrawls[i-len(subnetsAnouncments)]
+		}
+		_, ipnet, err := net.ParseCIDR(subnet.String())
+		if err != nil {
+			fmt.Println(Red("Could not parse to subnet", err))
+			continue
+		}
+
+		//the path ID is 0 if the session does not use Add-Path
+		if !isAnnouncement {
+			withdraw(detector.Withdrawal{Subnet: *ipnet, Peer: peerID, PathID: subnet.PathIdentifier(), Timestamp: timestamp})
+			continue
+		}
+		//for an announcement we also have to set the AS path (and AS4path) attributes
+		a := detector.Announcement{Subnet: *ipnet, Peer: peerID, PathID: subnet.PathIdentifier(), Timestamp: timestamp}
+		setASpath(&a, attributes, localOrigin)
+		announce(a, findConflicts)
+	}
 }
 
-func setASpathInMessage(m *message, attributes []bgp.PathAttributeInterface) { //this function sets the aspath  in a message m
+// extractPrefixes returns the announced and the withdrawn prefixes of an update. IPv4 prefixes are usually carried directly in
+// the update, IPv6 prefixes (and IPv4 prefixes of some speakers) in the MP_REACH_NLRI and MP_UNREACH_NLRI path attributes (RFC 4760).
+func extractPrefixes(update *bgp.BGPUpdate) ([]bgp.AddrPrefixInterface, []bgp.AddrPrefixInterface) {
+	announced := make([]bgp.AddrPrefixInterface, 0, len(update.NLRI))
+	withdrawn := make([]bgp.AddrPrefixInterface, 0, len(update.WithdrawnRoutes))
+	for _, p := range update.NLRI {
+		announced = append(announced, p)
+	}
+	for _, p := range update.WithdrawnRoutes {
+		withdrawn = append(withdrawn, p)
+	}
+	for _, attr := range update.PathAttributes {
+		switch pa := attr.(type) {
+		case *bgp.PathAttributeMpReachNLRI:
+			if (pa.AFI == bgp.AFI_IP || pa.AFI == bgp.AFI_IP6) && pa.SAFI == bgp.SAFI_UNICAST {
+				announced = append(announced, pa.Value...)
+			}
+		case *bgp.PathAttributeMpUnreachNLRI:
+			if (pa.AFI == bgp.AFI_IP || pa.AFI == bgp.AFI_IP6) && pa.SAFI == bgp.SAFI_UNICAST {
+				withdrawn = append(withdrawn, pa.Value...)
+			}
+		}
+	}
+	return announced, withdrawn
+}
+
+/*
+setASpath sets the aspath and the origin of an announcement. A route which originates in the AS of the receiving router has an
+empty AS path on iBGP sessions, then localOrigin (the own AS) is the origin. It is 0 if the session is not an iBGP session.
+*/
+func setASpath(a *detector.Announcement, attributes []bgp.PathAttributeInterface, localOrigin uint32) {
 
 	//In case there are multiple AS paths, we want to choose one of the smallest AS paths
 	minimumLengthOfBestASPath := 10000
 	var bestASPath []uint32
+	bestASPathEndsInSet := false
 	minimumLengthOfBestRealASPath := 10000
 	var bestRealASPath []uint32
+	bestRealASPathEndsInSet := false
 attrs:
 	// we iterate over the attributes
 	for i := 0; i < len(attributes); i++ {
 		switch pa := attributes[i].(type) {
-		case *bgp.PathAttributeAsPath: // we have an AS path
-			if len(pa.Value) < 1 {
+		case *bgp.PathAttributeAsPath: // we have an AS path. Its segments are of kind "As4PathParam" or of the deprecated (but still used) "AsPathParam"
+			path, endsInSet := flattenASPath(pa.Value)
+			if len(path) < 1 { //reminder: there stood 0. this was probably wrong
 				continue attrs
 			}
-			if len(pa.Value) > 1 {
-			}
-
-			if v, ok := pa.Value[0].(*bgp.As4PathParam); ok { // we expect the AS paths to be of kind "AS4PathParam"
-				if len(v.AS) < 1 { //reminder: there stood 0. this was probably wrong
-					continue attrs
-				}
-				if len(v.AS) < minimumLengthOfBestASPath { // we compare the path length to our previous best path length
-					minimumLengthOfBestASPath = len(v.AS)
-					bestASPath = v.AS
-				}
-			}
-			if v, ok := pa.Value[0].(*bgp.AsPathParam); ok { // deprecated but still in use
-				if len(v.AS) < 1 { //reminder there stood 0. this was probably wrong
-					continue attrs
-				}
-				if len(v.AS) < minimumLengthOfBestASPath {
-					minimumLengthOfBestASPath = len(v.AS)
-
-					//we have to cast each uint16  in the slice into an uint32
-					bestASPath = make([]uint32, len(v.AS))
-					for i, v2 := range v.AS {
-						bestASPath[i] = uint32(v2)
-					}
-				}
+			if len(path) < minimumLengthOfBestASPath { // we compare the path length to our previous best path length
+				minimumLengthOfBestASPath = len(path)
+				bestASPath = path
+				bestASPathEndsInSet = endsInSet
 			}
 
 		case *bgp.PathAttributeAs4Path:
@@ -285,34 +393,54 @@ attrs:
 				23456 was introduced. When this AS number appears in an AS path, we also have the AS4path attribute. In this attribute
 				we have the "real" AS path (with 4 byte long AS numbers) stored.
 			*/
-			if len(pa.Value) < 1 {
-				continue attrs
+			segments := make([]bgp.AsPathParamInterface, len(pa.Value))
+			for j, v := range pa.Value {
+				segments[j] = v
 			}
-			pv := pa.Value[0]
-			if len(pv.AS) < 1 {
+			path, endsInSet := flattenASPath(segments)
+			if len(path) < 1 {
 				continue attrs
 			}
-			if len(pv.AS) < minimumLengthOfBestRealASPath {
-				minimumLengthOfBestRealASPath = len(pv.AS)
-				bestRealASPath = pv.AS //hint: if we get problems here, maybe pv.AS is not of type AS4PathParam but ASPathParam
+			if len(path) < minimumLengthOfBestRealASPath {
+				minimumLengthOfBestRealASPath = len(path)
+				bestRealASPath = path
+				bestRealASPathEndsInSet = endsInSet
 			}
 		default:
 			//	fmt.Println(" no PathAttributeAS(4)Path but ", pa)
 		}
 
 	}
-	m.aspath = bestASPath // we set the aspath attribute in message m
+	a.ASPath = bestASPath // we set the aspath attribute in announcement a
+	a.OriginIsASSet = bestASPathEndsInSet
 	if bestASPath != nil {
-		m.origin = bestASPath[len(bestASPath)-1]
+		a.Origin = bestASPath[len(bestASPath)-1]
 	} else {
-		fmt.Println("no best AS path specified. Could not set origin AS")
+		a.Origin = localOrigin
 	}
-	if minimumLengthOfBestRealASPath != 10000 { // if needed, we set the aspath to the "real" ASPath
+	if minimumLengthOfBestRealASPath != 10000 && len(a.ASPath) > 0 { // if needed, we set the aspath to the "real" ASPath
 		realAsPath := make([]uint32, len(bestRealASPath))
 		copy(realAsPath, bestRealASPath)
-		realAsPath = append([]uint32{m.aspath[0]}, realAsPath...) // it seems that the as4path does not include the first AS number. We want to see the full path and prepend this AS number
-		m.aspath = realAsPath
-		m.origin = realAsPath[len(realAsPath)-1]
+		realAsPath = append([]uint32{a.ASPath[0]}, realAsPath...) // it seems that the as4path does not include the first AS number. We want to see the full path and prepend this AS number
+		a.ASPath = realAsPath
+		a.Origin = realAsPath[len(realAsPath)-1]
+		a.OriginIsASSet = bestRealASPathEndsInSet
 	}
 
 }
+
+// flattenASPath concatenates all segments of an AS path. The second return value is true if the last segment is an AS_SET,
+// i.e. if the origin AS is not unambiguous.
+func flattenASPath(segments []bgp.AsPathParamInterface) ([]uint32, bool) {
+	var path []uint32
+	endsInSet := false
+	for _, segment := range segments {
+		as := segment.GetAS()
+		if len(as) == 0 {
+			continue
+		}
+		path = append(path, as...)
+		endsInSet = segment.GetType() == bgp.BGP_ASPATH_ATTR_TYPE_SET || segment.GetType() == bgp.BGP_ASPATH_ATTR_TYPE_CONFED_SET
+	}
+	return path, endsInSet
+}
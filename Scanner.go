@@ -5,17 +5,20 @@ import (
 	"compress/bzip2"
 	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
 
-func getRightScanner(file string) (*bufio.Scanner, error) {
+// getRightScanner opens a (bzip2 or gzip compressed) file. The returned closer closes the file and has to be called when
+// the scanner is not needed anymore
+func getRightScanner(file string) (*bufio.Scanner, io.Closer, error) {
 	var scanner *bufio.Scanner
 
 	//read inpput
 	f, err := os.Open(file)
 	if err != nil {
-		return scanner, err
+		return scanner, nil, err
 	}
 	if strings.HasSuffix(file, "bz2") {
 		bzip2Reader := bzip2.NewReader(f)
@@ -25,12 +28,13 @@ func getRightScanner(file string) (*bufio.Scanner, error) {
 			gzipReader, err := gzip.NewReader(f)
 			if err != nil {
 				fmt.Println("Could not open gz file")
-				return scanner, err
+				f.Close()
+				return scanner, nil, err
 			}
 			scanner = bufio.NewScanner(gzipReader)
 		} else {
 			scanner = bufio.NewScanner(f)
 		}
 	}
-	return scanner, nil
+	return scanner, f, nil
 }
@@ -0,0 +1,324 @@
+package detector
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ammario/ipisp/v2"
+)
+
+/*
+The metadata of the ASes (registry, country, organisation and allocation date) is read from local files, so that no network
+lookup is needed while inserting messages:
+  - RIR delegated-extended statistics (registry|cc|type|start|value|date|status|...): registry, country and allocation date
+  - CAIDA AS Organizations (as-org2info.txt): the name and country of the organisation of each AS
+  - a cache file, which is written when the program stops. It also contains the results of the network lookups
+The files may be compressed with gzip or bzip2. If enabled, ASes without metadata are looked up over the network (ipisp)
+by a separate goroutine. Until the lookup finished, their metadata is shown as "-". Failed lookups are retried a few times.
+*/
+
+// asInfo is the metadata of one AS. Unknown fields are empty
+type asInfo struct {
+	registry  string
+	country   string
+	org       string
+	allocated string //YYYY-MM-DD
+}
+
+// merge fills all fields which are still unknown with the fields of o
+func (a *asInfo) merge(o asInfo) {
+	if a.registry == "" {
+		a.registry = o.registry
+	}
+	if a.country == "" {
+		a.country = o.country
+	}
+	if a.org == "" {
+		a.org = o.org
+	}
+	if a.allocated == "" {
+		a.allocated = o.allocated
+	}
+}
+
+func (a asInfo) complete() bool {
+	return a.registry != "" && a.country != "" && a.org != ""
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// asLookup is the state of the network lookups of one AS
+type asLookup struct {
+	pending  bool      //queued or running
+	done     bool      //the lookup succeeded, even if the result is incomplete
+	failures int       //failed lookups so far
+	retryAt  time.Time //the earliest time of the next lookup after a failure
+}
+
+// A failed lookup is retried after lookupRetryInterval, the next one after twice the interval and so on. After
+// maxLookupAttempts failures the AS is not looked up again until the program restarts
+const lookupRetryInterval = 10 * time.Minute
+const maxLookupAttempts = 4
+
+// asInfoOf returns the metadata of an AS. If it is incomplete, a network lookup is requested
+func (d *Detector) asInfoOf(asn uint32) asInfo {
+	info := d.asInfos[asn]
+	if !info.complete() && d.lookups != nil {
+		l := d.asLookups[asn]
+		if l.pending || l.done || l.failures >= maxLookupAttempts || time.Now().Before(l.retryAt) {
+			return info
+		}
+		select {
+		case d.lookups <- asn:
+			l.pending = true
+			d.asLookups[asn] = l
+		default: //the lookups are only an enrichment. If too many are pending, we rather skip some than block the insertion
+		}
+	}
+	return info
+}
+
+// finishLookup stores the result of a network lookup. Failures are kept, so that the AS is not looked up again right away
+func (d *Detector) finishLookup(asn uint32, info asInfo, err error) {
+	l := d.asLookups[asn]
+	l.pending = false
+	if err != nil {
+		l.failures++
+		l.retryAt = time.Now().Add(lookupRetryInterval << uint(l.failures-1))
+		d.asLookups[asn] = l
+		return
+	}
+	l.done = true
+	d.asLookups[asn] = l
+	d.addASInfo(asn, info)
+}
+
+func (d *Detector) addASInfo(asn uint32, info asInfo) {
+	existing := d.asInfos[asn]
+	existing.merge(info)
+	d.asInfos[asn] = existing
+}
+
+// openMaybeCompressed opens a file, which may be compressed with gzip (.gz) or bzip2 (.bz2)
+func openMaybeCompressed(fileName string) (io.Reader, func(), error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case strings.HasSuffix(fileName, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return gz, func() { f.Close() }, nil
+	case strings.HasSuffix(fileName, ".bz2"):
+		return bzip2.NewReader(f), func() { f.Close() }, nil
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// readASMetadata reads all metadata files of the options. The files come first, the cache only fills the gaps
+func (d *Detector) readASMetadata() {
+	for _, fileName := range d.options.DelegatedFiles {
+		count, err := d.readDelegated(fileName)
+		if err != nil {
+			d.println(red("Could not read delegated stats file ", fileName, ": ", err))
+			continue
+		}
+		d.println(teal("Read ", count, " ASes from delegated stats file ", fileName))
+	}
+	if d.options.AS2OrgFile != "" {
+		count, err := d.readAS2Org(d.options.AS2OrgFile)
+		if err != nil {
+			d.println(red("Could not read AS organizations file: ", err))
+		} else {
+			d.println(teal("Read ", count, " ASes from AS organizations file ", d.options.AS2OrgFile))
+		}
+	}
+	if d.options.ASMetadataCache != "" {
+		count, err := d.readASMetadataCache(d.options.ASMetadataCache)
+		if os.IsNotExist(err) {
+			return //the cache is created when the program stops
+		}
+		if err != nil {
+			d.println(red("Could not read AS metadata cache: ", err))
+		} else {
+			d.println(teal("Read ", count, " ASes from AS metadata cache ", d.options.ASMetadataCache))
+		}
+	}
+}
+
+// readDelegated reads the asn records of a RIR delegated(-extended) statistics file. A record can cover several ASes
+func (d *Detector) readDelegated(fileName string) (int, error) {
+	r, closeFile, err := openMaybeCompressed(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile()
+	count := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) < 7 || strings.HasPrefix(fields[0], "#") || fields[2] != "asn" || fields[1] == "*" {
+			continue //comments, the version line, the summary lines and all IPv4 and IPv6 records
+		}
+		if fields[6] != "allocated" && fields[6] != "assigned" {
+			continue
+		}
+		start, err1 := strconv.ParseUint(fields[3], 10, 32)
+		number, err2 := strconv.ParseUint(fields[4], 10, 32)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		info := asInfo{registry: fields[0], country: fields[1]}
+		if t, err := time.Parse("20060102", fields[5]); err == nil {
+			info.allocated = t.Format("2006-01-02")
+		}
+		for asn := start; asn < start+number; asn++ {
+			d.addASInfo(uint32(asn), info)
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// readAS2Org reads the CAIDA AS Organizations dataset. It consists of an organisation section and an AS section, each
+// introduced by a "# format:" line
+func (d *Detector) readAS2Org(fileName string) (int, error) {
+	r, closeFile, err := openMaybeCompressed(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile()
+	type organisation struct {
+		name    string
+		country string
+	}
+	orgs := make(map[string]organisation)
+	orgOfAS := make(map[uint32]string)
+	var format []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# format:") {
+			format = strings.Split(strings.TrimPrefix(line, "# format:"), "|")
+			continue
+		}
+		if strings.HasPrefix(line, "#") || len(format) == 0 {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		record := make(map[string]string, len(format))
+		for i, name := range format {
+			if i < len(fields) {
+				record[name] = fields[i]
+			}
+		}
+		if aut, ok := record["aut"]; ok {
+			asn, err := strconv.ParseUint(aut, 10, 32)
+			if err == nil {
+				orgOfAS[uint32(asn)] = record["org_id"]
+			}
+		} else if orgID, ok := record["org_id"]; ok {
+			orgs[orgID] = organisation{name: record["org_name"], country: record["country"]}
+		}
+	}
+	for asn, orgID := range orgOfAS {
+		o := orgs[orgID]
+		d.addASInfo(asn, asInfo{org: strings.ReplaceAll(o.name, ",", ""), country: o.country})
+	}
+	return len(orgOfAS), scanner.Err()
+}
+
+// The cache file has one line per AS: asn|registry|country|org|allocated
+func (d *Detector) readASMetadataCache(fileName string) (int, error) {
+	r, closeFile, err := openMaybeCompressed(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile()
+	count := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) != 5 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		asn, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		d.addASInfo(uint32(asn), asInfo{registry: fields[1], country: fields[2], org: fields[3], allocated: fields[4]})
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// WriteASMetadataCache writes the metadata of all ASes involved in conflicts to the cache file of the options (if any)
+func (d *Detector) WriteASMetadataCache() error {
+	if d.options.ASMetadataCache == "" {
+		return nil
+	}
+	d.lock.Lock()
+	defer d.unlock()
+	tmpName := d.options.ASMetadataCache + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString("# asn|registry|country|org|allocated\n")
+	for asn, info := range d.asInfos {
+		if _, ok := d.originCounters[asn]; !ok {
+			continue //the cache should not grow to the size of all files together
+		}
+		w.WriteString(strconv.Itoa(int(asn)) + "|" + info.registry + "|" + info.country + "|" + strings.ReplaceAll(info.org, "|", "") + "|" + info.allocated + "\n")
+	}
+	err = w.Flush()
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, d.options.ASMetadataCache)
+}
+
+// lookupASNs runs in its own goroutine and looks up the ASes requested by asInfoOf over the network
+func (d *Detector) lookupASNs() {
+	for asn := range d.lookups {
+		var info asInfo
+		resp, err := ipisp.LookupASN(context.Background(), ipisp.ASN(asn))
+		if err == nil {
+			info = asInfo{
+				registry: strings.ToLower(resp.Registry),
+				country:  resp.Country,
+				org:      strings.ReplaceAll(resp.ISPName, ",", ""),
+			}
+			if !resp.AllocatedAt.IsZero() {
+				info.allocated = resp.AllocatedAt.Format("2006-01-02")
+			}
+		}
+		d.lock.Lock()
+		if err != nil && d.options.Verbose {
+			d.println(red("Lookup for ASN: ", asn, " did not work: ", err))
+		}
+		d.finishLookup(asn, info, err)
+		d.unlock()
+	}
+}
@@ -0,0 +1,149 @@
+package detector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+	"time"
+)
+
+const testDelegated = `2|ripencc|1700000000|5|19830705|20231114|+0100
+ripencc|*|asn|*|3|summary
+ripencc|*|ipv4|*|1|summary
+ripencc|DE|asn|64500|1|20100101|allocated|abc
+ripencc|NL|asn|64510|3|20120304|assigned|def
+ripencc|FR|asn|64520|1|20120304|available|
+ripencc||asn|64530|1||reserved|
+ripencc|DE|ipv4|10.0.0.0|256|20100101|allocated|abc
+ripencc|GB|asn|x64540|1|20100101|allocated|ghi
+ripencc|IT|asn|64550|1|unknown|allocated|jkl
+`
+
+const testAS2Org = `# name: AS Org
+# format:org_id|changed|org_name|country|source
+ORG-1|20240101|Example, Inc.|US|ARIN
+ORG-2|20240101|Beispiel GmbH|DE|RIPE
+# format:aut|changed|aut_name|org_id|opaque_id|source
+64500|20240101|EXAMPLE-AS|ORG-1|x|ARIN
+64501|20240101|BEISPIEL-AS|ORG-2|y|RIPE
+64502|20240101|ORPHAN-AS|ORG-3|z|RIPE
+invalid|20240101|INVALID-AS|ORG-1|z|RIPE
+`
+
+func TestReadDelegated(t *testing.T) {
+	d := newTestDetector(Options{})
+	count, err := d.readDelegated(writeTestFile(t, "delegated", testDelegated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("read %d ASes, want 5", count)
+	}
+	tests := []struct {
+		asn  uint32
+		want asInfo
+	}{
+		{64500, asInfo{registry: "ripencc", country: "DE", allocated: "2010-01-01"}},
+		{64510, asInfo{registry: "ripencc", country: "NL", allocated: "2012-03-04"}},
+		{64512, asInfo{registry: "ripencc", country: "NL", allocated: "2012-03-04"}}, //last AS of the range
+		{64513, asInfo{}},
+		{64520, asInfo{}}, //available
+		{64530, asInfo{}}, //reserved
+		{64550, asInfo{registry: "ripencc", country: "IT"}},
+	}
+	for _, tt := range tests {
+		if got := d.asInfos[tt.asn]; got != tt.want {
+			t.Errorf("AS %d: %+v, want %+v", tt.asn, got, tt.want)
+		}
+	}
+}
+
+func TestReadAS2Org(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(testAS2Org))
+	gz.Close()
+	for _, fileName := range []string{"as-org2info.txt", "as-org2info.txt.gz"} {
+		content := testAS2Org
+		if fileName == "as-org2info.txt.gz" {
+			content = compressed.String()
+		}
+		d := newTestDetector(Options{})
+		d.addASInfo(64501, asInfo{registry: "ripencc", country: "AT"}) //from a delegated file, which comes first
+		count, err := d.readAS2Org(writeTestFile(t, fileName, content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 3 {
+			t.Errorf("%s: read %d ASes, want 3", fileName, count)
+		}
+		tests := []struct {
+			asn  uint32
+			want asInfo
+		}{
+			{64500, asInfo{org: "Example Inc.", country: "US"}},
+			{64501, asInfo{registry: "ripencc", org: "Beispiel GmbH", country: "AT"}},
+			{64502, asInfo{}}, //organisation not in the file
+		}
+		for _, tt := range tests {
+			if got := d.asInfos[tt.asn]; got != tt.want {
+				t.Errorf("%s: AS %d: %+v, want %+v", fileName, tt.asn, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestASLookupRetry(t *testing.T) {
+	d := newTestDetector(Options{})
+	d.lookups = make(chan uint32, 10) //without the goroutine, the test finishes the lookups itself
+	requested := func() bool {
+		select {
+		case <-d.lookups:
+			return true
+		default:
+			return false
+		}
+	}
+
+	d.asInfoOf(64500)
+	if !requested() {
+		t.Fatal("no lookup for an AS without metadata")
+	}
+	d.asInfoOf(64500)
+	if requested() {
+		t.Error("second lookup while the first one is pending")
+	}
+	for failures := 1; failures <= maxLookupAttempts; failures++ {
+		d.finishLookup(64500, asInfo{}, errors.New("timeout"))
+		d.asInfoOf(64500)
+		if requested() {
+			t.Fatalf("retry right after failure %d", failures)
+		}
+		l := d.asLookups[64500]
+		want := lookupRetryInterval << uint(failures-1) //the interval doubles with each failure
+		if wait := time.Until(l.retryAt); wait <= want-time.Minute || wait > want {
+			t.Errorf("retry in %v after failure %d, want %v", wait, failures, want)
+		}
+		l.retryAt = time.Now().Add(-time.Second)
+		d.asLookups[64500] = l
+		d.asInfoOf(64500)
+		if got := requested(); got != (failures < maxLookupAttempts) {
+			t.Errorf("lookup after the retry interval of failure %d: %v", failures, got)
+		}
+	}
+
+	d.asInfoOf(64501)
+	requested()
+	d.finishLookup(64501, asInfo{registry: "arin", country: "US"}, nil) //incomplete, the org is missing
+	d.asInfoOf(64501)
+	if requested() || d.asInfos[64501].country != "US" {
+		t.Errorf("after a successful lookup: requested again or metadata %+v missing", d.asInfos[64501])
+	}
+
+	d.addASInfo(64502, asInfo{registry: "ripencc", country: "DE", org: "Beispiel GmbH"})
+	d.asInfoOf(64502)
+	if requested() {
+		t.Error("lookup of an AS with complete metadata")
+	}
+}
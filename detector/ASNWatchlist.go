@@ -0,0 +1,121 @@
+package detector
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/*
+The ASNs of the watchlist are our own ASes, listed next to the prefixes of the watchlist:
+	"asns": [{"asn": 64500, "neighbors": [64510, 64520], "tag": "noc@example.net"}]
+The neighbors are all ASes our AS is directly connected to (optional). Alerts of the category "asn-watchlist" are raised for
+  - new-announcement: one of our ASes originates a subnet which it did not originate before
+  - in-conflict:      one of our ASes is the origin of an announcement in a conflict (e.g. our prefix originated by someone else)
+  - forged-origin:    one of our ASes is the origin, but the AS right before it is not one of its neighbors
+  - forged-neighbor:  one of our ASes is right before the origin, but the origin is not one of its neighbors
+The last two require the neighbors. Conflicts matching the allowlist do not raise alerts.
+*/
+
+const (
+	alertNewAnnouncement = "new-announcement"
+	alertInConflict      = "in-conflict"
+	alertForgedOrigin    = "forged-origin"
+	alertForgedNeighbor  = "forged-neighbor"
+)
+
+type watchedASN struct {
+	asn       uint32
+	neighbors []uint32
+	tag       string
+}
+
+type watchASNJSON struct {
+	ASN       vrpASN   `json:"asn"`
+	Neighbors []vrpASN `json:"neighbors"`
+	Tag       string   `json:"tag"`
+}
+
+func (a watchASNJSON) toWatchedASN() watchedASN {
+	w := watchedASN{asn: uint32(a.ASN), tag: a.Tag}
+	for _, asn := range a.Neighbors {
+		w.neighbors = append(w.neighbors, uint32(asn))
+	}
+	return w
+}
+
+func (d *Detector) addWatchedASN(w watchedASN) {
+	d.watchedASNs[w.asn] = &w
+	d.println(green("The following ASN was successfully added to the watchlist: ", w.asn))
+}
+
+// isNewForWatchedASN returns true if the announcement is originated by one of our ASes and no peer carries this subnet
+// with this origin yet. It has to be called before the announcement is inserted
+func (d *Detector) isNewForWatchedASN(root *trieRoot, m message) bool {
+	if _, ok := d.watchedASNs[m.origin]; !ok {
+		return false
+	}
+	return len(root.peersAnnouncing(m.prefix, m.origin)) == 0
+}
+
+// checkASNWatchlist raises the alerts of the ASN watchlist for a single announcement
+func (d *Detector) checkASNWatchlist(m message, isNew bool) {
+	neighbor := neighborOfOrigin(m)
+	if w, ok := d.watchedASNs[m.origin]; ok {
+		if isNew {
+			d.raiseASNAlert(w, alertNewAnnouncement, alertKey{prefix: m.prefix, origin: m.origin, reason: alertNewAnnouncement}, m, nil,
+				"AS "+strconv.Itoa(int(m.origin))+" announces "+m.prefix.toString())
+		}
+		if len(w.neighbors) > 0 && neighbor != 0 && !isContainedInUint32(w.neighbors, neighbor) {
+			d.raiseASNAlert(w, alertForgedOrigin, alertKey{prefix: m.prefix, origin: m.origin, neighbor: neighbor, reason: alertForgedOrigin}, m, nil,
+				"AS "+strconv.Itoa(int(m.origin))+" is the origin behind AS "+strconv.Itoa(int(neighbor))+", which is not one of its neighbors")
+		}
+	}
+	if w, ok := d.watchedASNs[neighbor]; ok && len(w.neighbors) > 0 && !isContainedInUint32(w.neighbors, m.origin) {
+		d.raiseASNAlert(w, alertForgedNeighbor, alertKey{prefix: m.prefix, origin: m.origin, neighbor: neighbor, reason: alertForgedNeighbor}, m, nil,
+			"AS "+strconv.Itoa(int(neighbor))+" is right before AS "+strconv.Itoa(int(m.origin))+", which is not one of its neighbors")
+	}
+}
+
+// checkASNWatchlistConflicts raises an alert for every conflict in which one of our ASes is an origin. It has to be called
+// after the conflicts were registered, so that the alerts carry their IDs
+func (d *Detector) checkASNWatchlistConflicts(c conflicts) {
+	ref := c.referenceAnnouncement
+	for i, m := range c.conflictingMessages {
+		if c.allowlistTag(i) != "" {
+			continue
+		}
+		w, ok := d.watchedASNs[ref.origin]
+		if !ok {
+			if w, ok = d.watchedASNs[m.origin]; !ok {
+				continue
+			}
+		}
+		key := alertKey{prefix: ref.prefix, origin: ref.origin, other: conflictSide{prefix: m.prefix, origin: m.origin}, reason: alertInConflict}
+		d.raiseASNAlert(w, alertInConflict, key, ref, &c.conflictingMessages[i],
+			fmt.Sprintf("%s (AS %d) <-> %s (AS %d)", ref.prefix.toString(), ref.origin, m.prefix.toString(), m.origin))
+	}
+}
+
+func (d *Detector) raiseASNAlert(w *watchedASN, reason string, key alertKey, m message, conflicting *message, details string) {
+	if d.isRepeatedAlert(key, m.timestamp) {
+		return
+	}
+	alert := AlertJSON{
+		Category:     "asn-watchlist",
+		Reason:       reason,
+		Severity:     severityOfReason[reason],
+		Tag:          w.tag,
+		WatchedASN:   int(w.asn),
+		Announcement: d.convertMessageForAlert(m),
+	}
+	if reason == alertForgedOrigin || reason == alertForgedNeighbor {
+		alert.Expected = aspathtoIntSlice(w.neighbors)
+	}
+	if conflicting != nil {
+		alert.Conflicting = d.conflictingForAlert(m, *conflicting)
+	}
+	d.countASNAlerts++
+	d.pendingAlerts = append(d.pendingAlerts, alert)
+	d.println(magenta(fmt.Sprintf("ASN watchlist alert [%s] for AS %d (tag: %s): %s", reason, w.asn, orDash(w.tag), details)))
+	d.println(magenta("  ", m.toString()))
+}
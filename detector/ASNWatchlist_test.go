@@ -0,0 +1,100 @@
+package detector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestASNWatchlistAlerts(t *testing.T) {
+	var alerts []AlertJSON
+	d := newTestDetector(Options{
+		WatchlistFile: writeTestFile(t, "watchlist.json", `{"asns": [{"asn": 64500, "neighbors": [64510, 64520], "tag": "noc@example.net"}]}`),
+		OnAlert:       func(a AlertJSON) { alerts = append(alerts, a) },
+	})
+	steps := []struct {
+		name             string
+		peerIP           string
+		subnet           string
+		aspath           []uint32 //nil: a withdrawal
+		timestamp        uint32
+		findConflicts    bool
+		want             []string
+		conflictingPeers int //peers carrying the other announcement of in-conflict alerts
+	}{
+		{"first announcement", "192.0.2.1", "10.0.0.0/16", []uint32{64496, 64510, 64500}, 1600000000, true, []string{alertNewAnnouncement}, 0},
+		{"announced by a second peer", "192.0.2.2", "10.0.0.0/16", []uint32{64496, 64510, 64500}, 1600000001, true, nil, 0},
+		{"withdrawn by the first peer", "192.0.2.1", "10.0.0.0/16", nil, 1600000002, true, nil, 0},
+		{"withdrawn by the second peer", "192.0.2.2", "10.0.0.0/16", nil, 1600000003, true, nil, 0},
+		{"announced again within the repeat interval", "192.0.2.1", "10.0.0.0/16", []uint32{64496, 64510, 64500}, 1600000010, true, nil, 0},
+		{"withdrawn again", "192.0.2.1", "10.0.0.0/16", nil, 1600000020, true, nil, 0},
+		{"announced again after the repeat interval", "192.0.2.1", "10.0.0.0/16", []uint32{64496, 64510, 64500}, 1600003700, true, []string{alertNewAnnouncement}, 0},
+		{"announced again by the second peer", "192.0.2.2", "10.0.0.0/16", []uint32{64496, 64520, 64500}, 1600003701, true, nil, 0},
+		{"forged origin", "192.0.2.3", "10.1.0.0/16", []uint32{64496, 64530, 64500}, 1600003702, true, []string{alertNewAnnouncement, alertForgedOrigin}, 0},
+		{"forged neighbor", "192.0.2.4", "10.3.0.0/16", []uint32{64496, 64500, 64502}, 1600003703, true, []string{alertForgedNeighbor}, 0},
+		{"behind a real neighbor", "192.0.2.4", "10.3.1.0/24", []uint32{64496, 64500, 64510}, 1600003704, true, nil, 0},
+		{"in conflict", "192.0.2.5", "10.0.1.0/24", []uint32{64496, 64520, 64502}, 1600003705, true, []string{alertInConflict}, 2},
+		{"same conflict seen by another peer", "192.0.2.6", "10.0.1.0/24", []uint32{64496, 64520, 64502}, 1600003706, true, nil, 0},
+		{"from a RIB", "192.0.2.7", "10.4.0.0/16", []uint32{64496, 64510, 64500}, 1600003707, false, nil, 0},
+		{"already in the RIB", "192.0.2.8", "10.4.0.0/16", []uint32{64496, 64510, 64500}, 1600003708, true, nil, 0},
+	}
+	for _, s := range steps {
+		alerts = nil
+		peerID := d.Peer(s.peerIP, 64496, "rrc00")
+		if s.aspath == nil {
+			d.Withdraw(Withdrawal{Subnet: mustParseCIDR(t, s.subnet), Peer: peerID, Timestamp: s.timestamp})
+		} else {
+			d.Announce(Announcement{
+				Subnet:    mustParseCIDR(t, s.subnet),
+				Peer:      peerID,
+				Timestamp: s.timestamp,
+				Origin:    s.aspath[len(s.aspath)-1],
+				ASPath:    s.aspath,
+			}, s.findConflicts)
+		}
+		var reasons []string
+		for _, a := range alerts {
+			reasons = append(reasons, a.Reason)
+			if a.Category != "asn-watchlist" || a.WatchedASN != 64500 || a.Tag != "noc@example.net" {
+				t.Errorf("%s: alert %+v of another category, AS or tag", s.name, a)
+			}
+			if a.Announcement.PeerCount != 1 || len(a.Announcement.Peers) != 1 || a.Announcement.Peers[0].IP != s.peerIP {
+				t.Errorf("%s: %s announcement carried by %d peers %v, want only %s", s.name, a.Reason, a.Announcement.PeerCount, a.Announcement.Peers, s.peerIP)
+			}
+			if (a.Reason == alertForgedOrigin || a.Reason == alertForgedNeighbor) && !reflect.DeepEqual(a.Expected, []int{64510, 64520}) {
+				t.Errorf("%s: expected neighbors %v", s.name, a.Expected)
+			}
+			if a.Reason != alertInConflict {
+				continue
+			}
+			if a.Conflicting == nil || a.Conflicting.OriginAS != 64500 || a.Conflicting.ConflictID == "" || a.Conflicting.PeerCount != s.conflictingPeers || len(a.Conflicting.Peers) != s.conflictingPeers {
+				t.Errorf("%s: conflicting announcement %+v, want origin 64500 carried by %d peers", s.name, a.Conflicting, s.conflictingPeers)
+			}
+		}
+		if !reflect.DeepEqual(reasons, s.want) {
+			t.Errorf("%s: alerts %v, want %v", s.name, reasons, s.want)
+		}
+	}
+	if d.countASNAlerts != 6 {
+		t.Errorf("ASN alerts = %d, want 6", d.countASNAlerts)
+	}
+}
+
+// The decision whether an announcement is new has to be made before it is inserted, as afterwards its own peer carries it
+func TestIsNewForWatchedASN(t *testing.T) {
+	d := newTestDetector(Options{WatchlistFile: writeTestFile(t, "watchlist.json", `{"asns": [{"asn": 64500}]}`)})
+	m := message{prefix: convertIPtoPrefix(mustParseCIDR(t, "10.0.0.0/16")), origin: 64500, aspath: []uint32{64496, 64500},
+		peerID: d.Peer("192.0.2.1", 64496, "rrc00"), timestamp: 1600000000, isAnnouncement: true}
+	root := d.rootFor(m.prefix)
+	if !d.isNewForWatchedASN(root, m) {
+		t.Error("not new before the insertion")
+	}
+	root.insert(d, m)
+	if d.isNewForWatchedASN(root, m) {
+		t.Error("still new after the insertion")
+	}
+	other := m
+	other.origin = 64501
+	if d.isNewForWatchedASN(root, other) {
+		t.Error("new for an AS which is not watched")
+	}
+}
@@ -0,0 +1,230 @@
+package detector
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+const originOfLessSpecific = 0
+const sameSubnet = 1
+const originOfMoreSpecific = 2
+
+type originCounter struct {
+	asn                 uint32
+	counterLessSpecific uint32 //potential victim
+	counterMoreSpecific uint32 //potential attacker
+	counterSameSubnet   uint32 //
+
+	counterLessSpecificRecent    uint32 //potential victim
+	counterMoreSpecificRecent    uint32 //potential attacker
+	counterSameSubnetRecent      uint32 //
+	topographicallyRelatedRecent uint32
+
+	topographicallyRelated uint32
+	rpkiInvalid            uint32 //number of conflicts in which the announcement of this AS was RPKI-invalid
+}
+
+func (d *Detector) updateOriginCounter(asn uint32, level uint32, inPathofOther bool, rpkiInvalid bool) {
+	v, ok := d.originCounters[asn]
+	if ok {
+		switch level {
+		case originOfLessSpecific:
+			v.counterLessSpecific++
+			v.counterLessSpecificRecent++
+		case sameSubnet:
+			v.counterSameSubnet++
+			v.counterSameSubnetRecent++
+		case originOfMoreSpecific:
+			v.counterMoreSpecific++
+			v.counterMoreSpecificRecent++
+		}
+		if inPathofOther {
+			v.topographicallyRelated++
+			v.topographicallyRelatedRecent++
+		}
+		if rpkiInvalid {
+			v.rpkiInvalid++
+		}
+
+	} else {
+		d.asInfoOf(asn) //the metadata is looked up in the background, if it is not known yet
+		newCounter := originCounter{
+			asn: asn,
+		}
+		switch level {
+		case originOfLessSpecific:
+			newCounter.counterLessSpecific++
+			newCounter.counterLessSpecificRecent++
+		case sameSubnet:
+			newCounter.counterSameSubnet++
+			newCounter.counterSameSubnetRecent++
+		case originOfMoreSpecific:
+			newCounter.counterMoreSpecific++
+			newCounter.counterMoreSpecificRecent++
+		}
+		if inPathofOther {
+			newCounter.topographicallyRelated++
+			newCounter.topographicallyRelatedRecent++
+		}
+		if rpkiInvalid {
+			newCounter.rpkiInvalid++
+		}
+		d.originCounters[asn] = &newCounter
+
+	}
+}
+
+// ResetRecentOriginCounters starts a new interval for the recent counters of the origin ASes
+func (d *Detector) ResetRecentOriginCounters() {
+	d.lock.Lock()
+	defer d.unlock()
+	for as := range d.originCounters {
+		d.originCounters[as].counterSameSubnetRecent = 0
+		d.originCounters[as].counterMoreSpecificRecent = 0
+		d.originCounters[as].counterLessSpecificRecent = 0
+		d.originCounters[as].topographicallyRelatedRecent = 0
+	}
+}
+
+func (d *Detector) updateSummary(c conflicts) {
+	m1 := c.referenceAnnouncement
+	m1Invalid := m1.rpki.isInvalid()
+	for i, m2 := range c.conflictingMessages {
+		if c.allowlistTag(i) != "" {
+			continue //known benign, so neither side is a potential victim or attacker
+		}
+		inPathOfOther := oneOriginInASpathOfOther(m1, m2)
+		m2Invalid := m2.rpki.isInvalid()
+
+		if m1.prefix.length == m2.prefix.length {
+			d.updateOriginCounter(m1.origin, sameSubnet, inPathOfOther, m1Invalid)
+			d.updateOriginCounter(m2.origin, sameSubnet, inPathOfOther, m2Invalid)
+		}
+		if m1.prefix.length > m2.prefix.length {
+			d.updateOriginCounter(m1.origin, originOfMoreSpecific, inPathOfOther, m1Invalid)
+			d.updateOriginCounter(m2.origin, originOfLessSpecific, inPathOfOther, m2Invalid)
+		}
+		if m1.prefix.length < m2.prefix.length {
+			d.updateOriginCounter(m1.origin, originOfLessSpecific, inPathOfOther, m1Invalid)
+			d.updateOriginCounter(m2.origin, originOfMoreSpecific, inPathOfOther, m2Invalid)
+		}
+
+	}
+}
+
+// PrintShortSummary prints the origin ASes which were most often involved in conflicts
+func (d *Detector) PrintShortSummary() {
+	d.lock.Lock()
+	defer d.unlock()
+	asSlice := make([]uint32, 0, len(d.originCounters))
+	for as := range d.originCounters {
+		asSlice = append(asSlice, as)
+	}
+
+	d.println()
+
+	d.println(teal("----------------------------------------------------------------------------------------------------------------------------------"))
+	d.println(teal("Short Summary of mostly involved ASes"))
+	d.println()
+	d.println(white("Number of ASes involved in conflicts: ", len(d.originCounters)))
+	d.println()
+	d.println(teal("Most often as origin in the less specific message (potential victim)"))
+	sort.Slice(asSlice, func(i, j int) bool {
+		return d.originCounters[asSlice[i]].counterLessSpecific > d.originCounters[asSlice[j]].counterLessSpecific
+	})
+	d.printTopAS(10, asSlice)
+
+	d.println()
+	d.println(teal("Most often as origin in the more specific message (potential attacker)"))
+	sort.Slice(asSlice, func(i, j int) bool {
+		return d.originCounters[asSlice[i]].counterMoreSpecific > d.originCounters[asSlice[j]].counterMoreSpecific
+	})
+	d.printTopAS(10, asSlice)
+
+	d.println()
+	d.println(teal("Most often as origin in conflict with conflicting announcements for same subnet"))
+	sort.Slice(asSlice, func(i, j int) bool {
+		return d.originCounters[asSlice[i]].counterSameSubnet > d.originCounters[asSlice[j]].counterSameSubnet
+	})
+	d.printTopAS(10, asSlice)
+
+	d.println()
+	d.println(teal("Most often as origin in conflicts without topological relation based on AS path"))
+	sort.Slice(asSlice, func(i, j int) bool {
+		return d.originCounters[asSlice[i]].counterMoreSpecific+d.originCounters[asSlice[i]].counterSameSubnet+d.originCounters[asSlice[i]].counterLessSpecific-d.originCounters[asSlice[i]].topographicallyRelated > d.originCounters[asSlice[j]].counterMoreSpecific+d.originCounters[asSlice[j]].counterSameSubnet+d.originCounters[asSlice[j]].counterLessSpecific-d.originCounters[asSlice[j]].topographicallyRelated
+	})
+	d.printTopAS(10, asSlice)
+
+	if d.vrpsByPrefix != nil {
+		d.println()
+		d.println(teal("Most often as origin of an RPKI-invalid announcement in conflicts"))
+		sort.Slice(asSlice, func(i, j int) bool {
+			return d.originCounters[asSlice[i]].rpkiInvalid > d.originCounters[asSlice[j]].rpkiInvalid
+		})
+		d.printTopAS(10, asSlice)
+	}
+
+}
+
+func (d *Detector) printTopAS(n int, asSlice []uint32) {
+	for i := 0; i < min(n, len(asSlice)); i++ {
+		counterTotal := d.originCounters[asSlice[i]].counterLessSpecific + d.originCounters[asSlice[i]].counterSameSubnet + d.originCounters[asSlice[i]].counterMoreSpecific
+		legitPercentage := (float32(d.originCounters[asSlice[i]].topographicallyRelated) / float32(counterTotal)) * 100
+		d.println(white("AS ", asSlice[i],
+			" -> victim: ", d.originCounters[asSlice[i]].counterLessSpecific, ", same subnet: ", d.originCounters[asSlice[i]].counterSameSubnet, ", attacker: ", d.originCounters[asSlice[i]].counterMoreSpecific,
+			" [total: ", counterTotal, ", legit ", legitPercentage, "%, rpki-invalid: ", d.originCounters[asSlice[i]].rpkiInvalid, "]"))
+
+		info := d.asInfoOf(asSlice[i])
+		fmt.Fprintf(d.out, "   [%+v, %+v, %+v]:  %+v  \n\n", orDash(info.allocated), orDash(info.registry), orDash(info.country), orDash(info.org))
+	}
+}
+
+// WriteOriginFrequencies writes the counters of all origin ASes involved in conflicts as CSV, ordered by their total
+func (d *Detector) WriteOriginFrequencies(w io.Writer) error {
+	return d.writeOriginFrequencies(w, false)
+}
+
+// WriteRecentOriginFrequencies writes the counters of the origin ASes since the last ResetRecentOriginCounters as CSV
+func (d *Detector) WriteRecentOriginFrequencies(w io.Writer) error {
+	return d.writeOriginFrequencies(w, true)
+}
+
+func (d *Detector) writeOriginFrequencies(w io.Writer, recent bool) error {
+	d.lock.Lock()
+	defer d.unlock()
+	counts := func(c *originCounter) (lessSpecific uint32, sameSubnet uint32, moreSpecific uint32, related uint32) {
+		if recent {
+			return c.counterLessSpecificRecent, c.counterSameSubnetRecent, c.counterMoreSpecificRecent, c.topographicallyRelatedRecent
+		}
+		return c.counterLessSpecific, c.counterSameSubnet, c.counterMoreSpecific, c.topographicallyRelated
+	}
+	total := func(c *originCounter) uint32 {
+		lessSpecific, sameSubnet, moreSpecific, _ := counts(c)
+		return lessSpecific + sameSubnet + moreSpecific
+	}
+	asSlice := make([]uint32, 0, len(d.originCounters))
+	for as := range d.originCounters {
+		asSlice = append(asSlice, as)
+	}
+	sort.Slice(asSlice, func(i, j int) bool {
+		return total(d.originCounters[asSlice[i]]) > total(d.originCounters[asSlice[j]])
+	})
+
+	_, err := io.WriteString(w, "asn,total,lessSpecificOrigin,sameSubnet,moreSpecificOrigin,legit,registry,country,orgName,allocated\n")
+	if err != nil {
+		return err
+	}
+	for _, as := range asSlice {
+		c := d.originCounters[as]
+		info := d.asInfoOf(as)
+		lessSpecific, sameSubnet, moreSpecific, related := counts(c)
+		_, err = io.WriteString(w, strconv.Itoa(int(c.asn))+","+strconv.Itoa(int(total(c)))+","+strconv.Itoa(int(lessSpecific))+","+strconv.Itoa(int(sameSubnet))+","+strconv.Itoa(int(moreSpecific))+","+strconv.Itoa(int(related))+
+			","+orDash(info.registry)+","+orDash(info.country)+","+orDash(info.org)+","+orDash(info.allocated)+"\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package detector
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+/*
+The allowlist contains known benign conflicts (anycast, customers announcing more specifics of provider space, DDoS
+scrubbing, ...). It has one rule per line, fields separated by whitespace, "#" starts a comment:
+	prefix        192.0.2.0/24     64500,64501  [tag|suppress] [name]   both subnets lie within the prefix and both origins are in the set
+	pair          64500            64502        [tag|suppress] [name]   any conflict between the two origin ASes
+	morespecific  198.51.100.0/22  64503        [tag|suppress] [name]   the more specific subnet lies below the prefix and is originated by the AS
+With "tag" (the default) a matching conflict is still reported, but marked with the name of the rule (by default its
+kind). With "suppress" it is dropped. In both cases it is not counted for the origin ASes.
+*/
+
+type allowlistAction uint8
+
+const (
+	allowlistTag allowlistAction = iota
+	allowlistSuppress
+)
+
+type allowlistRule struct {
+	action  allowlistAction
+	tag     string
+	origins []uint32 //prefix rules: the allowed origins, morespecific rules: the one AS
+}
+
+// allowlist is replaced as a whole when it is reloaded, so it is never changed after it was read
+type allowlist struct {
+	byPrefix       map[prefix][]allowlistRule
+	byMoreSpecific map[prefix][]allowlistRule
+	byPair         map[[2]uint32]allowlistRule //the smaller ASN first
+	rules          int
+}
+
+func orderedPair(a uint32, b uint32) [2]uint32 {
+	if a > b {
+		return [2]uint32{b, a}
+	}
+	return [2]uint32{a, b}
+}
+
+func parseAllowlistPrefix(s string) (prefix, error) {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return prefix{}, err
+	}
+	return convertIPtoPrefix(*ipnet), nil
+}
+
+func parseAllowlistLine(line string) (kind string, args []string, rule allowlistRule, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return "", nil, rule, fmt.Errorf("expected at least 3 fields")
+	}
+	kind = fields[0]
+	args = fields[1:3]
+	rule.tag = kind
+	if len(fields) > 3 {
+		switch fields[3] {
+		case "tag":
+		case "suppress":
+			rule.action = allowlistSuppress
+		default:
+			return "", nil, rule, fmt.Errorf("unknown action %q", fields[3])
+		}
+	}
+	if len(fields) > 4 {
+		rule.tag = fields[4]
+	}
+	if len(fields) > 5 {
+		return "", nil, rule, fmt.Errorf("too many fields")
+	}
+	return kind, args, rule, nil
+}
+
+// readAllowlist parses an allowlist file. Invalid lines are printed and skipped, only a missing file is an error
+func (d *Detector) readAllowlist(fileName string) (*allowlist, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a := &allowlist{
+		byPrefix:       make(map[prefix][]allowlistRule),
+		byMoreSpecific: make(map[prefix][]allowlistRule),
+		byPair:         make(map[[2]uint32]allowlistRule),
+	}
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		kind, args, rule, err := parseAllowlistLine(line)
+		if err == nil {
+			switch kind {
+			case "prefix", "morespecific":
+				var p prefix
+				p, err = parseAllowlistPrefix(args[0])
+				if err != nil {
+					break
+				}
+				for _, s := range strings.Split(args[1], ",") {
+					var asn uint32
+					asn, err = parseASN(s)
+					if err != nil {
+						break
+					}
+					rule.origins = append(rule.origins, asn)
+				}
+				if err != nil {
+					break
+				}
+				if kind == "prefix" {
+					a.byPrefix[p] = append(a.byPrefix[p], rule)
+				} else if len(rule.origins) != 1 {
+					err = fmt.Errorf("a morespecific rule takes exactly one AS")
+				} else {
+					a.byMoreSpecific[p] = append(a.byMoreSpecific[p], rule)
+				}
+			case "pair":
+				var as1, as2 uint32
+				as1, err = parseASN(args[0])
+				if err == nil {
+					as2, err = parseASN(args[1])
+				}
+				if err == nil {
+					a.byPair[orderedPair(as1, as2)] = rule
+				}
+			default:
+				err = fmt.Errorf("unknown kind %q", kind)
+			}
+		}
+		if err != nil {
+			d.println(red("Skipping line ", lineNumber, " of allowlist ", fileName, ": ", err))
+			continue
+		}
+		a.rules++
+	}
+	return a, scanner.Err()
+}
+
+// ReloadAllowlist reads the allowlist file of the options again. On error the previous allowlist stays active
+func (d *Detector) ReloadAllowlist() error {
+	if d.options.AllowlistFile == "" {
+		return nil
+	}
+	a, err := d.readAllowlist(d.options.AllowlistFile) //outside of the lock, insertion continues meanwhile
+	if err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.unlock()
+	d.allowlist = a
+	d.println(green("Allowlist loaded with ", a.rules, " rules"))
+	return nil
+}
+
+// matchAllowlist returns the rule that covers the conflict between the two announcements, if any
+func (d *Detector) matchAllowlist(m1 message, m2 message) (allowlistRule, bool) {
+	a := d.allowlist
+	if a == nil {
+		return allowlistRule{}, false
+	}
+	if rule, ok := a.byPair[orderedPair(m1.origin, m2.origin)]; ok {
+		return rule, true
+	}
+	//the shorter subnet decides, as a rule for it also covers the longer one
+	shorter, longer := m1, m2
+	if shorter.prefix.length > longer.prefix.length {
+		shorter, longer = m2, m1
+	}
+	for length := 0; length <= int(shorter.prefix.length); length++ {
+		for _, rule := range a.byPrefix[shorter.prefix.masked(uint8(length))] {
+			if isContainedInUint32(rule.origins, m1.origin) && isContainedInUint32(rule.origins, m2.origin) {
+				return rule, true
+			}
+		}
+	}
+	if shorter.prefix.length == longer.prefix.length {
+		return allowlistRule{}, false
+	}
+	for length := 0; length < int(longer.prefix.length); length++ {
+		for _, rule := range a.byMoreSpecific[longer.prefix.masked(uint8(length))] {
+			if rule.origins[0] == longer.origin {
+				return rule, true
+			}
+		}
+	}
+	return allowlistRule{}, false
+}
+
+// applyAllowlist removes the suppressed conflicting announcements and tags the remaining allowlisted ones
+func (d *Detector) applyAllowlist(c conflicts) conflicts {
+	if d.allowlist == nil {
+		return c
+	}
+	remaining := make([]message, 0, len(c.conflictingMessages))
+	tags := make([]string, 0, len(c.conflictingMessages))
+	for _, m := range c.conflictingMessages {
+		rule, ok := d.matchAllowlist(c.referenceAnnouncement, m)
+		if !ok {
+			remaining = append(remaining, m)
+			tags = append(tags, "")
+			continue
+		}
+		d.countAllowlisted++
+		if rule.action == allowlistTag {
+			remaining = append(remaining, m)
+			tags = append(tags, rule.tag)
+		}
+	}
+	c.conflictingMessages = remaining
+	c.allowlistTags = tags
+	return c
+}
+
+// allowlistTag returns the tag of the i-th conflicting announcement, "" if it is not allowlisted
+func (c conflicts) allowlistTag(i int) string {
+	if i < len(c.allowlistTags) {
+		return c.allowlistTags[i]
+	}
+	return ""
+}
+
+// hasSuspicious returns true if at least one conflicting announcement is not allowlisted
+func (c conflicts) hasSuspicious() bool {
+	for i := range c.conflictingMessages {
+		if c.allowlistTag(i) == "" {
+			return true
+		}
+	}
+	return false
+}
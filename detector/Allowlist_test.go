@@ -0,0 +1,204 @@
+package detector
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestReadAllowlist(t *testing.T) {
+	content := `# known benign conflicts
+prefix 192.0.2.0/24 64500,64501
+prefix 2001:db8::/32 AS64502 suppress   # trailing comment
+pair 64500 64503 tag anycast
+morespecific 198.51.100.0/22 64504 suppress scrubbing
+morespecific 198.51.100.0/22 64504,64505
+prefix 192.0.2.0/33 64500,64501
+prefix 192.0.2.0/24 64500,x
+pair 64500 64503 ignore
+pair 64500
+pair 64500 64503 tag anycast extra
+customer 64500 64503
+`
+	d := newTestDetector(Options{})
+	a, err := d.readAllowlist(writeTestFile(t, "allowlist", content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.rules != 4 {
+		t.Errorf("rules = %d, want 4 (all invalid lines skipped)", a.rules)
+	}
+	rule, ok := a.byPair[orderedPair(64503, 64500)]
+	if !ok || rule.action != allowlistTag || rule.tag != "anycast" {
+		t.Errorf("pair rule %+v (found: %v)", rule, ok)
+	}
+	rules := a.byMoreSpecific[convertIPtoPrefix(mustParseCIDR(t, "198.51.100.0/22"))]
+	if len(rules) != 1 || rules[0].action != allowlistSuppress || rules[0].tag != "scrubbing" {
+		t.Errorf("morespecific rules %+v", rules)
+	}
+	rules = a.byPrefix[convertIPtoPrefix(mustParseCIDR(t, "2001:db8::/32"))]
+	if len(rules) != 1 || rules[0].action != allowlistSuppress || rules[0].tag != "prefix" || len(rules[0].origins) != 1 || rules[0].origins[0] != 64502 {
+		t.Errorf("IPv6 prefix rules %+v", rules)
+	}
+
+	if _, err := d.readAllowlist(writeTestFile(t, "allowlist", "") + ".missing"); err == nil {
+		t.Error("a missing allowlist file has to be an error")
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	type reported struct {
+		subnet string
+		origin int
+		tag    string
+	}
+	tests := []struct {
+		name      string
+		allowlist string
+		existing  []testAnnouncement
+		new       testAnnouncement
+		want      []reported //the conflicts reported for the new announcement
+		counted   []uint32   //the origin ASes with counters
+	}{
+		{"no rule matches", "prefix 10.0.0.0/8 64500,64502\npair 64500 64502\nmorespecific 10.0.0.0/16 64502",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.1.0/24", 64501},
+			[]reported{{"10.0.0.0/16", 64500, ""}}, []uint32{64500, 64501}},
+		{"prefix tag", "prefix 10.0.0.0/8 64500,64501",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.1.0/24", 64501},
+			[]reported{{"10.0.0.0/16", 64500, "prefix"}}, nil},
+		{"prefix rule equal to the shorter subnet", "prefix 10.0.0.0/16 64500,64501 tag customer",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.1.0/24", 64501},
+			[]reported{{"10.0.0.0/16", 64500, "customer"}}, nil},
+		{"prefix rule covering only the longer subnet", "prefix 10.0.1.0/24 64500,64501",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.1.0/24", 64501},
+			[]reported{{"10.0.0.0/16", 64500, ""}}, []uint32{64500, 64501}},
+		{"prefix suppress", "prefix 10.0.0.0/8 64500,64501 suppress",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.0.0/16", 64501},
+			nil, nil},
+		{"pair in any order", "pair 64501 64500 tag anycast",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.0.0/16", 64501},
+			[]reported{{"10.0.0.0/16", 64500, "anycast"}}, nil},
+		{"pair suppress", "pair 64500 64501 suppress",
+			[]testAnnouncement{{"2001:db8::/32", 64500}}, testAnnouncement{"2001:db8:1::/48", 64501},
+			nil, nil},
+		{"more specific", "morespecific 10.0.0.0/16 64501 tag scrubbing",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.1.0/24", 64501},
+			[]reported{{"10.0.0.0/16", 64500, "scrubbing"}}, nil},
+		{"more specific announced before the less specific", "morespecific 10.0.0.0/16 64501 suppress",
+			[]testAnnouncement{{"10.0.1.0/24", 64501}}, testAnnouncement{"10.0.0.0/16", 64500},
+			nil, nil},
+		{"more specific rule for the less specific origin", "morespecific 10.0.0.0/16 64500",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.1.0/24", 64501},
+			[]reported{{"10.0.0.0/16", 64500, ""}}, []uint32{64500, 64501}},
+		{"more specific rule for the same subnet", "morespecific 10.0.0.0/15 64501",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.0.0/16", 64501},
+			[]reported{{"10.0.0.0/16", 64500, ""}}, []uint32{64500, 64501}},
+		{"only one of several conflicts suppressed", "pair 64500 64501 suppress",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}, {"10.0.0.0/16", 64502}}, testAnnouncement{"10.0.1.0/24", 64501},
+			[]reported{{"10.0.0.0/16", 64502, ""}}, []uint32{64501, 64502}},
+		{"tagged and untagged conflicts", "pair 64500 64501",
+			[]testAnnouncement{{"10.0.0.0/16", 64500}, {"10.0.0.0/16", 64502}}, testAnnouncement{"10.0.1.0/24", 64501},
+			[]reported{{"10.0.0.0/16", 64500, "pair"}, {"10.0.0.0/16", 64502, ""}}, []uint32{64501, 64502}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var found []ConflictJSON
+			d := newTestDetector(Options{
+				AllowlistFile: writeTestFile(t, "allowlist", tt.allowlist),
+				OnConflict:    func(c ConflictJSON) { found = append(found, c) },
+			})
+			announceAll(t, d, tt.existing, false)
+			announceAll(t, d, []testAnnouncement{tt.new}, true)
+
+			var got []reported
+			for _, c := range found {
+				for _, m := range c.Conflicts {
+					got = append(got, reported{m.Subnet, m.OriginAS, m.AllowlistTag})
+				}
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].origin < got[j].origin })
+			if len(got) != len(tt.want) {
+				t.Fatalf("reported %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("reported %+v, want %+v", got, tt.want)
+					break
+				}
+			}
+
+			var counted []uint32
+			for asn := range d.originCounters {
+				counted = append(counted, asn)
+			}
+			sort.Slice(counted, func(i, j int) bool { return counted[i] < counted[j] })
+			if len(counted) != len(tt.counted) {
+				t.Fatalf("counted origins %v, want %v", counted, tt.counted)
+			}
+			for i := range counted {
+				if counted[i] != tt.counted[i] {
+					t.Errorf("counted origins %v, want %v", counted, tt.counted)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestReloadAllowlist(t *testing.T) {
+	if err := newTestDetector(Options{}).ReloadAllowlist(); err != nil {
+		t.Errorf("reload without allowlist file: %v", err)
+	}
+
+	fileName := writeTestFile(t, "allowlist", "pair 64500 64501 tag anycast\n")
+	var found []ConflictJSON
+	d := newTestDetector(Options{AllowlistFile: fileName, OnConflict: func(c ConflictJSON) { found = append(found, c) }})
+	subnet := 0
+	conflict := func() (reported bool, tag string) { //a new conflict between 64500 and 64501
+		subnet++
+		found = nil
+		s := "10." + strconv.Itoa(subnet) + ".0.0/16"
+		announceAll(t, d, []testAnnouncement{{s, 64500}}, false)
+		announceAll(t, d, []testAnnouncement{{s, 64501}}, true)
+		if len(found) == 0 {
+			return false, ""
+		}
+		return true, found[0].Conflicts[0].AllowlistTag
+	}
+
+	if reported, tag := conflict(); !reported || tag != "anycast" {
+		t.Errorf("before the reload: reported %v with tag %q, want tag anycast", reported, tag)
+	}
+	if err := ioutil.WriteFile(fileName, []byte("pair 64500 64501 suppress\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReloadAllowlist(); err != nil {
+		t.Fatal(err)
+	}
+	if reported, _ := conflict(); reported {
+		t.Error("after the reload the conflict has to be suppressed")
+	}
+	if err := os.Remove(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReloadAllowlist(); err == nil {
+		t.Error("the missing file was not reported")
+	}
+	if reported, _ := conflict(); reported {
+		t.Error("after a failed reload the previous allowlist has to stay active")
+	}
+	if err := ioutil.WriteFile(fileName, []byte("# all rules removed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReloadAllowlist(); err != nil {
+		t.Fatal(err)
+	}
+	if reported, tag := conflict(); !reported || tag != "" {
+		t.Errorf("after removing all rules: reported %v with tag %q, want untagged", reported, tag)
+	}
+	if d.countAllowlisted != 3 {
+		t.Errorf("allowlisted conflicts = %d, want 3", d.countAllowlisted)
+	}
+}
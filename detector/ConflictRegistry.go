@@ -0,0 +1,158 @@
+package detector
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+const endedByWithdrawal = "withdrawal"
+const endedByOriginChange = "origin-change"
+const endedByPeerDown = "peer-down"
+
+// conflictSide is one of the two announcements that form a conflict: a subnet together with its origin AS
+type conflictSide struct {
+	prefix prefix
+	origin uint32
+}
+
+// conflictKey identifies a conflict by the prefix pair and the origin pair. sideA is always the "smaller" side (see lessSide)
+type conflictKey struct {
+	sideA conflictSide
+	sideB conflictSide
+}
+
+// conflictRecord keeps track of the lifecycle of one conflict
+type conflictRecord struct {
+	key       conflictKey
+	id        string //unique ID of the conflict, the same in the conflicts and in the closed conflicts output
+	firstSeen uint32 //timestamp of the message which triggered the conflict for the first time
+	lastSeen  uint32 //timestamp of the last message which triggered the conflict
+	peersA    map[uint16]bool
+	peersB    map[uint16]bool
+	tag       string //tag of the matching allowlist rule, "" if none matched
+
+	rpkiInvalid bool //true once one side was RPKI-invalid, as validated when the announcement was inserted
+}
+
+func lessSide(a conflictSide, b conflictSide) bool {
+	if a.prefix.length != b.prefix.length {
+		return a.prefix.length < b.prefix.length
+	}
+	if a.prefix.hi != b.prefix.hi {
+		return a.prefix.hi < b.prefix.hi
+	}
+	if a.prefix.lo != b.prefix.lo {
+		return a.prefix.lo < b.prefix.lo
+	}
+	return a.origin < b.origin
+}
+
+// keyOfConflict returns the key of the conflict between two announcements. The second return value is true if ref is side A
+func keyOfConflict(ref message, m message) (conflictKey, bool) {
+	refSide := conflictSide{prefix: ref.prefix, origin: ref.origin}
+	otherSide := conflictSide{prefix: m.prefix, origin: m.origin}
+	if lessSide(otherSide, refSide) {
+		return conflictKey{sideA: otherSide, sideB: refSide}, false
+	}
+	return conflictKey{sideA: refSide, sideB: otherSide}, true
+}
+
+// newConflictID derives the ID of a conflict from its key and the time it started, so that it stays unique over several runs
+func newConflictID(key conflictKey, firstSeen uint32) string {
+	h := fnv.New64a()
+	fmt.Fprint(h, key.sideA.prefix.toString(), key.sideA.origin, key.sideB.prefix.toString(), key.sideB.origin, firstSeen)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// registerConflicts creates or refreshes the records of all conflicts between the reference announcement and the conflicting messages
+func (d *Detector) registerConflicts(c conflicts) {
+	ref := c.referenceAnnouncement
+	for i, m := range c.conflictingMessages {
+		key, refPeerOnSideA := keyOfConflict(ref, m)
+
+		record, ok := d.activeConflicts[key]
+		if !ok {
+			record = &conflictRecord{
+				key:       key,
+				id:        newConflictID(key, ref.timestamp),
+				firstSeen: ref.timestamp,
+				peersA:    make(map[uint16]bool),
+				peersB:    make(map[uint16]bool),
+			}
+			d.activeConflicts[key] = record
+			d.addToSideIndex(key.sideA, key)
+			d.addToSideIndex(key.sideB, key)
+		}
+		record.lastSeen = ref.timestamp
+		record.tag = c.allowlistTag(i)
+		record.rpkiInvalid = record.rpkiInvalid || ref.rpki.isInvalid() || m.rpki.isInvalid()
+		if refPeerOnSideA {
+			record.peersA[ref.peerID] = true
+			record.peersB[m.peerID] = true
+		} else {
+			record.peersA[m.peerID] = true
+			record.peersB[ref.peerID] = true
+		}
+	}
+}
+
+// observeConflictSide records that a peer (again) announces one side of already known conflicts. This is also the case for
+// announcements which do not trigger a new conflict search, because the same announcement is already active.
+func (d *Detector) observeConflictSide(side conflictSide, peerID uint16, timestamp uint32) {
+	for key := range d.conflictsBySide[side] {
+		record := d.activeConflicts[key]
+		record.lastSeen = timestamp
+		if key.sideA == side {
+			record.peersA[peerID] = true
+		} else {
+			record.peersB[peerID] = true
+		}
+	}
+}
+
+func (d *Detector) addToSideIndex(side conflictSide, key conflictKey) {
+	keys, ok := d.conflictsBySide[side]
+	if !ok {
+		keys = make(map[conflictKey]bool)
+		d.conflictsBySide[side] = keys
+	}
+	keys[key] = true
+}
+
+func (d *Detector) removeFromSideIndex(side conflictSide, key conflictKey) {
+	delete(d.conflictsBySide[side], key)
+	if len(d.conflictsBySide[side]) == 0 {
+		delete(d.conflictsBySide, side)
+	}
+}
+
+// closeConflictsOfSide ends all ongoing conflicts in which the given subnet/origin pair takes part. It is called as soon as
+// no peer announces the subnet with this origin anymore.
+func (d *Detector) closeConflictsOfSide(side conflictSide, timestamp uint32, reason string) {
+	keys, ok := d.conflictsBySide[side]
+	if !ok {
+		return
+	}
+	closed := make([]*conflictRecord, 0, len(keys))
+	for key := range keys {
+		closed = append(closed, d.activeConflicts[key])
+	}
+	sort.Slice(closed, func(i, j int) bool { //deterministic order in the output file
+		return closed[i].firstSeen < closed[j].firstSeen
+	})
+	for _, record := range closed {
+		delete(d.activeConflicts, record.key)
+		d.removeFromSideIndex(record.key.sideA, record.key)
+		d.removeFromSideIndex(record.key.sideB, record.key)
+		d.countClosedConflicts++
+		if !d.isReportedConflict(record) {
+			continue
+		}
+		d.pendingClosedConflicts = append(d.pendingClosedConflicts, d.convertClosedConflictForJSON(record, timestamp, reason))
+		if d.options.Verbose {
+			d.println(white("Conflict ended (", reason, ") after ", timestamp-minUint32(timestamp, record.firstSeen), "s: ",
+				record.key.sideA.prefix.toString(), " (AS ", record.key.sideA.origin, ") <-> ", record.key.sideB.prefix.toString(), " (AS ", record.key.sideB.origin, ")"))
+		}
+	}
+}
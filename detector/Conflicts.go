@@ -0,0 +1,114 @@
+package detector
+
+import "strconv"
+
+type conflicts struct {
+	referencePrefix       prefix  //representing the node from which we want to start to find Conflicts
+	referenceAnnouncement message //BGP update which triggered a conflict
+	conflictingMessages   []message
+	relevant              bool
+
+	referencePeers   []uint16   //all peers which currently announce the subnet of the reference announcement with the same origin
+	conflictingPeers [][]uint16 //the same for each of the conflictingMessages (same index)
+	allowlistTags    []string   //tag of the matching allowlist rule for each of the conflictingMessages, "" if none matched
+}
+
+// addPeerVisibility looks up for each side of the conflicts how many distinct peers currently carry the announcement
+func addPeerVisibility(root *trieRoot, c conflicts) conflicts {
+	c.referencePeers = root.peersAnnouncing(c.referenceAnnouncement.prefix, c.referenceAnnouncement.origin)
+	c.conflictingPeers = make([][]uint16, len(c.conflictingMessages))
+	for i, m := range c.conflictingMessages {
+		c.conflictingPeers[i] = root.peersAnnouncing(m.prefix, m.origin)
+	}
+	return c
+}
+
+func peerVisibilityToStringForOutput(peerIDs []uint16) string {
+	if peerIDs == nil {
+		return ""
+	}
+	return " (seen by " + strconv.Itoa(len(peerIDs)) + " peers)"
+}
+
+// peersToString returns the number of peers together with their IP addresses and ASes
+func (d *Detector) peersToString(peerIDs []uint16) string {
+	result := "seen by " + strconv.Itoa(len(peerIDs)) + " of " + strconv.Itoa(len(d.ourPeers)) + " peers ["
+	for i, id := range peerIDs {
+		if i != 0 {
+			result = result + ", "
+		}
+		if p, ok := d.peermapByID[id]; ok {
+			result = result + p.ip + " (AS " + strconv.Itoa(int(p.as)) + ")"
+		}
+	}
+	return result + "]"
+}
+
+// conflictType classifies a pair of conflicting announcements from the view of the reference announcement
+type conflictType uint8
+
+const (
+	exactPrefixMOAS         conflictType = iota // both announcements are for the same subnet, but with different origins
+	subPrefixHijack                             // the reference announcement is more specific than the conflicting announcement
+	superPrefixAnnouncement                     // the reference announcement is less specific than the conflicting announcement
+	originInPath                                // the origin of one announcement is in the AS path of the other (topological relation, probably legit)
+	asSetOrigin                                 // at least one AS path ends in an AS_SET, hence the origin is not unambiguous
+)
+
+func (t conflictType) toString() string {
+	switch t {
+	case exactPrefixMOAS:
+		return "exact-prefix-moas"
+	case subPrefixHijack:
+		return "sub-prefix-hijack"
+	case superPrefixAnnouncement:
+		return "super-prefix-announcement"
+	case originInPath:
+		return "origin-in-path"
+	case asSetOrigin:
+		return "as-set-origin"
+	}
+	return "unknown"
+}
+
+// oneOriginInASpathOfOther returns true if the origin of one of the two announcements appears in the AS path of the other
+func oneOriginInASpathOfOther(m1 message, m2 message) bool {
+	return isContainedInUint32(m2.aspath, m1.origin) || isContainedInUint32(m1.aspath, m2.origin)
+}
+
+// classifyConflict returns the type of the conflict between the reference announcement and one conflicting announcement
+func classifyConflict(reference message, other message) conflictType {
+	if reference.originIsASSet || other.originIsASSet {
+		return asSetOrigin
+	}
+	if oneOriginInASpathOfOther(reference, other) {
+		return originInPath
+	}
+	if reference.prefix.length > other.prefix.length {
+		return subPrefixHijack
+	}
+	if reference.prefix.length < other.prefix.length {
+		return superPrefixAnnouncement
+	}
+	return exactPrefixMOAS
+}
+
+func (d *Detector) conflictsToString(conf conflicts) string {
+	result := "announcement: " + conf.referenceAnnouncement.toString() + rpkiStateToStringForOutput(conf.referenceAnnouncement) + peerVisibilityToStringForOutput(conf.referencePeers) + "\n"
+	result = result + " the following conflicts were detected: \n"
+
+	for i := 0; i < len(conf.conflictingMessages); i++ {
+
+		result = result + "    [" + classifyConflict(conf.referenceAnnouncement, conf.conflictingMessages[i]).toString() + "] " + conf.conflictingMessages[i].toString() + rpkiStateToStringForOutput(conf.conflictingMessages[i])
+		if tag := conf.allowlistTag(i); tag != "" {
+			result = result + " (allowlisted: " + tag + ")"
+		}
+		if i < len(conf.conflictingPeers) {
+			result = result + peerVisibilityToStringForOutput(conf.conflictingPeers[i])
+		}
+		result = result + "\n"
+
+	}
+	return result
+
+}
@@ -0,0 +1,76 @@
+package detector
+
+import "testing"
+
+func TestClassifyConflict(t *testing.T) {
+	type side struct {
+		subnet  string
+		aspath  []uint32
+		isASSet bool
+	}
+	tests := []struct {
+		name      string
+		reference side
+		other     side
+		want      string
+	}{
+		{"exact prefix MOAS", side{"10.0.0.0/16", []uint32{64496, 64500}, false}, side{"10.0.0.0/16", []uint32{64496, 64501}, false}, "exact-prefix-moas"},
+		{"sub-prefix", side{"10.0.1.0/24", []uint32{64496, 64501}, false}, side{"10.0.0.0/16", []uint32{64496, 64500}, false}, "sub-prefix-hijack"},
+		{"super-prefix", side{"10.0.0.0/16", []uint32{64496, 64501}, false}, side{"10.0.1.0/24", []uint32{64496, 64500}, false}, "super-prefix-announcement"},
+		{"origin in path of the other", side{"10.0.0.0/16", []uint32{64496, 64500, 64501}, false}, side{"10.0.0.0/16", []uint32{64496, 64500}, false}, "origin-in-path"},
+		{"origin of the other in path", side{"10.0.0.0/16", []uint32{64496, 64500}, false}, side{"10.0.0.0/16", []uint32{64496, 64500, 64501}, false}, "origin-in-path"},
+		{"AS_SET of the reference", side{"10.0.0.0/16", []uint32{64496, 64501}, true}, side{"10.0.0.0/16", []uint32{64496, 64500}, false}, "as-set-origin"},
+		{"AS_SET of the other", side{"10.0.0.0/16", []uint32{64496, 64501}, false}, side{"10.0.0.0/16", []uint32{64496, 64500}, true}, "as-set-origin"},
+		{"AS_SET before origin in path", side{"10.0.0.0/16", []uint32{64496, 64500, 64501}, true}, side{"10.0.0.0/16", []uint32{64496, 64500}, false}, "as-set-origin"},
+		{"origin in path before sub-prefix", side{"10.0.1.0/24", []uint32{64496, 64500, 64501}, false}, side{"10.0.0.0/16", []uint32{64496, 64500}, false}, "origin-in-path"},
+		{"origin in path before super-prefix", side{"10.0.0.0/16", []uint32{64496, 64500}, false}, side{"10.0.1.0/24", []uint32{64496, 64500, 64501}, false}, "origin-in-path"},
+		{"AS_SET before sub-prefix", side{"10.0.1.0/24", []uint32{64496, 64501}, false}, side{"10.0.0.0/16", []uint32{64496, 64500}, true}, "as-set-origin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toMessage := func(s side) message {
+				return message{
+					prefix:        convertIPtoPrefix(mustParseCIDR(t, s.subnet)),
+					origin:        s.aspath[len(s.aspath)-1],
+					aspath:        s.aspath,
+					originIsASSet: s.isASSet,
+				}
+			}
+			if got := classifyConflict(toMessage(tt.reference), toMessage(tt.other)).toString(); got != tt.want {
+				t.Errorf("classifyConflict = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddPeerVisibility(t *testing.T) {
+	d := newTestDetector(Options{})
+	announceAll(t, d, []testAnnouncement{{"10.0.0.0/16", 64500}, {"10.0.0.0/16", 64500}, {"10.0.1.0/24", 64501}, {"10.0.1.0/24", 64502}}, false)
+	//a second path of the first peer is not another peer
+	d.Announce(Announcement{Subnet: mustParseCIDR(t, "10.0.0.0/16"), Peer: 0, PathID: 2, Origin: 64500, ASPath: []uint32{64496, 64500}}, false)
+
+	toMessage := func(subnet string, origin uint32) message {
+		return message{prefix: convertIPtoPrefix(mustParseCIDR(t, subnet)), origin: origin}
+	}
+	c := conflicts{
+		referenceAnnouncement: toMessage("10.0.1.0/24", 64501),
+		conflictingMessages:   []message{toMessage("10.0.0.0/16", 64500), toMessage("10.0.2.0/24", 64503)},
+	}
+	c = addPeerVisibility(d.rootFor(c.referenceAnnouncement.prefix), c)
+
+	if len(c.referencePeers) != 1 || c.referencePeers[0] != 2 {
+		t.Errorf("reference peers = %v, want [2]", c.referencePeers)
+	}
+	if len(c.conflictingPeers) != 2 {
+		t.Fatalf("got %d conflicting peer lists, want 2", len(c.conflictingPeers))
+	}
+	if len(c.conflictingPeers[0]) != 2 || c.conflictingPeers[0][0] != 0 || c.conflictingPeers[0][1] != 1 {
+		t.Errorf("conflicting peers = %v, want [0 1]", c.conflictingPeers[0])
+	}
+	if len(c.conflictingPeers[1]) != 0 {
+		t.Errorf("peers of a subnet nobody announces = %v, want none", c.conflictingPeers[1])
+	}
+	if got := peerVisibilityToStringForOutput(c.conflictingPeers[0]); got != " (seen by 2 peers)" {
+		t.Errorf("output = %q", got)
+	}
+}
@@ -0,0 +1,328 @@
+/*
+Package detector implements the detection of potential BGP hijacks: two announcements for subnets with at least one IP
+address in common, but with different origin ASes. All announcements are stored in a path-compressed PATRICIA trie (one
+for IPv4 and one for IPv6), the conflicts are found while inserting an announcement.
+
+A Detector holds its complete state itself, so several independent detectors can run in one process (e.g. one per
+route collector). All methods may be called from several goroutines.
+*/
+package detector
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Options configure a Detector. The zero value is a detector without special prefixes, RPKI and snapshots
+type Options struct {
+	WatchlistFile    string        // our own prefixes with their expected origins, neighbors and max length (JSON, YAML or one prefix per line, see Watchlist.go)
+	VRPFile          string        // Validated ROA Payloads (JSON or CSV export of rpki-client or Routinator) for the RPKI route origin validation
+	RPKIInvalidOnly  bool          // only report conflicts in which at least one announcement is RPKI-invalid. Requires VRPFile
+	SnapshotFile     string        // if set, the state is periodically written to this file
+	SnapshotInterval time.Duration // interval of the snapshots
+	Verbose          bool          // print every conflict, every new peer and every ended conflict
+	DelegatedFiles   []string      // RIR delegated-extended statistics files for the registry, country and allocation date of the ASes
+	AS2OrgFile       string        // CAIDA AS Organizations file for the organisation names of the ASes
+	ASMetadataCache  string        // file with the AS metadata of earlier runs. Read by New and written by WriteASMetadataCache
+	LookupASNs       bool          // look up ASes without (complete) metadata over the network, in a separate goroutine
+	AllowlistFile    string        // known benign conflicts, which are tagged or suppressed (see Allowlist.go). Reloaded by ReloadAllowlist
+	Output           io.Writer     // progress and conflict output, os.Stdout if nil
+
+	OnConflict      func(ConflictJSON)       // called for every announcement which triggered conflicts
+	OnConflictEnded func(ClosedConflictJSON) // called for every conflict that ended
+	OnAlert         func(AlertJSON)          // called for every alert, e.g. of the watchlist
+}
+
+// Detector holds the tries, the peers, the ongoing conflicts and the counters of the origin ASes
+type Detector struct {
+	options Options
+	out     io.Writer
+
+	//the lock protects the whole state. The callbacks are called after it was released again
+	lock                   sync.Mutex
+	pendingConflicts       []ConflictJSON
+	pendingClosedConflicts []ClosedConflictJSON
+	pendingAlerts          []AlertJSON
+	pendingSnapshot        *serializedSnapshot //written to the snapshot file after the lock was released
+
+	ipv4T trieRoot
+	ipv6T trieRoot
+
+	//peers
+	ourPeers         []peer
+	highestPeerId    uint32
+	highestPeerId100 uint32
+	peermapByID      map[uint16]*peer
+	peermapByIP      map[string]*peer
+	routesByPeer     map[uint16]map[*trieNode]bool // per peer index of all trie nodes in which the peer has an active announcement
+
+	//conflicts
+	activeConflicts map[conflictKey]*conflictRecord       //all conflicts that are still ongoing
+	conflictsBySide map[conflictSide]map[conflictKey]bool //to quickly find all ongoing conflicts an announcement is part of
+	allowlist       *allowlist                            //nil without allowlist file
+	watchlist       map[prefix]*watchEntry
+	watchedASNs     map[uint32]*watchedASN
+	alerted         map[alertKey]uint32 //timestamp of the last alert for each key
+
+	originCounters map[uint32]*originCounter
+	asInfos        map[uint32]asInfo
+	lookups        chan uint32 //ASes to look up over the network, nil if disabled
+	asLookups      map[uint32]asLookup
+	vrpsByPrefix   map[prefix][]vrp //all VRPs, indexed by their exact prefix. nil without VRP file
+	countVRPs      int
+
+	nextSnapshotT    time.Time
+	snapshotFileLock sync.Mutex //only one snapshot is written to the file at a time
+
+	countInserted             int
+	countInserted100000       int
+	countWithdrawals          int
+	countTrieNodes            int
+	countActiveAnnouncements  int
+	countConflictTriggers     int
+	countConflictTriggers1000 int
+	countConflicts            int
+	countAllowlisted          int
+	countAlerts               int
+	countASNAlerts            int
+	countClosedConflicts      int
+	countPeerDowns            int
+	countFlushedRoutes        int
+}
+
+// Announcement is the announcement of one subnet by one peer
+type Announcement struct {
+	Subnet        net.IPNet
+	Peer          uint16 // ID of the peer as returned by Detector.Peer
+	PathID        uint32 // Add-Path identifier (RFC 7911), 0 without Add-Path
+	Timestamp     uint32 // unix timestamp
+	Origin        uint32
+	ASPath        []uint32
+	OriginIsASSet bool // true if the AS path ends in an AS_SET. Then Origin is only one of several possible origin ASes
+}
+
+// Withdrawal is the withdrawal of one subnet by one peer
+type Withdrawal struct {
+	Subnet    net.IPNet
+	Peer      uint16
+	PathID    uint32
+	Timestamp uint32
+}
+
+// Statistics are the counters of a Detector
+type Statistics struct {
+	Inserted             int // inserted announcements and withdrawals
+	Withdrawals          int
+	TrieNodes            int // nodes of both tries, including the nodes where two subtries branch off
+	ActiveAnnouncements  int
+	ConflictTriggers     int // announcements which triggered conflicts
+	Conflicts            int // conflicts found (one announcement can trigger several conflicts)
+	AllowlistedConflicts int // conflicts matching the allowlist, both tagged and suppressed ones
+	Alerts               int // alerts of the watchlist of prefixes
+	ASNAlerts            int // alerts of the watchlist of ASNs
+	ActiveConflicts      int // conflicts which are still ongoing
+	ClosedConflicts      int
+	Peers                int
+	PeerDowns            int
+	FlushedRoutes        int // announcements removed because their peer went down
+	OriginASes           int // origin ASes involved in conflicts
+	VRPs                 int
+}
+
+// New creates a detector and reads the special prefixes and the VRPs of the options
+func New(options Options) *Detector {
+	d := &Detector{
+		options:         options,
+		out:             options.Output,
+		peermapByID:     make(map[uint16]*peer),
+		peermapByIP:     make(map[string]*peer),
+		routesByPeer:    make(map[uint16]map[*trieNode]bool),
+		activeConflicts: make(map[conflictKey]*conflictRecord),
+		conflictsBySide: make(map[conflictSide]map[conflictKey]bool),
+		originCounters:  make(map[uint32]*originCounter),
+		asInfos:         make(map[uint32]asInfo),
+		asLookups:       make(map[uint32]asLookup),
+		watchlist:       make(map[prefix]*watchEntry),
+		watchedASNs:     make(map[uint32]*watchedASN),
+		alerted:         make(map[alertKey]uint32),
+		ipv4T:           newTrieRoot(32),
+		ipv6T:           newTrieRoot(128),
+	}
+	if d.out == nil {
+		d.out = os.Stdout
+	}
+	d.readWatchlist()
+	d.readVRPs()
+	d.readASMetadata()
+	if d.options.AllowlistFile != "" {
+		a, err := d.readAllowlist(d.options.AllowlistFile)
+		if err != nil {
+			d.println(red("Could not read allowlist ", d.options.AllowlistFile, ": ", err, ". Continuing without allowlist."))
+		} else {
+			d.allowlist = a
+			d.println(green("Allowlist loaded with ", a.rules, " rules"))
+		}
+	}
+	if d.options.LookupASNs {
+		d.lookups = make(chan uint32, 10000)
+		go d.lookupASNs()
+	}
+	if d.options.RPKIInvalidOnly && d.vrpsByPrefix == nil {
+		d.println(red("RPKIInvalidOnly requires a valid VRP file. Reporting all conflicts instead."))
+		d.options.RPKIInvalidOnly = false
+	}
+	return d
+}
+
+func (d *Detector) println(a ...interface{}) {
+	fmt.Fprintln(d.out, a...)
+}
+
+// unlock releases the lock and then delivers all conflicts found in the meantime to the callbacks. A snapshot taken in the
+// meantime is written to its file as well, so that the disk does not block the other goroutines
+func (d *Detector) unlock() {
+	conflicts := d.pendingConflicts
+	closed := d.pendingClosedConflicts
+	alerts := d.pendingAlerts
+	snapshot := d.pendingSnapshot
+	d.pendingConflicts = nil
+	d.pendingClosedConflicts = nil
+	d.pendingAlerts = nil
+	d.pendingSnapshot = nil
+	d.lock.Unlock()
+	if snapshot != nil {
+		d.writeSnapshotFile(snapshot)
+	}
+	if d.options.OnConflictEnded != nil {
+		for _, c := range closed {
+			d.options.OnConflictEnded(c)
+		}
+	}
+	if d.options.OnConflict != nil {
+		for _, c := range conflicts {
+			d.options.OnConflict(c)
+		}
+	}
+	if d.options.OnAlert != nil {
+		for _, a := range alerts {
+			d.options.OnAlert(a)
+		}
+	}
+}
+
+// Peer returns the ID of the peer with this IP address at the route collector (empty if unknown). Unknown peers are added
+func (d *Detector) Peer(ip string, as uint32, collector string) uint16 {
+	d.lock.Lock()
+	defer d.unlock()
+	return d.findPeerIDbyIP(ip, as, collector)
+}
+
+// Announce inserts an announcement. If findConflicts is false, it only builds up the state (e.g. while reading a RIB)
+func (d *Detector) Announce(a Announcement, findConflicts bool) {
+	var m message
+	m.prefix = convertIPtoPrefix(a.Subnet)
+	m.peerID = a.Peer
+	m.pathID = a.PathID
+	m.timestamp = a.Timestamp
+	m.origin = a.Origin
+	m.aspath = a.ASPath
+	m.originIsASSet = a.OriginIsASSet
+	m.isAnnouncement = true
+	d.lock.Lock()
+	defer d.unlock()
+	if !fitsTrie(a.Subnet) {
+		d.println(red("subnet does not fit into the trie of its address family: \n", m.toString()))
+		return
+	}
+	d.insertAndFindConflicts(m, findConflicts)
+}
+
+// Withdraw removes the announcement of the subnet by the peer (and path)
+func (d *Detector) Withdraw(w Withdrawal) {
+	var m message
+	m.prefix = convertIPtoPrefix(w.Subnet)
+	m.peerID = w.Peer
+	m.pathID = w.PathID
+	m.timestamp = w.Timestamp
+	d.lock.Lock()
+	defer d.unlock()
+	if !fitsTrie(w.Subnet) {
+		d.println(red("subnet does not fit into the trie of its address family: \n", m.toString()))
+		return
+	}
+	d.insertAndFindConflicts(m, false)
+}
+
+// FlushPeer removes all announcements of a peer. It has to be called when the BGP session of the peer left the Established
+// state, as all routes learned over this session are no longer valid then
+func (d *Detector) FlushPeer(peerID uint16, timestamp uint32) {
+	d.lock.Lock()
+	defer d.unlock()
+	d.flushPeer(peerID, timestamp)
+}
+
+// MarkRelevant adds a subnet without any expectations to the watchlist: conflicts involving it (or more specific subnets)
+// are printed immediately
+func (d *Detector) MarkRelevant(subnet net.IPNet) {
+	d.lock.Lock()
+	defer d.unlock()
+	d.addWatchEntry(watchEntry{prefix: convertIPtoPrefix(subnet)})
+}
+
+// Announcements returns all active announcements for exactly this subnet
+func (d *Detector) Announcements(subnet net.IPNet) []MessageJSON {
+	p := convertIPtoPrefix(subnet)
+	d.lock.Lock()
+	defer d.unlock()
+	node := d.rootFor(p).findNode(p)
+	if node == nil {
+		return []MessageJSON{}
+	}
+	result := make([]MessageJSON, 0, len(node.activeAnnouncments))
+	for _, a := range node.activeAnnouncments {
+		m := d.convertMessageForJSON(a)
+		m.Peers = d.convertPeerListForJSON([]uint16{a.peerID})
+		m.PeerCount = 1
+		result = append(result, m)
+	}
+	return result
+}
+
+// Peers returns all peers known to the detector, ordered by their ID
+func (d *Detector) Peers() []PeerJSON {
+	d.lock.Lock()
+	defer d.unlock()
+	ids := make([]uint16, 0, len(d.ourPeers))
+	for _, p := range d.ourPeers {
+		ids = append(ids, p.id)
+	}
+	return d.convertPeerListForJSON(ids)
+}
+
+// Statistics returns the current counters
+func (d *Detector) Statistics() Statistics {
+	d.lock.Lock()
+	defer d.unlock()
+	return Statistics{
+		Inserted:             d.countInserted,
+		Withdrawals:          d.countWithdrawals,
+		TrieNodes:            d.countTrieNodes,
+		ActiveAnnouncements:  d.countActiveAnnouncements,
+		ConflictTriggers:     d.countConflictTriggers,
+		Conflicts:            d.countConflicts,
+		AllowlistedConflicts: d.countAllowlisted,
+		Alerts:               d.countAlerts,
+		ASNAlerts:            d.countASNAlerts,
+		ActiveConflicts:      len(d.activeConflicts),
+		ClosedConflicts:      d.countClosedConflicts,
+		Peers:                len(d.ourPeers),
+		PeerDowns:            d.countPeerDowns,
+		FlushedRoutes:        d.countFlushedRoutes,
+		OriginASes:           len(d.originCounters),
+		VRPs:                 d.countVRPs,
+	}
+}
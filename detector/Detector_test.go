@@ -0,0 +1,113 @@
+package detector_test
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"bgp-hijack-detection/detector"
+)
+
+// countingDetector is a detector which counts the conflicts reported to its callback
+type countingDetector struct {
+	*detector.Detector
+	lock      sync.Mutex
+	conflicts []detector.ConflictJSON
+}
+
+func newCountingDetector(options detector.Options) *countingDetector {
+	c := &countingDetector{}
+	options.Output = ioutil.Discard
+	options.OnConflict = func(conflict detector.ConflictJSON) {
+		c.lock.Lock()
+		c.conflicts = append(c.conflicts, conflict)
+		c.lock.Unlock()
+	}
+	c.Detector = detector.New(options)
+	return c
+}
+
+func (c *countingDetector) countConflicts() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.conflicts)
+}
+
+func announce(t *testing.T, d *detector.Detector, peerIP string, subnet string, origin uint32) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Announce(detector.Announcement{
+		Subnet:    *ipnet,
+		Peer:      d.Peer(peerIP, 64496, "rrc00"),
+		Timestamp: 1600000000,
+		Origin:    origin,
+		ASPath:    []uint32{64496, origin},
+	}, true)
+}
+
+func TestIndependentDetectors(t *testing.T) {
+	a := newCountingDetector(detector.Options{})
+	b := newCountingDetector(detector.Options{})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { //conflicting announcements
+		defer wg.Done()
+		announce(t, a.Detector, "192.0.2.1", "192.0.2.0/24", 64500)
+		announce(t, a.Detector, "192.0.2.2", "192.0.2.0/24", 64501)
+		announce(t, a.Detector, "192.0.2.3", "192.0.2.0/25", 64502)
+	}()
+	go func() { //the same subnets from the same origin
+		defer wg.Done()
+		announce(t, b.Detector, "198.51.100.1", "192.0.2.0/24", 64500)
+		announce(t, b.Detector, "198.51.100.2", "192.0.2.0/25", 64500)
+	}()
+	wg.Wait()
+
+	if got := a.countConflicts(); got != 2 {
+		t.Errorf("detector a reported %d conflicts, want 2", got)
+	}
+	if got := b.countConflicts(); got != 0 {
+		t.Errorf("detector b reported %d conflicts of detector a", got)
+	}
+	if got := len(b.Peers()); got != 2 {
+		t.Errorf("detector b knows %d peers, want its own 2", got)
+	}
+	if got := a.Statistics().Conflicts; got != 3 { //the /25 conflicts with both announcements of the /24
+		t.Errorf("statistics of detector a: %d conflicts, want 3", got)
+	}
+	if got := b.Statistics().Conflicts; got != 0 {
+		t.Errorf("statistics of detector b: %d conflicts, want 0", got)
+	}
+}
+
+func TestPeriodicSnapshot(t *testing.T) {
+	snapshotFile := filepath.Join(t.TempDir(), "snapshot")
+	a := newCountingDetector(detector.Options{SnapshotFile: snapshotFile, SnapshotInterval: time.Nanosecond})
+	announce(t, a.Detector, "192.0.2.1", "192.0.2.0/24", 64500) //the first insertion only starts the interval
+	time.Sleep(time.Millisecond)
+	announce(t, a.Detector, "192.0.2.2", "192.0.2.0/24", 64501)
+
+	restored := newCountingDetector(detector.Options{})
+	if err := restored.RestoreSnapshot(snapshotFile); err != nil {
+		t.Fatal(err)
+	}
+	_, ipnet, _ := net.ParseCIDR("192.0.2.0/24")
+	if got := len(restored.Announcements(*ipnet)); got != 2 {
+		t.Errorf("%d announcements restored from the periodic snapshot, want 2", got)
+	}
+}
+
+func TestFlushUnknownPeer(t *testing.T) {
+	d := newCountingDetector(detector.Options{Verbose: true})
+	announce(t, d.Detector, "192.0.2.1", "10.0.0.0/16", 64500)
+	d.FlushPeer(42, 1600000001) //an ID which was never returned by Peer
+	if s := d.Statistics(); s.PeerDowns != 1 || s.FlushedRoutes != 0 {
+		t.Errorf("peer downs = %d, flushed routes = %d, want 1 and 0", s.PeerDowns, s.FlushedRoutes)
+	}
+}
@@ -0,0 +1,80 @@
+package detector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	red     = color("\033[1;31m%s\033[0m")
+	green   = color("\033[1;32m%s\033[0m")
+	yellow  = color("\033[1;33m%s\033[0m")
+	magenta = color("\033[1;35m%s\033[0m")
+	teal    = color("\033[1;36m%s\033[0m")
+	white   = color("\033[1;37m%s\033[0m")
+)
+
+func color(colorString string) func(...interface{}) string {
+	sprint := func(args ...interface{}) string {
+		return fmt.Sprintf(colorString,
+			fmt.Sprint(args...))
+	}
+	return sprint
+}
+
+func isContainedInUint32(slice []uint32, val uint32) bool { //a generic method to find out if a specific uint32 is contained in a slice of uint32
+	result := false
+	for i := 0; i < len(slice); i++ {
+		if slice[i] == val {
+			result = true
+			break
+		}
+	}
+	return result
+}
+
+func containsUint16(slice []uint16, val uint16) bool {
+	for i := 0; i < len(slice); i++ {
+		if slice[i] == val {
+			return true
+		}
+	}
+	return false
+}
+
+func aspathtoIntSlice(aspath []uint32) []int {
+	aspathAsString := make([]int, len(aspath))
+	for i := 0; i < len(aspath); i++ {
+		aspathAsString[i] = int(aspath[i])
+	}
+	return aspathAsString
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	} else {
+		return b
+	}
+}
+
+func minUint32(a uint32, b uint32) uint32 {
+	if a < b {
+		return a
+	} else {
+		return b
+	}
+}
+
+// seen on StackOverflow: https://stackoverflow.com/questions/12518876/how-to-check-if-a-file-exists-in-go
+func exists(name string) (bool, error) {
+	_, err := os.Stat(name)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,159 @@
+package detector
+
+import "sort"
+
+// MessageJSON is one announcement in a conflict
+type MessageJSON struct {
+	MessageType   string     `json:"messageType"` // announcement or withdrawal
+	Subnet        string     `json:"subnet"`
+	OriginAS      int        `json:"origin"`
+	OriginIsASSet bool       `json:"originIsASSet,omitempty"`
+	Timestamp     int        `json:"timestamp"`
+	Aspath        []int      `json:"aspath"`
+	Rpki          string     `json:"rpki,omitempty"`         // result of RPKI route origin validation, only set if a VRP file is used
+	ConflictType  string     `json:"conflictType,omitempty"` // only set for conflicts, relative to the referenceAnnouncement
+	Collector     string     `json:"collector,omitempty"`    // the route collector of the peer which sent this announcement
+	PathID        int        `json:"pathId,omitempty"`       // Add-Path identifier of the path, only set if the peer uses Add-Path
+	ConflictID    string     `json:"conflictId,omitempty"`   // only set for conflicts, ID of the conflict with the referenceAnnouncement
+	AllowlistTag  string     `json:"allowlistTag,omitempty"` // only set for conflicts matching the allowlist
+	Peer          *PeerJSON  `json:"peer,omitempty"`         // the peer which sent this message
+	PeerCount     int        `json:"peerCount"`              // number of distinct peers currently carrying this announcement
+	Peers         []PeerJSON `json:"peers"`
+}
+
+// ConflictJSON is an announcement together with all announcements it is in conflict with
+type ConflictJSON struct {
+	ReferenceAnnouncement MessageJSON   `json:"referenceAnnouncement"`
+	Conflicts             []MessageJSON `json:"conflicts"`
+}
+
+// AlertJSON is an announcement which does not match what is expected for it
+type AlertJSON struct {
+	Category      string       `json:"category"` // watchlist or asn-watchlist
+	Reason        string       `json:"reason"`   // see Watchlist.go and ASNWatchlist.go
+	Severity      string       `json:"severity"` // SeverityInfo, SeverityWarning or SeverityCritical
+	Tag           string       `json:"tag,omitempty"`
+	WatchedPrefix string       `json:"watchedPrefix,omitempty"` // the watchlist entry the announcement was checked against
+	WatchedASN    int          `json:"watchedAsn,omitempty"`
+	Expected      []int        `json:"expected,omitempty"` // the expected origins, the allowed neighbors or the max length
+	Announcement  MessageJSON  `json:"announcement"`
+	Conflicting   *MessageJSON `json:"conflicting,omitempty"` // the other announcement, if the alert is about a conflict
+}
+
+// PeerJSON is a peer (of a route collector) which carries an announcement
+type PeerJSON struct {
+	IP        string `json:"ip"`
+	AS        int    `json:"as"`
+	Collector string `json:"collector,omitempty"`
+}
+
+// ClosedConflictJSON is a conflict that ended, together with its duration
+type ClosedConflictJSON struct {
+	ID        string     `json:"conflictId"`
+	SubnetA   string     `json:"subnetA"`
+	OriginA   int        `json:"originA"`
+	PeersA    []PeerJSON `json:"peersA"` // all peers which observed the announcement of side A during the conflict
+	SubnetB   string     `json:"subnetB"`
+	OriginB   int        `json:"originB"`
+	PeersB    []PeerJSON `json:"peersB"`
+	FirstSeen int        `json:"firstSeen"`
+	LastSeen  int        `json:"lastSeen"`
+	Ended     int        `json:"ended"`
+	Duration  int        `json:"duration"` // in seconds, from firstSeen till ended
+	EndReason string     `json:"endReason"`
+	Tag       string     `json:"allowlistTag,omitempty"`
+}
+
+func (d *Detector) convertPeersForJSON(peerIDs map[uint16]bool) []PeerJSON {
+	ids := make([]uint16, 0, len(peerIDs))
+	for id := range peerIDs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return d.convertPeerListForJSON(ids)
+}
+
+func (d *Detector) convertPeerListForJSON(peerIDs []uint16) []PeerJSON {
+	result := make([]PeerJSON, 0, len(peerIDs))
+	for _, id := range peerIDs {
+		if p, ok := d.peermapByID[id]; ok {
+			result = append(result, PeerJSON{IP: p.ip, AS: int(p.as), Collector: p.collector})
+		}
+	}
+	return result
+}
+
+func (d *Detector) convertClosedConflictForJSON(r *conflictRecord, ended uint32, reason string) ClosedConflictJSON {
+	duration := 0
+	if ended > r.firstSeen {
+		duration = int(ended - r.firstSeen)
+	}
+	return ClosedConflictJSON{
+		ID:        r.id,
+		SubnetA:   r.key.sideA.prefix.toString(),
+		OriginA:   int(r.key.sideA.origin),
+		PeersA:    d.convertPeersForJSON(r.peersA),
+		SubnetB:   r.key.sideB.prefix.toString(),
+		OriginB:   int(r.key.sideB.origin),
+		PeersB:    d.convertPeersForJSON(r.peersB),
+		FirstSeen: int(r.firstSeen),
+		LastSeen:  int(r.lastSeen),
+		Ended:     int(ended),
+		Duration:  duration,
+		EndReason: reason,
+		Tag:       r.tag,
+	}
+}
+
+func (d *Detector) convertMessageForJSON(m message) MessageJSON {
+	mesJSON := MessageJSON{
+		MessageType:   "withdrawal",
+		Subnet:        m.prefix.toString(),
+		OriginAS:      int(m.origin),
+		OriginIsASSet: m.originIsASSet,
+		Timestamp:     int(m.timestamp),
+		Aspath:        aspathtoIntSlice(m.aspath),
+		Rpki:          m.rpki.toString(),
+		PathID:        int(m.pathID),
+	}
+	if m.isAnnouncement {
+		mesJSON.MessageType = "announcement"
+	}
+	if p, ok := d.peermapByID[m.peerID]; ok {
+		mesJSON.Collector = p.collector
+		mesJSON.Peer = &PeerJSON{IP: p.ip, AS: int(p.as), Collector: p.collector}
+	}
+	return mesJSON
+}
+
+func (d *Detector) convertMessagesForJSON(m []message) []MessageJSON {
+	messages := make([]MessageJSON, len(m))
+	for i := 0; i < len(m); i++ {
+		messages[i] = d.convertMessageForJSON(m[i])
+	}
+	return messages
+}
+
+func (d *Detector) prepareJSON(c conflicts) ConflictJSON {
+	conflicts := d.convertMessagesForJSON(c.conflictingMessages)
+	for i := 0; i < len(conflicts); i++ {
+		conflicts[i].ConflictType = classifyConflict(c.referenceAnnouncement, c.conflictingMessages[i]).toString()
+		conflicts[i].AllowlistTag = c.allowlistTag(i)
+		key, _ := keyOfConflict(c.referenceAnnouncement, c.conflictingMessages[i])
+		if r, ok := d.activeConflicts[key]; ok {
+			conflicts[i].ConflictID = r.id
+		}
+		if i < len(c.conflictingPeers) {
+			conflicts[i].PeerCount = len(c.conflictingPeers[i])
+			conflicts[i].Peers = d.convertPeerListForJSON(c.conflictingPeers[i])
+		}
+	}
+	reference := d.convertMessageForJSON(c.referenceAnnouncement)
+	reference.PeerCount = len(c.referencePeers)
+	reference.Peers = d.convertPeerListForJSON(c.referencePeers)
+
+	return ConflictJSON{
+		ReferenceAnnouncement: reference,
+		Conflicts:             conflicts,
+	}
+}
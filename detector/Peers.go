@@ -0,0 +1,96 @@
+package detector
+
+import "strconv"
+
+type peer struct {
+	id        uint16
+	ip        string //this used to be of type net.IP. This led to a bug as sometimes the IP addresses did change without being intended to do so.
+	as        uint32
+	collector string //the route collector this peer is connected to (e.g. rrc00). Empty if unknown
+}
+
+func (p peer) toString() string {
+	result := ""
+	result = result + "Peer " + strconv.Itoa(int(p.id)) + ": " + p.ip + " (AS " + strconv.Itoa(int(p.as)) + ")"
+	if p.collector != "" {
+		result = result + " at " + p.collector
+	}
+	return result + "\n"
+}
+
+// peerKey is the key for d.peermapByIP. The same router can be a peer of several route collectors, these are different BGP sessions
+func peerKey(ip string, collector string) string {
+	if collector == "" {
+		return ip
+	}
+	return collector + " " + ip
+}
+func (d *Detector) ourPeersToString() string {
+	result := ""
+	for i := 0; i < len(d.ourPeers); i++ {
+		result = result + d.ourPeers[i].toString()
+	}
+	return result
+}
+
+// The d.routesByPeer index of the detector allows to remove all routes of a peer without walking through the whole trie.
+func (d *Detector) addRouteOfPeer(peerID uint16, node *trieNode) {
+	nodes, ok := d.routesByPeer[peerID]
+	if !ok {
+		nodes = make(map[*trieNode]bool)
+		d.routesByPeer[peerID] = nodes
+	}
+	nodes[node] = true
+}
+
+func (d *Detector) removeRouteOfPeer(peerID uint16, node *trieNode) {
+	delete(d.routesByPeer[peerID], node)
+}
+
+// flushPeer removes all announcements of a peer from the tries. It is called when the BGP session of the peer left the
+// Established state, as all routes learned over this session are no longer valid then.
+func (d *Detector) flushPeer(peerID uint16, timestamp uint32) {
+	nodes := d.routesByPeer[peerID]
+	flushed := 0
+	for node := range nodes {
+		removed := node.removeAnnouncementsOfPeer(peerID)
+		flushed = flushed + len(removed)
+		node.closeEndedConflicts(d, removed, timestamp, endedByPeerDown)
+	}
+	delete(d.routesByPeer, peerID)
+	d.countPeerDowns++
+	d.countFlushedRoutes = d.countFlushedRoutes + flushed
+	d.countActiveAnnouncements = d.countActiveAnnouncements - flushed
+	if p, ok := d.peermapByID[peerID]; ok && (d.options.Verbose || flushed > 0) {
+		d.println(yellow("peer down, removed ", flushed, " announcements of ", p.toString()))
+	}
+}
+
+func (d *Detector) findPeerIDbyIP(ip string, as uint32, collector string) uint16 {
+	v, ok := d.peermapByIP[peerKey(ip, collector)]
+	if ok {
+		if v.as != as {
+			d.println(red("Found already existing peer with same ip, but for different as"))
+		}
+		return v.id
+	} else {
+		p := peer{
+			id:        uint16(d.highestPeerId),
+			ip:        ip,
+			as:        as,
+			collector: collector,
+		}
+		if d.options.Verbose {
+			d.println(yellow("new peer added: ", p.toString()))
+		}
+		if d.highestPeerId == d.highestPeerId100*100 {
+			d.highestPeerId100++
+			d.println(yellow("peers added: ", d.highestPeerId))
+		}
+		d.highestPeerId++
+		d.peermapByID[p.id] = &p
+		d.peermapByIP[peerKey(p.ip, p.collector)] = &p
+		d.ourPeers = append(d.ourPeers, p)
+		return p.id
+	}
+}
@@ -0,0 +1,109 @@
+package detector
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net"
+	"strconv"
+)
+
+/*
+A prefix stores a subnet as integer/length pair instead of one byte per bit.
+The address is stored left aligned in 128 bits (hi holds the first 64 bits, lo the last 64 bits).
+An IPv4 address only uses the upper 32 bits of hi.
+All bits after length are always zero.
+*/
+type prefix struct {
+	hi     uint64
+	lo     uint64
+	length uint8
+	isIPv4 bool
+}
+
+// bit returns the bit at position i (0 is the most significant bit)
+func (p prefix) bit(i uint8) uint8 {
+	if i < 64 {
+		return uint8(p.hi>>(63-i)) & 1
+	}
+	return uint8(p.lo>>(127-i)) & 1
+}
+
+// masked returns the prefix shortened to the given length
+func (p prefix) masked(length uint8) prefix {
+	r := prefix{length: length, isIPv4: p.isIPv4}
+	switch {
+	case length == 0:
+	case length < 64:
+		r.hi = p.hi &^ (^uint64(0) >> length)
+	case length == 64:
+		r.hi = p.hi
+	case length < 128:
+		r.hi = p.hi
+		r.lo = p.lo &^ (^uint64(0) >> (length - 64))
+	default:
+		r.hi = p.hi
+		r.lo = p.lo
+	}
+	return r
+}
+
+// commonLength returns the number of leading bits both prefixes have in common (at most the length of the shorter prefix)
+func (p prefix) commonLength(o prefix) uint8 {
+	var common uint8
+	if x := p.hi ^ o.hi; x != 0 {
+		common = uint8(bits.LeadingZeros64(x))
+	} else {
+		common = 64 + uint8(bits.LeadingZeros64(p.lo^o.lo))
+	}
+	if common > p.length {
+		common = p.length
+	}
+	if common > o.length {
+		common = o.length
+	}
+	return common
+}
+
+// contains returns true if o is equal to or more specific than p
+func (p prefix) contains(o prefix) bool {
+	return p.isIPv4 == o.isIPv4 && p.length <= o.length && p.commonLength(o) == p.length
+}
+
+func (p prefix) toIPNet() net.IPNet {
+	if p.isIPv4 {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(p.hi>>32))
+		return net.IPNet{IP: ip, Mask: net.CIDRMask(int(p.length), 32)}
+	}
+	ip := make(net.IP, 16)
+	binary.BigEndian.PutUint64(ip[:8], p.hi)
+	binary.BigEndian.PutUint64(ip[8:], p.lo)
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(int(p.length), 128)}
+}
+
+func (p prefix) toString() string {
+	ipNet := p.toIPNet()
+	return ipNet.IP.String() + "/" + strconv.Itoa(int(p.length))
+}
+
+// convertIPtoPrefix converts a subnet into the integer/length representation that is used as key in the prefix tries
+func convertIPtoPrefix(ipNet net.IPNet) prefix {
+	var p prefix
+	length, _ := ipNet.Mask.Size()
+	if ip4 := ipNet.IP.To4(); ip4 != nil {
+		p.isIPv4 = true
+		p.hi = uint64(binary.BigEndian.Uint32(ip4)) << 32
+		if length > 32 {
+			length = 32
+		}
+	} else {
+		ip6 := ipNet.IP.To16()
+		if ip6 == nil {
+			return p
+		}
+		p.hi = binary.BigEndian.Uint64(ip6[:8])
+		p.lo = binary.BigEndian.Uint64(ip6[8:])
+	}
+	p.length = uint8(length)
+	return p.masked(p.length)
+}
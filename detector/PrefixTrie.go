@@ -0,0 +1,405 @@
+package detector
+
+import (
+	"net"
+	"strconv"
+)
+
+type trieRoot struct { //one root for the IPv4 trie (32 bit) and one for the IPv6 trie (128 bit)
+	node     *trieNode //represents the subnet of length 0 and never holds announcements itself
+	maxDepth uint8
+}
+
+func newTrieRoot(maxDepth uint8) trieRoot {
+	return trieRoot{
+		node:     &trieNode{prefix: prefix{isIPv4: maxDepth == 32}},
+		maxDepth: maxDepth,
+	}
+}
+
+type trie interface {
+	insertMessage(d *Detector, m message) *trie // returns pointer to the node where we ended up inserting the message
+	findConflictsBelow(c conflicts) conflicts
+	findConflictsAboveAndSameLevel(c conflicts) conflicts
+	toStringNode() string
+	toStringNodeAndSubtrie() string
+	isRelevant() bool
+}
+
+func (root *trieRoot) insert(d *Detector, m message) *trie {
+	return root.node.insertMessage(d, m)
+}
+
+// findNode returns the node representing exactly p (or nil if there is none). In contrast to insert no nodes are created
+func (root *trieRoot) findNode(p prefix) *trieNode {
+	node := root.node
+	for node != nil && node.prefix.length < p.length {
+		node = node.children[p.bit(node.prefix.length)]
+	}
+	if node == nil || node.prefix != p {
+		return nil
+	}
+	return node
+}
+
+// peersAnnouncing returns all distinct peers which currently announce subnet p with the given origin
+func (root *trieRoot) peersAnnouncing(p prefix, origin uint32) []uint16 {
+	result := make([]uint16, 0)
+	node := root.findNode(p)
+	if node == nil {
+		return result
+	}
+	for _, a := range node.activeAnnouncments {
+		if a.origin == origin && !containsUint16(result, a.peerID) {
+			result = append(result, a.peerID)
+		}
+	}
+	return result
+}
+
+// rootFor returns the trie responsible for the address family of the subnet
+func (d *Detector) rootFor(p prefix) *trieRoot {
+	if p.isIPv4 {
+		return &d.ipv4T
+	}
+	return &d.ipv6T
+}
+
+// fitsTrie returns true if the subnet can be stored in the trie of its address family (e.g. not an IPv4 address with an IPv6 mask)
+func fitsTrie(subnet net.IPNet) bool {
+	size, bits := subnet.Mask.Size()
+	p := convertIPtoPrefix(subnet)
+	return int(p.length) == size && (bits == 32) == p.isIPv4 && (bits == 32 || bits == 128)
+}
+
+/*
+The trie is a path-compressed (PATRICIA) trie: a node only exists for a subnet that was inserted or where two subtries branch off.
+Nodes in between are skipped, every node knows the full subnet it represents as integer/length pair.
+*/
+type trieNode struct { //represents one subnet in the IPv4 or IPv6 range
+	children           [2]*trieNode //children[0] continues with a 0 bit after prefix, children[1] with a 1 bit
+	parent             *trieNode
+	prefix             prefix
+	activeAnnouncments []message
+	relevant           bool
+}
+
+func (prefixTrie *trieNode) isRelevant() bool {
+	return prefixTrie.relevant
+}
+
+// findOrCreateNode walks down from this node (which has to contain p) and returns the node representing exactly p. Missing nodes are created.
+func (prefixTrie *trieNode) findOrCreateNode(d *Detector, p prefix) *trieNode {
+	node := prefixTrie
+	for node.prefix.length != p.length {
+		nextBit := p.bit(node.prefix.length)
+		child := node.children[nextBit]
+
+		if child == nil { // nothing below in this direction. p becomes a new leaf
+			leaf := &trieNode{parent: node, prefix: p, relevant: node.relevant}
+			node.children[nextBit] = leaf
+			d.countTrieNodes++
+			return leaf
+		}
+
+		common := child.prefix.commonLength(p)
+		if common == child.prefix.length { // the child is a less specific subnet of p (or p itself), so we continue below the child
+			node = child
+			continue
+		}
+
+		// p and the child diverge somewhere on the compressed path. We need a new node at the position where they diverge
+		between := &trieNode{parent: node, prefix: p.masked(common), relevant: node.relevant}
+		between.children[child.prefix.bit(common)] = child
+		child.parent = between
+		node.children[nextBit] = between
+		d.countTrieNodes++
+		if common == p.length { // p itself is the less specific subnet of the child
+			return between
+		}
+		leaf := &trieNode{parent: between, prefix: p, relevant: node.relevant}
+		between.children[p.bit(common)] = leaf
+		d.countTrieNodes++
+		return leaf
+	}
+	return node
+}
+
+// removeAnnouncementsOfPeer removes all active announcements of the given peer for this subnet and returns them
+func (prefixTrie *trieNode) removeAnnouncementsOfPeer(peerID uint16) []message {
+	var removed []message
+	for i := 0; i < len(prefixTrie.activeAnnouncments); i++ {
+		if prefixTrie.activeAnnouncments[i].peerID == peerID {
+			removed = append(removed, prefixTrie.activeAnnouncments[i])
+			prefixTrie.activeAnnouncments = append(prefixTrie.activeAnnouncments[:i], prefixTrie.activeAnnouncments[i+1:]...)
+			i--
+		}
+	}
+	return removed
+}
+
+// removeAnnouncementsOfPath removes the active announcement of the given path of a peer for this subnet and returns it.
+// Without Add-Path every peer has only one path (ID 0) per subnet
+func (prefixTrie *trieNode) removeAnnouncementsOfPath(peerID uint16, pathID uint32) []message {
+	var removed []message
+	for i := 0; i < len(prefixTrie.activeAnnouncments); i++ {
+		if prefixTrie.activeAnnouncments[i].peerID == peerID && prefixTrie.activeAnnouncments[i].pathID == pathID {
+			removed = append(removed, prefixTrie.activeAnnouncments[i])
+			prefixTrie.activeAnnouncments = append(prefixTrie.activeAnnouncments[:i], prefixTrie.activeAnnouncments[i+1:]...)
+			i--
+		}
+	}
+	return removed
+}
+
+// isAnnouncedByPeer returns true if the peer has at least one active announcement (of any path) for this subnet
+func (prefixTrie *trieNode) isAnnouncedByPeer(peerID uint16) bool {
+	for _, a := range prefixTrie.activeAnnouncments {
+		if a.peerID == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+// closeEndedConflicts ends all conflicts of removed announcements whose origin is not announced for this subnet by any peer anymore
+func (prefixTrie *trieNode) closeEndedConflicts(d *Detector, removed []message, timestamp uint32, reason string) {
+	for _, r := range removed {
+		if !prefixTrie.isAnnouncedBy(r.origin) {
+			d.closeConflictsOfSide(conflictSide{prefix: prefixTrie.prefix, origin: r.origin}, timestamp, reason)
+		}
+	}
+}
+
+// isAnnouncedBy returns true if at least one active announcement for this subnet has the given origin
+func (prefixTrie *trieNode) isAnnouncedBy(origin uint32) bool {
+	for _, a := range prefixTrie.activeAnnouncments {
+		if a.origin == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// markRelevant marks this node and all more specific subnets below as relevant
+func (prefixTrie *trieNode) markRelevant() {
+	prefixTrie.relevant = true
+	for _, child := range prefixTrie.children {
+		if child != nil {
+			child.markRelevant()
+		}
+	}
+}
+
+func (prefixTrie *trieNode) insertMessage(d *Detector, m message) *trie {
+	node := prefixTrie.findOrCreateNode(d, m.prefix)
+	var n trie = node
+
+	if m.isSpecialPrefix {
+		node.markRelevant()
+		return &n
+	}
+
+	if m.isAnnouncement {
+		for i := 0; i < len(node.activeAnnouncments); i++ {
+			if node.activeAnnouncments[i].origin == m.origin {
+				m.alreadyAnnounced = true // to prevent the same (still active) conflict to be found over and over again
+			}
+		}
+	}
+
+	replaced := node.removeAnnouncementsOfPath(m.peerID, m.pathID) //if there was an announcement before from same peer (and path) and with another final destination, we update the message
+	d.countActiveAnnouncements = d.countActiveAnnouncements - len(replaced)
+	if m.isAnnouncement {
+		node.activeAnnouncments = append(node.activeAnnouncments, m)
+		d.countActiveAnnouncements++
+		d.addRouteOfPeer(m.peerID, node)
+	} else if len(replaced) > 0 && !node.isAnnouncedByPeer(m.peerID) {
+		d.removeRouteOfPeer(m.peerID, node)
+	}
+	/*
+			A few words about the logic here in the above lines:
+		    in node.announcements all announcements for exactly this subnet are stored. If there was a previous announcement from the same peer as our new message that we are currently inserting,
+			then there are two possibilities:
+			a) the new message is a Withdrawal message. Then the old Announcment by the same peer (and for the same subnet) gets nullified => we delete the old announcement by this peer
+			b) the new message is also un Announcement message. Then the old Announcement can be deleted and the new one (with a newer timestamp) can be stored instead
+			With Add-Path a peer may announce several paths for the same subnet. They are told apart by their path ID, only the path with the same ID is replaced
+	*/
+
+	reason := endedByOriginChange
+	if !m.isAnnouncement {
+		reason = endedByWithdrawal
+	}
+	node.closeEndedConflicts(d, replaced, m.timestamp, reason)
+
+	return &n
+}
+
+func (prefixTrie *trieNode) findConflictsThisLevel(c conflicts) conflicts {
+	if prefixTrie.activeAnnouncments != nil {
+		for i := len(prefixTrie.activeAnnouncments); i > 0; i-- { //we iterate over all announcements. from newest to oldest
+			if c.referenceAnnouncement.origin != prefixTrie.activeAnnouncments[i-1].origin { //it´s not a conflict if the final destination AS is the same
+				//we have found a potential conflict
+
+				alreadyAConflictByDifferentPeer := false
+				for j := 0; j < len(c.conflictingMessages); j++ {
+					if c.conflictingMessages[j].origin == prefixTrie.activeAnnouncments[i-1].origin &&
+						c.conflictingMessages[j].prefix == prefixTrie.activeAnnouncments[i-1].prefix {
+						alreadyAConflictByDifferentPeer = true
+					}
+				}
+				if !alreadyAConflictByDifferentPeer {
+					c.conflictingMessages = append(c.conflictingMessages, prefixTrie.activeAnnouncments[i-1])
+					if prefixTrie.relevant {
+						c.relevant = true
+					}
+				}
+			}
+		}
+	}
+
+	return c
+}
+
+func (prefixTrie *trieNode) findConflictsBelow(c conflicts) conflicts {
+	if c.referenceAnnouncement.prefix.length == prefixTrie.prefix.length { // we are not interested in conflicts at the same level as the reference message (they were already found in findConflictsThislevelAndAbove
+		for i := 0; i < len(prefixTrie.activeAnnouncments); i++ {
+			if prefixTrie.activeAnnouncments[i].peerID == c.referenceAnnouncement.peerID && prefixTrie.activeAnnouncments[i].pathID == c.referenceAnnouncement.pathID {
+				if prefixTrie.activeAnnouncments[i].alreadyAnnounced {
+					return c
+				}
+			}
+		}
+	} else {
+		c = prefixTrie.findConflictsThisLevel(c)
+	}
+
+	for _, child := range prefixTrie.children {
+		if child != nil {
+			c = child.findConflictsBelow(c)
+		}
+	}
+	return c
+}
+
+func (prefixTrie *trieNode) findConflictsAboveAndSameLevel(c conflicts) conflicts {
+	if c.referenceAnnouncement.prefix.length == prefixTrie.prefix.length {
+		for i := 0; i < len(prefixTrie.activeAnnouncments); i++ {
+			if prefixTrie.activeAnnouncments[i].peerID == c.referenceAnnouncement.peerID && prefixTrie.activeAnnouncments[i].pathID == c.referenceAnnouncement.pathID {
+				if prefixTrie.activeAnnouncments[i].alreadyAnnounced {
+					return c //if we land here, there was already the same announcement from the same peer and we already found all respective conflicts
+				}
+			}
+		}
+	}
+
+	c = prefixTrie.findConflictsThisLevel(c)
+
+	if prefixTrie.parent != nil {
+		return prefixTrie.parent.findConflictsAboveAndSameLevel(c) //we go recursively up to the root/ to less specific subnets
+	} else { //we are at the root
+		return c
+	}
+}
+
+func (prefixTrie *trieNode) toStringNode() string {
+	result := ""
+	result = result + prefixTrie.prefix.toString()
+
+	if len(prefixTrie.activeAnnouncments) != 0 {
+		result = result + "\n    , stored active announcements [" + strconv.Itoa(len(prefixTrie.activeAnnouncments)) + " ]:"
+		for _, m := range prefixTrie.activeAnnouncments {
+			result = result + "\n       " + m.toString()
+		}
+	}
+	return result
+}
+
+func (prefixTrie *trieNode) toStringNodeAndSubtrie() string {
+	result := "\n"
+	result = result + "\n" + "  " + prefixTrie.toStringNode()
+	if prefixTrie.children[0] != nil {
+		result = result + " \n ChildZero " + prefixTrie.children[0].toStringNodeAndSubtrie()
+	}
+	if prefixTrie.children[1] != nil {
+		result = result + "\n ChildOne " + prefixTrie.children[1].toStringNodeAndSubtrie()
+	}
+	return result
+}
+
+func (d *Detector) insertAndFindConflicts(m message, findConflicts bool) {
+	root := d.rootFor(m.prefix)
+	if m.isAnnouncement {
+		m.rpki = d.validateOrigin(m)
+	}
+	if m.prefix.length == 0 {
+		d.println(red("subnet length = 0. Will not insert: ", m.toString()))
+		return
+	}
+
+	isNewForWatchedASN := m.isAnnouncement && findConflicts && d.isNewForWatchedASN(root, m)
+	nodeWhereInserted := *root.insert(d, m)
+	if m.isAnnouncement {
+		d.observeConflictSide(conflictSide{prefix: m.prefix, origin: m.origin}, m.peerID, m.timestamp)
+	}
+	//fmt.Println("going to insert message: \n", m.toString(), "\n")
+
+	if m.isAnnouncement && findConflicts {
+		d.checkWatchlist(m)
+		d.checkASNWatchlist(m, isNewForWatchedASN)
+		conflictsField := make([]message, 0)
+
+		c := conflicts{
+			referencePrefix:       m.prefix,
+			referenceAnnouncement: m,
+			conflictingMessages:   conflictsField,
+			relevant:              nodeWhereInserted.isRelevant(),
+		}
+		confl := nodeWhereInserted.findConflictsAboveAndSameLevel(c)
+		confl = nodeWhereInserted.findConflictsBelow(confl)
+		confl = d.applyAllowlist(confl)
+		d.registerConflicts(confl) //all conflicts, so that their lifecycle is tracked even if they are not reported
+		if d.options.RPKIInvalidOnly {
+			confl = d.onlyRpkiInvalidConflicts(confl)
+		}
+		confl.relevant = confl.relevant && d.watchlistRelevant(confl)
+		confl = addPeerVisibility(root, confl)
+		if len(confl.conflictingMessages) > 0 {
+			d.countConflicts = d.countConflicts + len(confl.conflictingMessages)
+			d.checkASNWatchlistConflicts(confl)
+			d.pendingConflicts = append(d.pendingConflicts, d.prepareJSON(confl))
+			d.updateSummary(confl)
+			if d.countConflictTriggers == 1000*d.countConflictTriggers1000 {
+				d.countConflictTriggers1000++
+				d.println(white("Messages that triggered conflicts so far: ", d.countConflictTriggers))
+			}
+			d.countConflictTriggers++
+
+			if d.options.Verbose {
+				d.println(white(d.conflictsToString(confl)))
+			}
+			if confl.relevant && confl.hasSuspicious() {
+				d.println()
+				d.println(magenta("Relevant Conflict detected."))
+				d.raiseConflictAlerts(confl)
+				d.println(magenta(d.conflictsToString(confl)))
+				d.println(magenta("Involved Origin ASes: "))
+				d.println(magenta("  ", confl.referenceAnnouncement.origin, " = ", orDash(d.asInfoOf(confl.referenceAnnouncement.origin).org), ", ", d.peersToString(confl.referencePeers)))
+				for i, j := range confl.conflictingMessages {
+					d.println(magenta("  ", j.origin, " = ", orDash(d.asInfoOf(j.origin).org), ", ", d.peersToString(confl.conflictingPeers[i])))
+				}
+				d.println()
+			}
+		}
+	}
+
+	if d.countInserted == 100000*d.countInserted100000 {
+		d.countInserted100000++
+		d.println(green("inserted messages so far: ", d.countInserted))
+	}
+	d.countInserted++
+	if !m.isAnnouncement {
+		d.countWithdrawals++
+	}
+	d.snapshotIfDue()
+}
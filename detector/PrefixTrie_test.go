@@ -0,0 +1,344 @@
+package detector
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func newTestDetector(options Options) *Detector {
+	if options.Output == nil {
+		options.Output = ioutil.Discard
+	}
+	return New(options)
+}
+
+func mustParseCIDR(t testing.TB, s string) net.IPNet {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *ipnet
+}
+
+// testAnnouncement is an announcement of a test case, every one comes from its own peer
+type testAnnouncement struct {
+	subnet string
+	origin uint32
+}
+
+func announceAll(t *testing.T, d *Detector, announcements []testAnnouncement, findConflicts bool) {
+	for _, a := range announcements {
+		peerID := d.Peer("192.0.2."+strconv.Itoa(len(d.ourPeers)+1), 64496, "rrc00")
+		d.Announce(Announcement{
+			Subnet:    mustParseCIDR(t, a.subnet),
+			Peer:      peerID,
+			Timestamp: 1600000000,
+			Origin:    a.origin,
+			ASPath:    []uint32{64496, 3356, a.origin},
+		}, findConflicts)
+	}
+}
+
+func TestInsert(t *testing.T) {
+	tests := []struct {
+		name      string
+		subnets   []string
+		wantNodes int
+	}{
+		{"single subnet", []string{"10.0.0.0/24"}, 1},
+		{"same subnet twice", []string{"10.0.0.0/24", "10.0.0.0/24"}, 1},
+		{"less specific first", []string{"10.0.0.0/8", "10.1.0.0/16"}, 2},
+		{"more specific first", []string{"10.1.0.0/16", "10.0.0.0/8"}, 2},
+		{"siblings need a branching node", []string{"10.0.0.0/16", "10.1.0.0/16"}, 3},
+		{"branching node becomes a subnet", []string{"10.0.0.0/16", "10.1.0.0/16", "10.0.0.0/15"}, 3},
+		{"IPv6", []string{"2001:db8::/32", "2001:db8:1::/48", "2001:db8:2::/48"}, 4},
+		{"IPv4 and IPv6 in separate tries", []string{"10.0.0.0/8", "2001:db8::/32"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDetector(Options{})
+			announcements := make([]testAnnouncement, 0, len(tt.subnets))
+			for _, s := range tt.subnets {
+				announcements = append(announcements, testAnnouncement{s, 64500})
+			}
+			announceAll(t, d, announcements, false)
+			if d.countTrieNodes != tt.wantNodes {
+				t.Errorf("trie nodes = %d, want %d", d.countTrieNodes, tt.wantNodes)
+			}
+			if d.countActiveAnnouncements != len(tt.subnets) {
+				t.Errorf("active announcements = %d, want %d", d.countActiveAnnouncements, len(tt.subnets))
+			}
+			for _, s := range tt.subnets {
+				p := convertIPtoPrefix(mustParseCIDR(t, s))
+				node := d.rootFor(p).findNode(p)
+				if node == nil {
+					t.Fatalf("no node for %s", s)
+				}
+				for n := node; n.parent != nil; n = n.parent {
+					if !n.parent.prefix.contains(n.prefix) || n.parent.prefix.length >= n.prefix.length {
+						t.Errorf("parent %s of %s is not less specific", n.parent.prefix.toString(), n.prefix.toString())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestInsertReplacesPathOfPeer(t *testing.T) {
+	d := newTestDetector(Options{})
+	subnet := mustParseCIDR(t, "10.0.0.0/24")
+	peerID := d.Peer("192.0.2.1", 64496, "rrc00")
+	d.Announce(Announcement{Subnet: subnet, Peer: peerID, Origin: 64500, ASPath: []uint32{64496, 64500}}, false)
+	d.Announce(Announcement{Subnet: subnet, Peer: peerID, Origin: 64501, ASPath: []uint32{64496, 64501}}, false)
+	if d.countActiveAnnouncements != 1 {
+		t.Errorf("the same path of a peer has to be replaced, active announcements = %d", d.countActiveAnnouncements)
+	}
+	d.Announce(Announcement{Subnet: subnet, Peer: peerID, PathID: 2, Origin: 64501, ASPath: []uint32{64496, 64501}}, false)
+	if d.countActiveAnnouncements != 2 {
+		t.Errorf("another path of a peer has to be added, active announcements = %d", d.countActiveAnnouncements)
+	}
+	d.Withdraw(Withdrawal{Subnet: subnet, Peer: peerID, PathID: 2})
+	if d.countActiveAnnouncements != 1 {
+		t.Errorf("the withdrawal has to remove its path, active announcements = %d", d.countActiveAnnouncements)
+	}
+}
+
+func TestFindConflicts(t *testing.T) {
+	type wantConflict struct {
+		subnet       string
+		origin       int
+		conflictType string
+	}
+	tests := []struct {
+		name     string
+		existing []testAnnouncement
+		new      testAnnouncement
+		want     []wantConflict
+	}{
+		{"exact prefix MOAS", []testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.0.0/16", 64501},
+			[]wantConflict{{"10.0.0.0/16", 64500, "exact-prefix-moas"}}},
+		{"sub-prefix", []testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.1.0/24", 64501},
+			[]wantConflict{{"10.0.0.0/16", 64500, "sub-prefix-hijack"}}},
+		{"super-prefix", []testAnnouncement{{"10.0.1.0/24", 64500}}, testAnnouncement{"10.0.0.0/16", 64501},
+			[]wantConflict{{"10.0.1.0/24", 64500, "super-prefix-announcement"}}},
+		{"above and below", []testAnnouncement{{"10.0.0.0/8", 64500}, {"10.0.1.0/24", 64502}}, testAnnouncement{"10.0.0.0/16", 64501},
+			[]wantConflict{{"10.0.0.0/8", 64500, "sub-prefix-hijack"}, {"10.0.1.0/24", 64502, "super-prefix-announcement"}}},
+		{"same origin", []testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.0.1.0/24", 64500}, nil},
+		{"disjoint subnets", []testAnnouncement{{"10.0.0.0/16", 64500}}, testAnnouncement{"10.1.0.0/16", 64501}, nil},
+		{"siblings below a branching node", []testAnnouncement{{"10.0.0.0/16", 64500}, {"10.1.0.0/16", 64502}}, testAnnouncement{"10.0.0.0/15", 64501},
+			[]wantConflict{{"10.0.0.0/16", 64500, "super-prefix-announcement"}, {"10.1.0.0/16", 64502, "super-prefix-announcement"}}},
+		{"IPv6 sub-prefix", []testAnnouncement{{"2001:db8::/32", 64500}}, testAnnouncement{"2001:db8:1::/48", 64501},
+			[]wantConflict{{"2001:db8::/32", 64500, "sub-prefix-hijack"}}},
+		{"IPv6 does not conflict with IPv4", []testAnnouncement{{"0.0.0.0/8", 64500}}, testAnnouncement{"::/8", 64501}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var found []ConflictJSON
+			d := newTestDetector(Options{OnConflict: func(c ConflictJSON) { found = append(found, c) }})
+			announceAll(t, d, tt.existing, false)
+			announceAll(t, d, []testAnnouncement{tt.new}, true)
+			if len(tt.want) == 0 {
+				if len(found) != 0 {
+					t.Fatalf("unexpected conflicts %+v", found)
+				}
+				return
+			}
+			if len(found) != 1 {
+				t.Fatalf("got %d conflict triggers, want 1", len(found))
+			}
+			got := found[0].Conflicts
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d conflicts, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for _, w := range tt.want {
+				ok := false
+				for _, g := range got {
+					if g.Subnet == w.subnet && g.OriginAS == w.origin && g.ConflictType == w.conflictType {
+						ok = true
+					}
+				}
+				if !ok {
+					t.Errorf("missing conflict %+v in %+v", w, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRelevantInheritance(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []string //inserted before the relevant prefix is marked
+		after  []string
+		want   map[string]bool
+	}{
+		{"more specific inserted later", nil, []string{"10.0.1.0/24", "10.0.0.0/8", "10.1.0.0/16"},
+			map[string]bool{"10.0.1.0/24": true, "10.0.0.0/8": false, "10.1.0.0/16": false}},
+		{"more specific inserted before", []string{"10.0.1.0/24", "10.0.0.0/8"}, nil,
+			map[string]bool{"10.0.1.0/24": true, "10.0.0.0/8": false}},
+		{"branching node below the relevant prefix", nil, []string{"10.0.1.0/24", "10.0.2.0/24"},
+			map[string]bool{"10.0.1.0/24": true, "10.0.2.0/24": true, "10.0.0.0/22": true}},
+		{"branching node above the relevant prefix", nil, []string{"10.1.0.0/16"},
+			map[string]bool{"10.0.0.0/15": false, "10.1.0.0/16": false, "10.0.0.0/16": true}},
+		{"IPv6", nil, []string{"2001:db8:0:1::/64", "2001:db9::/32", "2001:db8:1:1::/64"},
+			map[string]bool{"2001:db8:0:1::/64": false, "2001:db9::/32": false, "2001:db8:1:1::/64": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDetector(Options{})
+			var announcements []testAnnouncement
+			for _, s := range tt.before {
+				announcements = append(announcements, testAnnouncement{s, 64500})
+			}
+			announceAll(t, d, announcements, false)
+			d.MarkRelevant(mustParseCIDR(t, "10.0.0.0/16"))
+			d.MarkRelevant(mustParseCIDR(t, "2001:db8:1::/48"))
+			announcements = nil
+			for _, s := range tt.after {
+				announcements = append(announcements, testAnnouncement{s, 64500})
+			}
+			announceAll(t, d, announcements, false)
+			for s, want := range tt.want {
+				p := convertIPtoPrefix(mustParseCIDR(t, s))
+				node := d.rootFor(p).findNode(p)
+				if node == nil {
+					t.Fatalf("no node for %s", s)
+				}
+				if node.relevant != want {
+					t.Errorf("%s: relevant = %v, want %v", s, node.relevant, want)
+				}
+			}
+		})
+	}
+}
+
+/*
+bitTrieNode is the trie that was replaced by the PATRICIA trie: one node per bit and every node stores its subnet as slice
+with one byte per bit. It is only kept to compare both in the benchmarks.
+*/
+type bitTrieNode struct {
+	childZero          *bitTrieNode
+	childOne           *bitTrieNode
+	parent             *bitTrieNode
+	representedNet     []uint8
+	value              uint8
+	activeAnnouncments []message
+	relevant           bool
+}
+
+func convertIPtoBits(ipNet net.IPNet) []uint8 {
+	ip := ipNet.IP.To4()
+	if ip == nil {
+		ip = ipNet.IP.To16()
+	}
+	length, _ := ipNet.Mask.Size()
+	result := make([]uint8, 0, len(ip)*8)
+	for _, b := range ip {
+		for j := 7; j >= 0; j-- {
+			result = append(result, (b>>uint(j))&1)
+		}
+	}
+	return result[:length]
+}
+
+func (n *bitTrieNode) insertMessage(m message, subnetAsBits []uint8, currentDepth int) *bitTrieNode {
+	if currentDepth == len(subnetAsBits) {
+		for i := 0; i < len(n.activeAnnouncments); i++ {
+			if n.activeAnnouncments[i].peerID == m.peerID {
+				n.activeAnnouncments = append(n.activeAnnouncments[:i], n.activeAnnouncments[i+1:]...)
+			}
+		}
+		n.activeAnnouncments = append(n.activeAnnouncments, m)
+		return n
+	}
+	nextBit := subnetAsBits[currentDepth]
+	nextChild := n.childZero
+	if nextBit != 0 {
+		nextChild = n.childOne
+	}
+	if nextChild == nil {
+		reprN := make([]uint8, len(n.representedNet)+1)
+		copy(reprN, n.representedNet)
+		reprN[len(n.representedNet)] = nextBit
+		nextChild = &bitTrieNode{parent: n, value: nextBit, representedNet: reprN, relevant: n.relevant}
+		if nextBit == 0 {
+			n.childZero = nextChild
+		} else {
+			n.childOne = nextChild
+		}
+	}
+	return nextChild.insertMessage(m, subnetAsBits, currentDepth+1)
+}
+
+// benchmarkSubnets returns the same random subnets for every run, most of them with the usual lengths of the routing table
+func benchmarkSubnets(count int, ipv4 bool) []net.IPNet {
+	r := rand.New(rand.NewSource(1))
+	result := make([]net.IPNet, count)
+	for i := range result {
+		if ipv4 {
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, r.Uint32())
+			length := 24
+			if r.Intn(2) == 0 {
+				length = 8 + r.Intn(17)
+			}
+			mask := net.CIDRMask(length, 32)
+			result[i] = net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		} else {
+			ip := make(net.IP, 16)
+			binary.BigEndian.PutUint64(ip, 0x2000000000000000|r.Uint64()>>3)
+			length := 48
+			if r.Intn(2) == 0 {
+				length = 19 + r.Intn(46)
+			}
+			mask := net.CIDRMask(length, 128)
+			result[i] = net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		}
+	}
+	return result
+}
+
+const benchmarkSubnetCount = 10000
+
+func benchmarkInsertBitTrie(b *testing.B, subnets []net.IPNet) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		root := &bitTrieNode{}
+		for j, s := range subnets {
+			root.insertMessage(message{peerID: uint16(j), origin: 64500, isAnnouncement: true}, convertIPtoBits(s), 0)
+		}
+	}
+}
+
+func benchmarkInsertPatriciaTrie(b *testing.B, subnets []net.IPNet) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := newTestDetector(Options{})
+		for j, s := range subnets {
+			m := message{prefix: convertIPtoPrefix(s), peerID: uint16(j), origin: 64500, isAnnouncement: true}
+			d.rootFor(m.prefix).insert(d, m)
+		}
+	}
+}
+
+func BenchmarkInsertIPv4BitTrie(b *testing.B) {
+	benchmarkInsertBitTrie(b, benchmarkSubnets(benchmarkSubnetCount, true))
+}
+
+func BenchmarkInsertIPv4PatriciaTrie(b *testing.B) {
+	benchmarkInsertPatriciaTrie(b, benchmarkSubnets(benchmarkSubnetCount, true))
+}
+
+func BenchmarkInsertIPv6BitTrie(b *testing.B) {
+	benchmarkInsertBitTrie(b, benchmarkSubnets(benchmarkSubnetCount, false))
+}
+
+func BenchmarkInsertIPv6PatriciaTrie(b *testing.B) {
+	benchmarkInsertPatriciaTrie(b, benchmarkSubnets(benchmarkSubnetCount, false))
+}
@@ -0,0 +1,190 @@
+package detector
+
+import (
+	"net"
+	"sort"
+)
+
+// RoutingStateJSON contains all active announcements which overlap with a queried subnet
+type RoutingStateJSON struct {
+	Query        string        `json:"query"`
+	Covering     []MessageJSON `json:"covering"`     // announcements of the subnet itself and of less specific subnets, the most specific first
+	MoreSpecific []MessageJSON `json:"moreSpecific"` // announcements of more specific subnets
+}
+
+// ActiveConflictJSON is a conflict that is still ongoing
+type ActiveConflictJSON struct {
+	ID        string     `json:"conflictId"`
+	SubnetA   string     `json:"subnetA"`
+	OriginA   int        `json:"originA"`
+	PeersA    []PeerJSON `json:"peersA"` // the peers which currently carry the announcement of side A
+	SubnetB   string     `json:"subnetB"`
+	OriginB   int        `json:"originB"`
+	PeersB    []PeerJSON `json:"peersB"`
+	FirstSeen int        `json:"firstSeen"`
+	LastSeen  int        `json:"lastSeen"`
+	Tag       string     `json:"allowlistTag,omitempty"`
+}
+
+// OriginJSON are the counters of an origin AS involved in conflicts. The recent counters cover the current interval only
+type OriginJSON struct {
+	ASN                          int    `json:"asn"`
+	Country                      string `json:"country"`
+	Registry                     string `json:"registry"`
+	Org                          string `json:"org"`
+	Allocated                    string `json:"allocated"`    // allocation date of the AS, YYYY-MM-DD
+	LessSpecific                 int    `json:"lessSpecific"` //potential victim
+	MoreSpecific                 int    `json:"moreSpecific"` //potential attacker
+	SameSubnet                   int    `json:"sameSubnet"`
+	TopographicallyRelated       int    `json:"topographicallyRelated"`
+	RPKIInvalid                  int    `json:"rpkiInvalid"`
+	LessSpecificRecent           int    `json:"lessSpecificRecent"`
+	MoreSpecificRecent           int    `json:"moreSpecificRecent"`
+	SameSubnetRecent             int    `json:"sameSubnetRecent"`
+	TopographicallyRelatedRecent int    `json:"topographicallyRelatedRecent"`
+}
+
+// Lookup returns the active announcements covering the subnet and those more specific than it. Announcements of the same
+// subnet with the same AS path are combined, together with all peers carrying them. For a single address use a /32 or /128
+func (d *Detector) Lookup(subnet net.IPNet) RoutingStateJSON {
+	p := convertIPtoPrefix(subnet)
+	d.lock.Lock()
+	defer d.unlock()
+	result := RoutingStateJSON{Query: p.toString(), Covering: []MessageJSON{}, MoreSpecific: []MessageJSON{}}
+
+	//we walk down to the subnet. All nodes on the way which contain it are less specific subnets
+	node := d.rootFor(p).node
+	for node != nil && node.prefix.length < p.length && node.prefix.contains(p) {
+		result.Covering = append(d.groupAnnouncementsForJSON(node.activeAnnouncments), result.Covering...)
+		node = node.children[p.bit(node.prefix.length)]
+	}
+	if node == nil || !p.contains(node.prefix) {
+		return result
+	}
+	if node.prefix == p {
+		result.Covering = append(d.groupAnnouncementsForJSON(node.activeAnnouncments), result.Covering...)
+		for _, child := range node.children {
+			result.MoreSpecific = d.appendSubtrie(result.MoreSpecific, child)
+		}
+	} else { //the subnet itself is not in the trie, but more specific ones are
+		result.MoreSpecific = d.appendSubtrie(result.MoreSpecific, node)
+	}
+	return result
+}
+
+func (d *Detector) appendSubtrie(result []MessageJSON, node *trieNode) []MessageJSON {
+	if node == nil {
+		return result
+	}
+	result = append(result, d.groupAnnouncementsForJSON(node.activeAnnouncments)...)
+	for _, child := range node.children {
+		result = d.appendSubtrie(result, child)
+	}
+	return result
+}
+
+// groupAnnouncementsForJSON combines the announcements of one node with the same origin and AS path. The timestamp is the newest one
+func (d *Detector) groupAnnouncementsForJSON(announcements []message) []MessageJSON {
+	result := make([]MessageJSON, 0, len(announcements))
+	peers := make([][]uint16, 0, len(announcements))
+	byPath := make(map[string]int)
+	for _, a := range announcements {
+		key := aspathtoString(a.aspath)
+		i, ok := byPath[key]
+		if !ok || result[i].OriginAS != int(a.origin) {
+			i = len(result)
+			byPath[key] = i
+			m := d.convertMessageForJSON(a)
+			m.PathID = 0 //path IDs differ between the peers
+			m.Collector = ""
+			m.Peer = nil
+			result = append(result, m)
+			peers = append(peers, nil)
+		}
+		if int(a.timestamp) > result[i].Timestamp {
+			result[i].Timestamp = int(a.timestamp)
+		}
+		if !containsUint16(peers[i], a.peerID) {
+			peers[i] = append(peers[i], a.peerID)
+		}
+	}
+	for i := range result {
+		sort.Slice(peers[i], func(a, b int) bool { return peers[i][a] < peers[i][b] })
+		result[i].Peers = d.convertPeerListForJSON(peers[i])
+		result[i].PeerCount = len(peers[i])
+	}
+	return result
+}
+
+// ActiveConflicts returns all conflicts which are still ongoing, the newest first. If subnet is not nil, only conflicts
+// with one side equal to, less specific or more specific than the subnet are returned
+func (d *Detector) ActiveConflicts(subnet *net.IPNet) []ActiveConflictJSON {
+	var p prefix
+	if subnet != nil {
+		p = convertIPtoPrefix(*subnet)
+	}
+	d.lock.Lock()
+	defer d.unlock()
+	result := make([]ActiveConflictJSON, 0, len(d.activeConflicts))
+	for _, r := range d.activeConflicts {
+		if subnet != nil && !overlaps(p, r.key.sideA.prefix) && !overlaps(p, r.key.sideB.prefix) {
+			continue
+		}
+		if !d.isReportedConflict(r) {
+			continue
+		}
+		result = append(result, ActiveConflictJSON{
+			ID:        r.id,
+			SubnetA:   r.key.sideA.prefix.toString(),
+			OriginA:   int(r.key.sideA.origin),
+			PeersA:    d.peersCarryingForJSON(r.key.sideA),
+			SubnetB:   r.key.sideB.prefix.toString(),
+			OriginB:   int(r.key.sideB.origin),
+			PeersB:    d.peersCarryingForJSON(r.key.sideB),
+			FirstSeen: int(r.firstSeen),
+			LastSeen:  int(r.lastSeen),
+			Tag:       r.tag,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].FirstSeen > result[j].FirstSeen })
+	return result
+}
+
+// peersCarryingForJSON returns the peers which announce one side of a conflict right now. The peers of the conflict record
+// also contain those which withdrew it in the meantime
+func (d *Detector) peersCarryingForJSON(side conflictSide) []PeerJSON {
+	peers := d.rootFor(side.prefix).peersAnnouncing(side.prefix, side.origin)
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+	return d.convertPeerListForJSON(peers)
+}
+
+func overlaps(p prefix, o prefix) bool {
+	return p.contains(o) || o.contains(p)
+}
+
+// Origin returns the counters of an origin AS. The second return value is false if the AS was not involved in any conflict yet
+func (d *Detector) Origin(asn uint32) (OriginJSON, bool) {
+	d.lock.Lock()
+	defer d.unlock()
+	v, ok := d.originCounters[asn]
+	if !ok {
+		return OriginJSON{ASN: int(asn)}, false
+	}
+	info := d.asInfoOf(asn)
+	return OriginJSON{
+		ASN:                          int(v.asn),
+		Country:                      orDash(info.country),
+		Registry:                     orDash(info.registry),
+		Org:                          orDash(info.org),
+		Allocated:                    orDash(info.allocated),
+		LessSpecific:                 int(v.counterLessSpecific),
+		MoreSpecific:                 int(v.counterMoreSpecific),
+		SameSubnet:                   int(v.counterSameSubnet),
+		TopographicallyRelated:       int(v.topographicallyRelated),
+		RPKIInvalid:                  int(v.rpkiInvalid),
+		LessSpecificRecent:           int(v.counterLessSpecificRecent),
+		MoreSpecificRecent:           int(v.counterMoreSpecificRecent),
+		SameSubnetRecent:             int(v.counterSameSubnetRecent),
+		TopographicallyRelatedRecent: int(v.topographicallyRelatedRecent),
+	}, true
+}
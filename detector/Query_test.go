@@ -0,0 +1,132 @@
+package detector
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	d := newTestDetector(Options{})
+	announceAll(t, d, []testAnnouncement{{"10.0.0.0/8", 64500}, {"10.0.0.0/16", 64501}, {"10.0.0.0/16", 64501}, {"10.0.1.0/24", 64502},
+		{"10.1.0.0/16", 64503}, {"2001:db8::/32", 64504}}, false)
+	toStrings := func(messages []MessageJSON) []string {
+		result := make([]string, 0, len(messages))
+		for _, m := range messages {
+			result = append(result, m.Subnet+" "+strconv.Itoa(m.OriginAS)+" "+strconv.Itoa(m.PeerCount))
+		}
+		return result
+	}
+	tests := []struct {
+		query        string
+		covering     []string //subnet, origin and number of peers
+		moreSpecific []string
+	}{
+		{"10.0.0.0/16", []string{"10.0.0.0/16 64501 2", "10.0.0.0/8 64500 1"}, []string{"10.0.1.0/24 64502 1"}},
+		{"10.0.1.5/32", []string{"10.0.1.0/24 64502 1", "10.0.0.0/16 64501 2", "10.0.0.0/8 64500 1"}, nil},
+		{"10.0.0.0/15", []string{"10.0.0.0/8 64500 1"}, []string{"10.0.0.0/16 64501 2", "10.0.1.0/24 64502 1", "10.1.0.0/16 64503 1"}},
+		{"10.2.0.0/16", []string{"10.0.0.0/8 64500 1"}, nil},
+		{"192.0.2.0/24", nil, nil},
+		{"2001:db8:1::1/128", []string{"2001:db8::/32 64504 1"}, nil},
+		{"2001:db8::/31", nil, []string{"2001:db8::/32 64504 1"}},
+	}
+	for _, tt := range tests {
+		result := d.Lookup(mustParseCIDR(t, tt.query))
+		covering, moreSpecific := toStrings(result.Covering), toStrings(result.MoreSpecific)
+		if !equalStrings(covering, tt.covering) || !equalStrings(moreSpecific, tt.moreSpecific) {
+			t.Errorf("%s: covering %v and more specific %v, want %v and %v", tt.query, covering, moreSpecific, tt.covering, tt.moreSpecific)
+		}
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestActiveConflicts(t *testing.T) {
+	d := newTestDetector(Options{})
+	announceAll(t, d, []testAnnouncement{{"10.0.0.0/16", 64500}}, false)
+	announceAll(t, d, []testAnnouncement{{"10.0.1.0/24", 64501}, {"10.0.1.0/24", 64501}}, true)
+	announceAll(t, d, []testAnnouncement{{"2001:db8::/32", 64502}}, false)
+	announceAll(t, d, []testAnnouncement{{"2001:db8:1::/48", 64503}}, true)
+	d.Withdraw(Withdrawal{Subnet: mustParseCIDR(t, "10.0.1.0/24"), Peer: 2, Timestamp: 1600000001})
+
+	all := d.ActiveConflicts(nil)
+	if len(all) != 2 {
+		t.Fatalf("got %d active conflicts, want 2: %+v", len(all), all)
+	}
+	for _, c := range all {
+		if c.SubnetA != "10.0.0.0/16" {
+			continue
+		}
+		if c.SubnetB != "10.0.1.0/24" || c.OriginA != 64500 || c.OriginB != 64501 || len(c.PeersA) != 1 {
+			t.Errorf("conflict %+v", c)
+		}
+		if len(c.PeersB) != 1 || c.PeersB[0].IP != "192.0.2.2" {
+			t.Errorf("peers of side B %+v, want only 192.0.2.2 which still carries the announcement", c.PeersB)
+		}
+	}
+
+	tests := []struct {
+		subnet string
+		want   []string //subnet of side B of each conflict
+	}{
+		{"10.0.1.128/25", []string{"10.0.1.0/24"}}, //more specific than side B
+		{"10.0.0.0/8", []string{"10.0.1.0/24"}},    //less specific than both sides
+		{"10.0.2.0/24", []string{"10.0.1.0/24"}},   //more specific than side A only
+		{"10.1.0.0/16", nil},
+		{"2001:db8:1:1::/64", []string{"2001:db8:1::/48"}},
+		{"2001:db8:2::/48", []string{"2001:db8:1::/48"}},
+	}
+	for _, tt := range tests {
+		_, subnet, _ := net.ParseCIDR(tt.subnet)
+		var got []string
+		for _, c := range d.ActiveConflicts(subnet) {
+			got = append(got, c.SubnetB)
+		}
+		if !equalStrings(got, tt.want) {
+			t.Errorf("conflicts overlapping %s: %v, want %v", tt.subnet, got, tt.want)
+		}
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	d := newTestDetector(Options{})
+	announceAll(t, d, []testAnnouncement{{"10.0.0.0/16", 64500}}, false)
+	announceAll(t, d, []testAnnouncement{{"10.0.1.0/24", 64501}, {"10.0.0.0/16", 64502}}, true)
+
+	tests := []struct {
+		asn          uint32
+		lessSpecific int
+		moreSpecific int
+		sameSubnet   int
+	}{
+		{64500, 1, 0, 1},
+		{64501, 0, 2, 0}, //more specific than the /16 of both other origins
+		{64502, 1, 0, 1},
+	}
+	for _, tt := range tests {
+		o, ok := d.Origin(tt.asn)
+		if !ok {
+			t.Errorf("AS %d: not found", tt.asn)
+			continue
+		}
+		if o.ASN != int(tt.asn) || o.LessSpecific != tt.lessSpecific || o.MoreSpecific != tt.moreSpecific || o.SameSubnet != tt.sameSubnet {
+			t.Errorf("AS %d: %+v, want less specific %d, more specific %d, same subnet %d", tt.asn, o, tt.lessSpecific, tt.moreSpecific, tt.sameSubnet)
+		}
+		if o.Country != "-" || o.Org != "-" {
+			t.Errorf("AS %d: country %q and org %q without AS metadata, want -", tt.asn, o.Country, o.Org)
+		}
+	}
+	if o, ok := d.Origin(64999); ok || o.ASN != 64999 {
+		t.Errorf("AS 64999 was never in a conflict, got %+v", o)
+	}
+}
@@ -0,0 +1,248 @@
+package detector
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// rpkiState is the result of the Route Origin Validation (RFC 6811) of one announcement
+type rpkiState uint8
+
+const (
+	rpkiNotChecked    rpkiState = iota // no VRP file was loaded
+	rpkiValid                          // a VRP covers the subnet with the same origin and a sufficient max length
+	rpkiInvalidOrigin                  // VRPs cover the subnet, but none of them is for the origin of the announcement
+	rpkiInvalidLength                  // a VRP for the origin covers the subnet, but the subnet is more specific than its max length
+	rpkiNotFound                       // no VRP covers the subnet
+)
+
+func (s rpkiState) toString() string {
+	switch s {
+	case rpkiValid:
+		return "valid"
+	case rpkiInvalidOrigin:
+		return "invalid-origin"
+	case rpkiInvalidLength:
+		return "invalid-length"
+	case rpkiNotFound:
+		return "not-found"
+	}
+	return ""
+}
+
+// rpkiStateToStringForOutput returns the RPKI state of an announcement for the terminal output (or nothing if RPKI is not used)
+func rpkiStateToStringForOutput(m message) string {
+	if m.rpki == rpkiNotChecked {
+		return ""
+	}
+	return " RPKI: " + m.rpki.toString()
+}
+
+func (s rpkiState) isInvalid() bool {
+	return s == rpkiInvalidOrigin || s == rpkiInvalidLength
+}
+
+// vrp is one Validated ROA Payload
+type vrp struct {
+	asn       uint32
+	maxLength uint8
+}
+
+// vrpASN accepts both formats used for the ASN in VRP exports: 13335 (rpki-client) and "AS13335" (Routinator)
+type vrpASN uint32
+
+func (a *vrpASN) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), "\"")
+	asn, err := parseASN(s)
+	if err != nil {
+		return err
+	}
+	*a = vrpASN(asn)
+	return nil
+}
+
+type vrpJSON struct {
+	ASN       vrpASN      `json:"asn"`
+	Prefix    string      `json:"prefix"`
+	MaxLength json.Number `json:"maxLength"`
+}
+
+type vrpFileJSON struct {
+	Roas []vrpJSON `json:"roas"`
+}
+
+func parseASN(s string) (uint32, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "AS")
+	asn, err := strconv.ParseUint(s, 10, 32)
+	return uint32(asn), err
+}
+
+// readVRPs loads the Validated ROA Payloads as exported by rpki-client or Routinator (JSON or CSV, told apart by the content)
+func (d *Detector) readVRPs() {
+	if d.options.VRPFile == "" {
+		return
+	}
+	f, err := os.Open(d.options.VRPFile)
+	if err != nil {
+		d.println(red("Could not open VRP file ", d.options.VRPFile, ": ", err, ". Continuing without RPKI route origin validation."))
+		return
+	}
+	defer f.Close()
+
+	d.vrpsByPrefix = make(map[prefix][]vrp)
+	reader := bufio.NewReader(f)
+	if startsWithJSONObject(reader) {
+		err = d.readVRPsJSON(reader)
+	} else {
+		err = d.readVRPsCSV(reader)
+	}
+	if err != nil {
+		d.println(red("Could not read VRP file ", d.options.VRPFile, ": ", err, ". Continuing without RPKI route origin validation."))
+		d.vrpsByPrefix = nil
+		d.countVRPs = 0
+		return
+	}
+	d.println(green("VRPs loaded for RPKI route origin validation: ", d.countVRPs))
+}
+
+// startsWithJSONObject returns true if the first character after leading whitespace is "{". The whitespace is consumed
+func startsWithJSONObject(r *bufio.Reader) bool {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			r.UnreadByte()
+			return b == '{'
+		}
+	}
+}
+
+// parseMaxLength parses the max length of a VRP. Without max length only the prefix itself is authorized (RFC 6482)
+func parseMaxLength(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func (d *Detector) readVRPsJSON(r io.Reader) error {
+	var file vrpFileJSON
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return err
+	}
+	for _, roa := range file.Roas {
+		maxLength, err := parseMaxLength(roa.MaxLength.String())
+		if err != nil {
+			d.println(red("Skipping VRP ", roa.Prefix, " with invalid max length: ", err))
+			continue
+		}
+		d.addVRP(roa.Prefix, uint32(roa.ASN), maxLength)
+	}
+	return nil
+}
+
+func (d *Detector) readVRPsCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 3 {
+			d.println(red("VRP line with less than 3 fields: ", record))
+			continue
+		}
+		asn, err := parseASN(record[0])
+		if err != nil { //this is also the case for the header line
+			continue
+		}
+		maxLength, err := parseMaxLength(record[2])
+		if err != nil {
+			d.println(red("Skipping VRP ", record[1], " with invalid max length: ", err))
+			continue
+		}
+		d.addVRP(strings.TrimSpace(record[1]), asn, maxLength)
+	}
+}
+
+func (d *Detector) addVRP(cidr string, asn uint32, maxLength int) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		d.println(red("Could not parse VRP prefix: ", cidr))
+		return
+	}
+	p := convertIPtoPrefix(*ipnet)
+	if maxLength > int(d.rootFor(p).maxDepth) {
+		d.println(red("Skipping VRP ", cidr, " with max length ", maxLength))
+		return
+	}
+	if maxLength < int(p.length) {
+		maxLength = int(p.length)
+	}
+	d.vrpsByPrefix[p] = append(d.vrpsByPrefix[p], vrp{asn: asn, maxLength: uint8(maxLength)})
+	d.countVRPs++
+}
+
+// validateOrigin performs the Route Origin Validation for an announcement against all covering VRPs
+func (d *Detector) validateOrigin(m message) rpkiState {
+	if d.vrpsByPrefix == nil {
+		return rpkiNotChecked
+	}
+	covered := false
+	originMatches := false
+	for length := 0; length <= int(m.prefix.length); length++ {
+		for _, v := range d.vrpsByPrefix[m.prefix.masked(uint8(length))] {
+			covered = true
+			if m.originIsASSet || v.asn == 0 || v.asn != m.origin { // an AS_SET origin never matches a VRP (RFC 6811)
+				continue
+			}
+			if m.prefix.length <= v.maxLength {
+				return rpkiValid
+			}
+			originMatches = true
+		}
+	}
+	if !covered {
+		return rpkiNotFound
+	}
+	if originMatches {
+		return rpkiInvalidLength
+	}
+	return rpkiInvalidOrigin
+}
+
+// onlyRpkiInvalidConflicts removes all conflicting announcements where neither side is RPKI-invalid
+func (d *Detector) onlyRpkiInvalidConflicts(c conflicts) conflicts {
+	if c.referenceAnnouncement.rpki.isInvalid() {
+		return c
+	}
+	remaining := make([]message, 0, len(c.conflictingMessages))
+	tags := make([]string, 0, len(c.conflictingMessages))
+	for i, m := range c.conflictingMessages {
+		if m.rpki.isInvalid() {
+			remaining = append(remaining, m)
+			tags = append(tags, c.allowlistTag(i))
+		}
+	}
+	c.conflictingMessages = remaining
+	c.allowlistTags = tags
+	return c
+}
+
+// isReportedConflict returns false for a conflict which is tracked, but not reported, because neither side is RPKI-invalid
+func (d *Detector) isReportedConflict(record *conflictRecord) bool {
+	return !d.options.RPKIInvalidOnly || record.rpkiInvalid
+}
@@ -0,0 +1,131 @@
+package detector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "detector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fileName := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestReadVRPs(t *testing.T) {
+	tests := []struct {
+		name      string
+		fileName  string
+		content   string
+		wantCount int
+	}{
+		{"JSON", "vrps.json", `{"roas": [{"asn": "AS64500", "prefix": "10.0.0.0/16", "maxLength": 24}, {"asn": 64501, "prefix": "2001:db8::/32", "maxLength": 48}]}`, 2},
+		{"JSON without suffix", "vrps", "\n  {\"roas\": [{\"asn\": 64500, \"prefix\": \"10.0.0.0/16\", \"maxLength\": 24}]}", 1},
+		{"CSV", "vrps.csv", "ASN,IP Prefix,Max Length,Trust Anchor\nAS64500,10.0.0.0/16,24,ripe\nAS64501,2001:db8::/32,48,ripe\n", 2},
+		{"CSV with suffix .json", "vrps.json", "ASN,IP Prefix,Max Length,Trust Anchor\nAS64500,10.0.0.0/16,24,ripe\n", 1},
+		{"invalid max length is skipped", "vrps.csv", "AS64500,10.0.0.0/16,x24,ripe\nAS64500,10.1.0.0/16,33,ripe\nAS64501,10.2.0.0/16,24,ripe\n", 1},
+		{"invalid max length in JSON is skipped", "vrps.json", `{"roas": [{"asn": 64500, "prefix": "10.0.0.0/16", "maxLength": 24.5}, {"asn": 64501, "prefix": "10.1.0.0/16", "maxLength": 24}]}`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDetector(Options{VRPFile: writeTestFile(t, tt.fileName, tt.content)})
+			if d.countVRPs != tt.wantCount {
+				t.Errorf("VRPs = %d, want %d", d.countVRPs, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestValidateOrigin(t *testing.T) {
+	d := newTestDetector(Options{VRPFile: writeTestFile(t, "vrps.csv", "AS64500,10.0.0.0/16,24,ripe\nAS64501,2001:db8::/32,32,ripe\n")})
+	tests := []struct {
+		subnet string
+		origin uint32
+		want   rpkiState
+	}{
+		{"10.0.0.0/16", 64500, rpkiValid},
+		{"10.0.1.0/24", 64500, rpkiValid},
+		{"10.0.1.128/25", 64500, rpkiInvalidLength},
+		{"10.0.1.0/24", 64502, rpkiInvalidOrigin},
+		{"10.1.0.0/16", 64500, rpkiNotFound},
+		{"2001:db8::/32", 64501, rpkiValid},
+		{"2001:db8:1::/48", 64501, rpkiInvalidLength},
+	}
+	for _, tt := range tests {
+		m := message{prefix: convertIPtoPrefix(mustParseCIDR(t, tt.subnet)), origin: tt.origin, isAnnouncement: true}
+		if got := d.validateOrigin(m); got != tt.want {
+			t.Errorf("%s AS%d: %s, want %s", tt.subnet, tt.origin, got.toString(), tt.want.toString())
+		}
+	}
+}
+
+func TestRPKIInvalidOnlyTracksAllConflicts(t *testing.T) {
+	var found []ConflictJSON
+	var ended []ClosedConflictJSON
+	d := newTestDetector(Options{
+		VRPFile:         writeTestFile(t, "vrps.csv", "AS64500,10.0.0.0/16,16,ripe\n"),
+		RPKIInvalidOnly: true,
+		OnConflict:      func(c ConflictJSON) { found = append(found, c) },
+		OnConflictEnded: func(c ClosedConflictJSON) { ended = append(ended, c) },
+	})
+	//not covered by any VRP, so not reported
+	announceAll(t, d, []testAnnouncement{{"10.1.0.0/16", 64510}}, false)
+	announceAll(t, d, []testAnnouncement{{"10.1.0.0/16", 64511}}, true)
+	//the more specific is RPKI-invalid
+	announceAll(t, d, []testAnnouncement{{"10.0.0.0/16", 64500}}, false)
+	announceAll(t, d, []testAnnouncement{{"10.0.1.0/24", 64501}}, true)
+
+	if len(found) != 1 || len(found[0].Conflicts) != 1 || found[0].ReferenceAnnouncement.Rpki != "invalid-origin" {
+		t.Fatalf("only the conflict with the invalid announcement has to be reported, got %+v", found)
+	}
+	if s := d.Statistics(); s.ActiveConflicts != 2 {
+		t.Errorf("active conflicts = %d, want 2", s.ActiveConflicts)
+	}
+	if got := len(d.ActiveConflicts(nil)); got != 1 {
+		t.Errorf("reported active conflicts = %d, want 1", got)
+	}
+
+	d.Withdraw(Withdrawal{Subnet: mustParseCIDR(t, "10.1.0.0/16"), Peer: 1}) //peer IDs start with 0
+	d.Withdraw(Withdrawal{Subnet: mustParseCIDR(t, "10.0.1.0/24"), Peer: 3})
+	if s := d.Statistics(); s.ClosedConflicts != 2 || s.ActiveConflicts != 0 {
+		t.Errorf("closed conflicts = %d, active conflicts = %d, want 2 and 0", s.ClosedConflicts, s.ActiveConflicts)
+	}
+	if len(ended) != 1 {
+		t.Errorf("got %d ended conflicts, want only the one with an invalid side: %+v", len(ended), ended)
+	}
+}
+
+func TestRPKIInvalidOnlyKeepsASSetConflicts(t *testing.T) {
+	var found []ConflictJSON
+	var ended []ClosedConflictJSON
+	d := newTestDetector(Options{
+		VRPFile:         writeTestFile(t, "vrps.csv", "AS64500,10.0.0.0/16,16,ripe\n"),
+		RPKIInvalidOnly: true,
+		OnConflict:      func(c ConflictJSON) { found = append(found, c) },
+		OnConflictEnded: func(c ClosedConflictJSON) { ended = append(ended, c) },
+	})
+	announceAll(t, d, []testAnnouncement{{"10.0.0.0/8", 64502}}, false)
+	//the origin matches the VRP, but an AS_SET origin is always invalid
+	peerID := d.Peer("192.0.2.100", 64496, "rrc00")
+	d.Announce(Announcement{Subnet: mustParseCIDR(t, "10.0.0.0/16"), Peer: peerID, Timestamp: 1600000000, Origin: 64500,
+		ASPath: []uint32{64496, 64500}, OriginIsASSet: true}, true)
+
+	if len(found) != 1 || found[0].ReferenceAnnouncement.Rpki != "invalid-origin" {
+		t.Fatalf("the conflict with the AS_SET announcement has to be reported, got %+v", found)
+	}
+	if got := len(d.ActiveConflicts(nil)); got != 1 {
+		t.Errorf("reported active conflicts = %d, want 1", got)
+	}
+	d.Withdraw(Withdrawal{Subnet: mustParseCIDR(t, "10.0.0.0/16"), Peer: peerID})
+	if len(ended) != 1 {
+		t.Errorf("got %d ended conflicts, want 1", len(ended))
+	}
+}
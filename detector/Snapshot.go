@@ -0,0 +1,476 @@
+package detector
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+/*
+A snapshot contains the state of the detector, so that a restart does not need to parse a whole RIB again:
+the peer table, the counters of all origin ASes, all active announcements (grouped by peer) and the ongoing conflicts.
+The file is a gzip compressed binary file. All numbers are written in big endian.
+
+	magic "HIJACKDETECTOR-SNAPSHOT", version (uint16), time of snapshot (int64, unix)
+	peers:          count (uint32), highestPeerId (uint32), per peer: id (uint16), ip (string), as (uint32), collector (string)
+	originCounters: count (uint32), per AS: asn, counterLessSpecific, counterMoreSpecific, counterSameSubnet,
+	                topographicallyRelated, rpkiInvalid (all uint32), country, registry, organisation (string, from the AS metadata)
+	announcements:  number of peers (uint32), per peer: id (uint16), number of announcements (uint32),
+	                per announcement: isIPv4 (bool), hi (uint64), lo (uint64), length (uint8), origin (uint32),
+	                originIsASSet (bool), timestamp (uint32), pathID (uint32), aspath (uint16 length + uint32 per AS)
+	conflicts:      count (uint32), per conflict: for side A and B: isIPv4 (bool), hi (uint64), lo (uint64), length (uint8),
+	                origin (uint32), then id (string), firstSeen (uint32), lastSeen (uint32), peers of side A and B
+	                (each uint16 count + uint16 per peer), tag (string), rpkiInvalid (bool)
+
+Strings are written as uint16 length followed by the bytes, bools as one byte.
+*/
+
+const snapshotMagic = "HIJACKDETECTOR-SNAPSHOT"
+const snapshotVersion uint16 = 4       //version 2 added the collector of each peer, version 3 the Add-Path ID of each announcement, version 4 the conflicts
+const oldestSnapshotVersion uint16 = 1 //older versions are still restored, the missing fields are left empty
+
+// snapshotIfDue takes a snapshot if the snapshot interval has passed since the last one. It is written by unlock
+func (d *Detector) snapshotIfDue() {
+	if d.options.SnapshotFile == "" {
+		return
+	}
+	if d.nextSnapshotT.IsZero() {
+		d.nextSnapshotT = time.Now().Add(d.options.SnapshotInterval)
+		return
+	}
+	if time.Now().After(d.nextSnapshotT) {
+		d.pendingSnapshot = d.serializeSnapshot()
+		d.nextSnapshotT = time.Now().Add(d.options.SnapshotInterval)
+	}
+}
+
+// WriteSnapshot writes the current state to the snapshot file of the options (if any)
+func (d *Detector) WriteSnapshot() {
+	if d.options.SnapshotFile == "" {
+		return
+	}
+	d.lock.Lock()
+	defer d.unlock()
+	d.pendingSnapshot = d.serializeSnapshot()
+}
+
+// serializedSnapshot is the uncompressed state, taken while holding the lock. It is compressed while it is written to the file
+type serializedSnapshot struct {
+	data               []byte
+	countPeers         int
+	countOrigins       int
+	countAnnouncements int
+	start              time.Time
+}
+
+// serializeSnapshot writes the current state into memory. It returns nil if this failed
+func (d *Detector) serializeSnapshot() *serializedSnapshot {
+	start := time.Now()
+	d.println(teal("Writing snapshot to ", d.options.SnapshotFile, "..."))
+	var buffer bytes.Buffer
+	w := &snapshotWriter{w: bufio.NewWriter(&buffer)}
+	countAnnouncements := d.writeState(w)
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.err != nil {
+		d.println(red("could not write snapshot: ", w.err))
+		return nil
+	}
+	return &serializedSnapshot{
+		data:               buffer.Bytes(),
+		countPeers:         len(d.peermapByID),
+		countOrigins:       len(d.originCounters),
+		countAnnouncements: countAnnouncements,
+		start:              start,
+	}
+}
+
+// writeSnapshotFile compresses a snapshot into a temporary file first and then replaces the old snapshot. It is called without the lock
+func (d *Detector) writeSnapshotFile(s *serializedSnapshot) {
+	d.snapshotFileLock.Lock()
+	defer d.snapshotFileLock.Unlock()
+	snapshotFileName := d.options.SnapshotFile
+	tmpName := snapshotFileName + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		d.println(red("could not create snapshot file: ", err))
+		return
+	}
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write(s.data)
+	if errClose := gz.Close(); err == nil {
+		err = errClose
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		d.println(red("could not write snapshot: ", err))
+		os.Remove(tmpName)
+		return
+	}
+	if err = os.Rename(tmpName, snapshotFileName); err != nil {
+		d.println(red("could not replace old snapshot: ", err))
+		return
+	}
+	d.println(teal("Snapshot with ", s.countPeers, " peers, ", s.countOrigins, " origin ASes and ", s.countAnnouncements, " announcements written in ", time.Since(s.start)))
+}
+
+// RestoreSnapshot restores the state from a snapshot file. It has to be called before any message is inserted
+func (d *Detector) RestoreSnapshot(restoreFileName string) error {
+	d.lock.Lock()
+	defer d.unlock()
+	start := time.Now()
+	f, err := os.Open(restoreFileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	r := &snapshotReader{r: bufio.NewReader(gz)}
+
+	magic := make([]byte, len(snapshotMagic))
+	r.read(magic)
+	if r.err != nil || string(magic) != snapshotMagic {
+		return errors.New("not a snapshot file of hijackdetector")
+	}
+	version := r.uint16()
+	if version < oldestSnapshotVersion || version > snapshotVersion {
+		return fmt.Errorf("snapshot version %d not supported (only %d to %d)", version, oldestSnapshotVersion, snapshotVersion)
+	}
+	taken := time.Unix(r.int64(), 0)
+	countAnnouncements := d.readState(r, version)
+	if r.err != nil {
+		return r.err
+	}
+	d.println(green("Restored snapshot from ", taken, " with ", len(d.peermapByID), " peers, ", len(d.originCounters), " origin ASes, ", countAnnouncements, " announcements and ",
+		len(d.activeConflicts), " conflicts in ", time.Since(start)))
+	return nil
+}
+
+func (d *Detector) writeState(w *snapshotWriter) int {
+	w.write([]byte(snapshotMagic))
+	w.uint16(snapshotVersion)
+	w.int64(time.Now().Unix())
+
+	//peers
+	peerIDs := make([]uint16, 0, len(d.peermapByID))
+	for id := range d.peermapByID {
+		peerIDs = append(peerIDs, id)
+	}
+	sort.Slice(peerIDs, func(i, j int) bool { return peerIDs[i] < peerIDs[j] })
+	w.uint32(uint32(len(peerIDs)))
+	w.uint32(d.highestPeerId)
+	for _, id := range peerIDs {
+		p := d.peermapByID[id]
+		w.uint16(p.id)
+		w.string(p.ip)
+		w.uint32(p.as)
+		w.string(p.collector)
+	}
+
+	//origin counters
+	w.uint32(uint32(len(d.originCounters)))
+	for _, c := range d.originCounters {
+		w.uint32(c.asn)
+		w.uint32(c.counterLessSpecific)
+		w.uint32(c.counterMoreSpecific)
+		w.uint32(c.counterSameSubnet)
+		w.uint32(c.topographicallyRelated)
+		w.uint32(c.rpkiInvalid)
+		info := d.asInfos[c.asn]
+		w.string(info.country)
+		w.string(info.registry)
+		w.string(info.org)
+	}
+
+	//active announcements by peer
+	countAnnouncements := 0
+	w.uint32(uint32(len(d.routesByPeer)))
+	for peerID, nodes := range d.routesByPeer {
+		announcements := make([]message, 0, len(nodes))
+		for node := range nodes {
+			for _, a := range node.activeAnnouncments {
+				if a.peerID == peerID {
+					announcements = append(announcements, a)
+				}
+			}
+		}
+		w.uint16(peerID)
+		w.uint32(uint32(len(announcements)))
+		for _, a := range announcements {
+			w.prefix(a.prefix)
+			w.uint32(a.origin)
+			w.bool(a.originIsASSet)
+			w.uint32(a.timestamp)
+			w.uint32(a.pathID)
+			w.uint16(uint16(len(a.aspath)))
+			for _, as := range a.aspath {
+				w.uint32(as)
+			}
+		}
+		countAnnouncements = countAnnouncements + len(announcements)
+	}
+
+	//conflicts
+	w.uint32(uint32(len(d.activeConflicts)))
+	for _, record := range d.activeConflicts {
+		for _, side := range []conflictSide{record.key.sideA, record.key.sideB} {
+			w.prefix(side.prefix)
+			w.uint32(side.origin)
+		}
+		w.string(record.id)
+		w.uint32(record.firstSeen)
+		w.uint32(record.lastSeen)
+		w.peerSet(record.peersA)
+		w.peerSet(record.peersB)
+		w.string(record.tag)
+		w.bool(record.rpkiInvalid)
+	}
+	return countAnnouncements
+}
+
+func (d *Detector) readState(r *snapshotReader, version uint16) int {
+	//peers
+	countPeers := r.uint32()
+	d.highestPeerId = r.uint32()
+	d.ourPeers = make([]peer, 0, countPeers)
+	for i := uint32(0); i < countPeers && r.err == nil; i++ {
+		p := peer{id: r.uint16(), ip: r.string(), as: r.uint32()}
+		if version >= 2 {
+			p.collector = r.string()
+		}
+		d.ourPeers = append(d.ourPeers, p)
+	}
+	for i := range d.ourPeers {
+		d.peermapByID[d.ourPeers[i].id] = &d.ourPeers[i]
+		d.peermapByIP[peerKey(d.ourPeers[i].ip, d.ourPeers[i].collector)] = &d.ourPeers[i]
+	}
+	d.highestPeerId100 = d.highestPeerId/100 + 1
+
+	//origin counters
+	countCounters := r.uint32()
+	for i := uint32(0); i < countCounters && r.err == nil; i++ {
+		c := originCounter{
+			asn:                    r.uint32(),
+			counterLessSpecific:    r.uint32(),
+			counterMoreSpecific:    r.uint32(),
+			counterSameSubnet:      r.uint32(),
+			topographicallyRelated: r.uint32(),
+			rpkiInvalid:            r.uint32(),
+		}
+		info := asInfo{country: r.string(), registry: r.string(), org: r.string()}
+		if info.org == "-" { //older snapshots contain "-" for unknown fields
+			info = asInfo{}
+		}
+		d.addASInfo(c.asn, info)
+		d.originCounters[c.asn] = &c
+	}
+
+	//active announcements
+	countAnnouncements := 0
+	countPeersWithAnnouncements := r.uint32()
+	for i := uint32(0); i < countPeersWithAnnouncements && r.err == nil; i++ {
+		peerID := r.uint16()
+		count := r.uint32()
+		for j := uint32(0); j < count && r.err == nil; j++ {
+			var m message
+			m.peerID = peerID
+			m.isAnnouncement = true
+			m.prefix = r.prefix()
+			m.origin = r.uint32()
+			m.originIsASSet = r.bool()
+			m.timestamp = r.uint32()
+			if version >= 3 {
+				m.pathID = r.uint32()
+			}
+			m.aspath = make([]uint32, r.uint16())
+			for k := range m.aspath {
+				m.aspath[k] = r.uint32()
+			}
+			if r.err == nil && m.prefix.length > 0 {
+				m.rpki = d.validateOrigin(m)
+				d.rootFor(m.prefix).insert(d, m)
+				countAnnouncements++
+			}
+		}
+	}
+
+	//conflicts
+	if version < 4 {
+		return countAnnouncements
+	}
+	countConflicts := r.uint32()
+	for i := uint32(0); i < countConflicts && r.err == nil; i++ {
+		var key conflictKey
+		key.sideA = conflictSide{prefix: r.prefix(), origin: r.uint32()}
+		key.sideB = conflictSide{prefix: r.prefix(), origin: r.uint32()}
+		record := &conflictRecord{
+			key:         key,
+			id:          r.string(),
+			firstSeen:   r.uint32(),
+			lastSeen:    r.uint32(),
+			peersA:      r.peerSet(),
+			peersB:      r.peerSet(),
+			tag:         r.string(),
+			rpkiInvalid: r.bool(),
+		}
+		if r.err == nil {
+			d.activeConflicts[key] = record
+			d.addToSideIndex(key.sideA, key)
+			d.addToSideIndex(key.sideB, key)
+		}
+	}
+	return countAnnouncements
+}
+
+// snapshotWriter remembers the first error, so that not every single write has to be checked
+type snapshotWriter struct {
+	w   *bufio.Writer
+	err error
+	buf [8]byte
+}
+
+func (w *snapshotWriter) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+func (w *snapshotWriter) uint8(v uint8) {
+	w.buf[0] = v
+	w.write(w.buf[:1])
+}
+
+func (w *snapshotWriter) bool(v bool) {
+	if v {
+		w.uint8(1)
+	} else {
+		w.uint8(0)
+	}
+}
+
+func (w *snapshotWriter) uint16(v uint16) {
+	binary.BigEndian.PutUint16(w.buf[:2], v)
+	w.write(w.buf[:2])
+}
+
+func (w *snapshotWriter) uint32(v uint32) {
+	binary.BigEndian.PutUint32(w.buf[:4], v)
+	w.write(w.buf[:4])
+}
+
+func (w *snapshotWriter) uint64(v uint64) {
+	binary.BigEndian.PutUint64(w.buf[:8], v)
+	w.write(w.buf[:8])
+}
+
+func (w *snapshotWriter) int64(v int64) {
+	w.uint64(uint64(v))
+}
+
+func (w *snapshotWriter) prefix(p prefix) {
+	w.bool(p.isIPv4)
+	w.uint64(p.hi)
+	w.uint64(p.lo)
+	w.uint8(p.length)
+}
+
+// peerSet writes the IDs of a set of peers in ascending order
+func (w *snapshotWriter) peerSet(peers map[uint16]bool) {
+	ids := make([]uint16, 0, len(peers))
+	for id := range peers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	w.uint16(uint16(len(ids)))
+	for _, id := range ids {
+		w.uint16(id)
+	}
+}
+
+func (w *snapshotWriter) string(s string) {
+	if len(s) > 0xFFFF {
+		s = s[:0xFFFF]
+	}
+	w.uint16(uint16(len(s)))
+	w.write([]byte(s))
+}
+
+// snapshotReader remembers the first error. After an error all reads return zero values
+type snapshotReader struct {
+	r   *bufio.Reader
+	err error
+	buf [8]byte
+}
+
+func (r *snapshotReader) read(b []byte) {
+	if r.err != nil {
+		for i := range b {
+			b[i] = 0
+		}
+		return
+	}
+	_, r.err = io.ReadFull(r.r, b)
+	if r.err == io.EOF || r.err == io.ErrUnexpectedEOF {
+		r.err = errors.New("snapshot file is truncated")
+	}
+}
+
+func (r *snapshotReader) uint8() uint8 {
+	r.read(r.buf[:1])
+	return r.buf[0]
+}
+
+func (r *snapshotReader) bool() bool {
+	return r.uint8() != 0
+}
+
+func (r *snapshotReader) uint16() uint16 {
+	r.read(r.buf[:2])
+	return binary.BigEndian.Uint16(r.buf[:2])
+}
+
+func (r *snapshotReader) uint32() uint32 {
+	r.read(r.buf[:4])
+	return binary.BigEndian.Uint32(r.buf[:4])
+}
+
+func (r *snapshotReader) uint64() uint64 {
+	r.read(r.buf[:8])
+	return binary.BigEndian.Uint64(r.buf[:8])
+}
+
+func (r *snapshotReader) int64() int64 {
+	return int64(r.uint64())
+}
+
+func (r *snapshotReader) prefix() prefix {
+	return prefix{isIPv4: r.bool(), hi: r.uint64(), lo: r.uint64(), length: r.uint8()}
+}
+
+func (r *snapshotReader) peerSet() map[uint16]bool {
+	peers := make(map[uint16]bool)
+	count := r.uint16()
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		peers[r.uint16()] = true
+	}
+	return peers
+}
+
+func (r *snapshotReader) string() string {
+	b := make([]byte, r.uint16())
+	r.read(b)
+	return string(b)
+}
@@ -0,0 +1,123 @@
+package detector
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestSnapshot writes a snapshot with one peer and one announcement in the format of the given version
+func writeTestSnapshot(t *testing.T, fileName string, version uint16) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	w := &snapshotWriter{w: bufio.NewWriter(gz)}
+	w.write([]byte(snapshotMagic))
+	w.uint16(version)
+	w.int64(1600000000)
+	w.uint32(1) //peers
+	w.uint32(1)
+	w.uint16(0)
+	w.string("192.0.2.1")
+	w.uint32(64496)
+	if version >= 2 {
+		w.string("rrc00")
+	}
+	w.uint32(0) //origin counters
+	w.uint32(1) //peers with announcements
+	w.uint16(0)
+	w.uint32(1)
+	p := convertIPtoPrefix(mustParseCIDR(t, "2001:db8::/32"))
+	w.bool(p.isIPv4)
+	w.uint64(p.hi)
+	w.uint64(p.lo)
+	w.uint8(p.length)
+	w.uint32(64500)
+	w.bool(false)
+	w.uint32(1600000000)
+	if version >= 3 {
+		w.uint32(7)
+	}
+	w.uint16(2)
+	w.uint32(64496)
+	w.uint32(64500)
+	if version >= 4 {
+		w.uint32(0) //conflicts
+	}
+	if err := w.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreSnapshotVersions(t *testing.T) {
+	dir := filepath.Dir(writeTestFile(t, "placeholder", ""))
+	for version := oldestSnapshotVersion; version <= snapshotVersion; version++ {
+		fileName := filepath.Join(dir, "state.snap")
+		writeTestSnapshot(t, fileName, version)
+		d := newTestDetector(Options{})
+		if err := d.RestoreSnapshot(fileName); err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		announcements := d.Announcements(mustParseCIDR(t, "2001:db8::/32"))
+		if len(announcements) != 1 || announcements[0].OriginAS != 64500 {
+			t.Fatalf("version %d: announcements = %+v", version, announcements)
+		}
+		wantCollector, wantPathID := "", 0
+		if version >= 2 {
+			wantCollector = "rrc00"
+		}
+		if version >= 3 {
+			wantPathID = 7
+		}
+		if announcements[0].Peers[0].Collector != wantCollector || announcements[0].PathID != wantPathID {
+			t.Errorf("version %d: collector %q and path ID %d, want %q and %d", version, announcements[0].Peers[0].Collector, announcements[0].PathID, wantCollector, wantPathID)
+		}
+	}
+
+	fileName := filepath.Join(dir, "future.snap")
+	writeTestSnapshot(t, fileName, snapshotVersion+1)
+	err := newTestDetector(Options{}).RestoreSnapshot(fileName)
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("a newer version has to be rejected, got %v", err)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	fileName := filepath.Join(filepath.Dir(writeTestFile(t, "placeholder", "")), "state.snap")
+	d := newTestDetector(Options{SnapshotFile: fileName})
+	announceAll(t, d, []testAnnouncement{{"10.0.0.0/16", 64500}, {"2001:db8::/32", 64502}}, false)
+	announceAll(t, d, []testAnnouncement{{"10.0.1.0/24", 64501}, {"10.0.1.0/24", 64501}}, true)
+	d.WriteSnapshot()
+
+	var ended []ClosedConflictJSON
+	restored := newTestDetector(Options{OnConflictEnded: func(c ClosedConflictJSON) { ended = append(ended, c) }})
+	if err := restored.RestoreSnapshot(fileName); err != nil {
+		t.Fatal(err)
+	}
+	before, after := d.Statistics(), restored.Statistics()
+	if after.ActiveAnnouncements != before.ActiveAnnouncements || after.Peers != before.Peers || after.ActiveConflicts != 1 {
+		t.Errorf("restored %d announcements, %d peers and %d conflicts, want %d, %d and 1",
+			after.ActiveAnnouncements, after.Peers, after.ActiveConflicts, before.ActiveAnnouncements, before.Peers)
+	}
+	want, got := d.ActiveConflicts(nil), restored.ActiveConflicts(nil)
+	if len(got) != 1 || got[0].ID != want[0].ID || got[0].FirstSeen != want[0].FirstSeen || len(got[0].PeersA) != 1 || len(got[0].PeersB) != 2 {
+		t.Fatalf("restored conflicts %+v, want %+v", got, want)
+	}
+
+	//the restored conflict ends with the same ID
+	restored.Withdraw(Withdrawal{Subnet: mustParseCIDR(t, "10.0.0.0/16"), Peer: 0, Timestamp: 1600000001})
+	if len(ended) != 1 || ended[0].ID != want[0].ID {
+		t.Errorf("ended conflicts %+v, want the one with ID %s", ended, want[0].ID)
+	}
+}
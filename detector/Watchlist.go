@@ -0,0 +1,364 @@
+package detector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+The watchlist describes our own address space. Each entry lists a prefix together with what is expected for it:
+	{"prefixes": [
+		{"prefix": "192.0.2.0/24", "origins": [64500], "neighbors": [64510, 64511], "maxLength": 24, "tag": "noc@example.net"},
+		{"prefix": "2001:db8::/32", "origins": ["AS64500"]}
+	]}
+The same structure can be written in YAML (.yaml or .yml). All fields but the prefix are optional. An announcement of the
+prefix or of a more specific subnet is checked against the most specific entry covering it, and an alert is raised for
+  - an origin which is not one of the expected origins
+  - a neighbor (the AS right before the origin) which is not one of the allowed neighbors
+  - a subnet which is more specific than the max length
+even if there is no other announcement it would be in conflict with. For all entries, conflicts are printed immediately
+and raise an alert (reason "conflict"), unless every announcement of the conflict within watched space is as expected.
+Files with another extension contain one prefix (or one ASN, e.g. AS64500) per line without any expectations, as the
+former special prefixes file. The ASNs of the watchlist ("asns") are described in ASNWatchlist.go.
+*/
+
+const alertRepeatInterval = 3600 //in seconds of message time. The same alert is not raised again within this interval
+
+const (
+	alertUnexpectedOrigin   = "unexpected-origin"
+	alertUnexpectedNeighbor = "unexpected-neighbor"
+	alertTooSpecific        = "too-specific"
+	alertConflict           = "conflict" //a conflict involving watched space, which is not as expected
+)
+
+// The severities of the alerts, from the lowest to the highest
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var severityOfReason = map[string]string{
+	alertUnexpectedOrigin:   SeverityCritical,
+	alertUnexpectedNeighbor: SeverityWarning,
+	alertTooSpecific:        SeverityCritical,
+	alertConflict:           SeverityWarning,
+	alertNewAnnouncement:    SeverityInfo,
+	alertInConflict:         SeverityWarning,
+	alertForgedOrigin:       SeverityCritical,
+	alertForgedNeighbor:     SeverityWarning,
+}
+
+type watchEntry struct {
+	prefix    prefix
+	origins   []uint32
+	neighbors []uint32
+	maxLength uint8 //0: any length
+	tag       string
+}
+
+func (e *watchEntry) hasExpectations() bool {
+	return len(e.origins) > 0 || len(e.neighbors) > 0 || e.maxLength > 0
+}
+
+type watchEntryJSON struct {
+	Prefix    string   `json:"prefix"`
+	Origins   []vrpASN `json:"origins"`
+	Neighbors []vrpASN `json:"neighbors"`
+	MaxLength int      `json:"maxLength"`
+	Tag       string   `json:"tag"`
+}
+
+type watchlistJSON struct {
+	Prefixes []watchEntryJSON `json:"prefixes"`
+	ASNs     []watchASNJSON   `json:"asns"`
+}
+
+// alertKey identifies an alert to suppress repetitions, e.g. by every peer that receives the same announcement
+type alertKey struct {
+	prefix   prefix
+	origin   uint32
+	neighbor uint32
+	other    conflictSide //the other announcement of a conflict
+	reason   string
+}
+
+// isRepeatedAlert returns true if the same alert was already raised within the alertRepeatInterval. Otherwise the alert is
+// recorded as raised now
+func (d *Detector) isRepeatedAlert(key alertKey, timestamp uint32) bool {
+	if last, ok := d.alerted[key]; ok && timestamp < last+alertRepeatInterval {
+		return true
+	}
+	d.alerted[key] = timestamp
+	return false
+}
+
+// readWatchlist reads the watchlist file of the options (JSON, YAML or one prefix per line)
+func (d *Detector) readWatchlist() {
+	fileName := d.options.WatchlistFile
+	if fileName == "" {
+		return
+	}
+	exists, _ := exists(fileName)
+	if !exists {
+		d.println(red("File ", fileName, " does not exist. Continuing without watchlist."))
+		return
+	}
+	var err error
+	switch {
+	case strings.HasSuffix(fileName, ".json"), strings.HasSuffix(fileName, ".yaml"), strings.HasSuffix(fileName, ".yml"):
+		err = d.readWatchlistStructured(fileName)
+	default:
+		err = d.readWatchlistPrefixes(fileName)
+	}
+	if err != nil {
+		d.println(red("Could not read watchlist ", fileName, " completely: ", err))
+	}
+	d.println(green("Watchlist entries: ", len(d.watchlist), " prefixes, ", len(d.watchedASNs), " ASNs"))
+}
+
+func (d *Detector) readWatchlistStructured(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(fileName, ".json") { //YAML is converted to JSON, so that both are decoded into the same structs
+		var value interface{}
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		if data, err = json.Marshal(value); err != nil {
+			return err
+		}
+	}
+	var file watchlistJSON
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	for _, e := range file.Prefixes {
+		_, ipnet, err := net.ParseCIDR(e.Prefix)
+		if err != nil {
+			d.println(red("Could not parse watchlist prefix: ", e.Prefix))
+			continue
+		}
+		entry := watchEntry{prefix: convertIPtoPrefix(*ipnet), tag: e.Tag}
+		for _, asn := range e.Origins {
+			entry.origins = append(entry.origins, uint32(asn))
+		}
+		for _, asn := range e.Neighbors {
+			entry.neighbors = append(entry.neighbors, uint32(asn))
+		}
+		if e.MaxLength != 0 {
+			if e.MaxLength < int(entry.prefix.length) || e.MaxLength > int(d.rootFor(entry.prefix).maxDepth) {
+				d.println(red("Invalid max length ", e.MaxLength, " for watchlist prefix ", e.Prefix))
+				continue
+			}
+			entry.maxLength = uint8(e.MaxLength)
+		}
+		d.addWatchEntry(entry)
+	}
+	for _, a := range file.ASNs {
+		d.addWatchedASN(a.toWatchedASN())
+	}
+	return nil
+}
+
+func (d *Detector) readWatchlistPrefixes(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(strings.ToUpper(line), "AS") {
+			asn, err := parseASN(line)
+			if err != nil {
+				d.println(red("Could not parse ASN: ", line))
+				continue
+			}
+			d.addWatchedASN(watchedASN{asn: asn})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(line)
+		if err != nil {
+			d.println(red("Could not parse to subnet: ", line))
+			continue
+		}
+		if length, _ := ipnet.Mask.Size(); length <= 0 {
+			d.println(red("Subnet length must be at least 1: ", line))
+			continue
+		}
+		d.addWatchEntry(watchEntry{prefix: convertIPtoPrefix(*ipnet)})
+	}
+	return scanner.Err()
+}
+
+// addWatchEntry adds an entry and marks its node in the trie (and all more specific ones) as relevant
+func (d *Detector) addWatchEntry(entry watchEntry) {
+	d.watchlist[entry.prefix] = &entry
+	var m message
+	m.prefix = entry.prefix
+	m.isSpecialPrefix = true
+	d.rootFor(m.prefix).insert(d, m)
+	d.println(green("The following prefix was successfully added to the watchlist: ", entry.prefix.toString()))
+}
+
+// watchEntryFor returns the most specific watchlist entry covering the prefix, nil if there is none
+func (d *Detector) watchEntryFor(p prefix) *watchEntry {
+	for length := int(p.length); length >= 0; length-- {
+		if e, ok := d.watchlist[p.masked(uint8(length))]; ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// neighborOfOrigin returns the AS right before the origin in the AS path (ignoring prepending), 0 if there is none
+func neighborOfOrigin(m message) uint32 {
+	for i := len(m.aspath) - 1; i >= 0; i-- {
+		if m.aspath[i] != m.origin {
+			return m.aspath[i]
+		}
+	}
+	return 0
+}
+
+// violations returns the reasons why an announcement does not match its watchlist entry
+func (e *watchEntry) violations(m message) []string {
+	var reasons []string
+	if len(e.origins) > 0 && (m.originIsASSet || !isContainedInUint32(e.origins, m.origin)) {
+		reasons = append(reasons, alertUnexpectedOrigin)
+	}
+	if neighbor := neighborOfOrigin(m); len(e.neighbors) > 0 && neighbor != 0 && !isContainedInUint32(e.neighbors, neighbor) {
+		reasons = append(reasons, alertUnexpectedNeighbor)
+	}
+	if e.maxLength > 0 && m.prefix.length > e.maxLength {
+		reasons = append(reasons, alertTooSpecific)
+	}
+	return reasons
+}
+
+// isExpectedByWatchlist returns true if the announcement lies outside of the watched space or is exactly as expected
+func (d *Detector) isExpectedByWatchlist(m message) bool {
+	e := d.watchEntryFor(m.prefix)
+	return e == nil || (e.hasExpectations() && len(e.violations(m)) == 0)
+}
+
+// watchlistRelevant returns true if at least one announcement of the conflict lies within watched space and is not as expected
+func (d *Detector) watchlistRelevant(c conflicts) bool {
+	if !d.isExpectedByWatchlist(c.referenceAnnouncement) {
+		return true
+	}
+	for _, m := range c.conflictingMessages {
+		if !d.isExpectedByWatchlist(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWatchlist raises an alert for every expectation of the watchlist which the announcement does not fulfil
+func (d *Detector) checkWatchlist(m message) {
+	e := d.watchEntryFor(m.prefix)
+	if e == nil {
+		return
+	}
+	for _, reason := range e.violations(m) {
+		key := alertKey{prefix: m.prefix, origin: m.origin, reason: reason}
+		if reason == alertUnexpectedNeighbor {
+			key.neighbor = neighborOfOrigin(m)
+		}
+		if d.isRepeatedAlert(key, m.timestamp) {
+			continue
+		}
+
+		alert := AlertJSON{
+			Category:      "watchlist",
+			Reason:        reason,
+			Severity:      severityOfReason[reason],
+			Tag:           e.tag,
+			WatchedPrefix: e.prefix.toString(),
+			Announcement:  d.convertMessageForAlert(m),
+		}
+		var details string
+		switch reason {
+		case alertUnexpectedOrigin:
+			alert.Expected = aspathtoIntSlice(e.origins)
+			details = "origin AS " + strconv.Itoa(int(m.origin)) + ", expected " + aspathtoString(e.origins)
+		case alertUnexpectedNeighbor:
+			alert.Expected = aspathtoIntSlice(e.neighbors)
+			details = "neighbor AS " + strconv.Itoa(int(key.neighbor)) + ", allowed " + aspathtoString(e.neighbors)
+		case alertTooSpecific:
+			alert.Expected = []int{int(e.maxLength)}
+			details = "length " + strconv.Itoa(int(m.prefix.length)) + ", max length " + strconv.Itoa(int(e.maxLength))
+		}
+		d.countAlerts++
+		d.pendingAlerts = append(d.pendingAlerts, alert)
+		d.println(magenta(fmt.Sprintf("Watchlist alert [%s] for %s (tag: %s): %s", reason, e.prefix.toString(), orDash(e.tag), details)))
+		d.println(magenta("  ", m.toString()))
+	}
+}
+
+// raiseConflictAlerts raises an alert for every conflict of a relevant announcement which is not allowlisted
+func (d *Detector) raiseConflictAlerts(c conflicts) {
+	ref := c.referenceAnnouncement
+	for i, m := range c.conflictingMessages {
+		if c.allowlistTag(i) != "" {
+			continue
+		}
+		e := d.watchEntryFor(ref.prefix)
+		if d.isExpectedByWatchlist(ref) {
+			e = d.watchEntryFor(m.prefix)
+		}
+		if e == nil {
+			continue
+		}
+		key := alertKey{prefix: ref.prefix, origin: ref.origin, other: conflictSide{prefix: m.prefix, origin: m.origin}, reason: alertConflict}
+		if d.isRepeatedAlert(key, ref.timestamp) {
+			continue
+		}
+		d.countAlerts++
+		d.pendingAlerts = append(d.pendingAlerts, AlertJSON{
+			Category:      "watchlist",
+			Reason:        alertConflict,
+			Severity:      severityOfReason[alertConflict],
+			Tag:           e.tag,
+			WatchedPrefix: e.prefix.toString(),
+			Announcement:  d.convertMessageForAlert(ref),
+			Conflicting:   d.conflictingForAlert(ref, m),
+		})
+	}
+}
+
+// conflictingForAlert converts the other announcement of a conflict for an alert, together with the type and ID of the conflict
+func (d *Detector) conflictingForAlert(ref message, m message) *MessageJSON {
+	other := d.convertMessageForAlert(m)
+	other.ConflictType = classifyConflict(ref, m).toString()
+	key, _ := keyOfConflict(ref, m)
+	if r, ok := d.activeConflicts[key]; ok {
+		other.ConflictID = r.id
+	}
+	return &other
+}
+
+// convertMessageForAlert converts an announcement for an alert, together with all peers which currently carry its subnet
+// with its origin. It has to be called after the announcement was inserted
+func (d *Detector) convertMessageForAlert(m message) MessageJSON {
+	result := d.convertMessageForJSON(m)
+	result.Peers = d.peersCarryingForJSON(conflictSide{prefix: m.prefix, origin: m.origin})
+	result.PeerCount = len(result.Peers)
+	return result
+}
@@ -0,0 +1,162 @@
+package detector
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testWatchlistYAML = `# our address space
+prefixes:
+  - prefix: 192.0.2.0/24
+    origins: [64500]            # expected origin ASes
+    neighbors: [64510, 64511]
+    maxLength: 24
+    tag: "noc@example.net"
+  - prefix: 2001:db8::/32
+    origins:
+      - AS64500
+      - 64501
+    maxLength: 48
+  - prefix: 198.51.100.0/24
+    maxLength: 23               # less specific than the prefix
+  - prefix: 2001:db8:ffff::/48
+    maxLength: 129
+asns:
+  - asn: AS64500
+    neighbors: [64510]
+`
+
+const testWatchlistJSON = `{"prefixes": [
+	{"prefix": "192.0.2.0/24", "origins": [64500], "neighbors": [64510, 64511], "maxLength": 24, "tag": "noc@example.net"},
+	{"prefix": "2001:db8::/32", "origins": ["AS64500", 64501], "maxLength": 48},
+	{"prefix": "198.51.100.0/24", "maxLength": 23},
+	{"prefix": "2001:db8:ffff::/48", "maxLength": 129}
+], "asns": [{"asn": "AS64500", "neighbors": [64510]}]}`
+
+func TestReadWatchlist(t *testing.T) {
+	yamlDetector := newTestDetector(Options{WatchlistFile: writeTestFile(t, "watchlist.yaml", testWatchlistYAML)})
+	jsonDetector := newTestDetector(Options{WatchlistFile: writeTestFile(t, "watchlist.json", testWatchlistJSON)})
+
+	want := map[string]watchEntry{
+		"192.0.2.0/24":  {origins: []uint32{64500}, neighbors: []uint32{64510, 64511}, maxLength: 24, tag: "noc@example.net"},
+		"2001:db8::/32": {origins: []uint32{64500, 64501}, maxLength: 48},
+	}
+	for name, d := range map[string]*Detector{"YAML": yamlDetector, "JSON": jsonDetector} {
+		if len(d.watchlist) != len(want) {
+			t.Errorf("%s: %d entries, want %d (the invalid max lengths have to be skipped)", name, len(d.watchlist), len(want))
+		}
+		for subnet, w := range want {
+			w.prefix = convertIPtoPrefix(mustParseCIDR(t, subnet))
+			e, ok := d.watchlist[w.prefix]
+			if !ok {
+				t.Errorf("%s: no entry for %s", name, subnet)
+				continue
+			}
+			if !reflect.DeepEqual(*e, w) {
+				t.Errorf("%s: entry %+v, want %+v", name, *e, w)
+			}
+		}
+		if w, ok := d.watchedASNs[64500]; !ok || !reflect.DeepEqual(w.neighbors, []uint32{64510}) {
+			t.Errorf("%s: watched ASN 64500 missing or with wrong neighbors", name)
+		}
+	}
+}
+
+func TestReadWatchlistInvalidYAML(t *testing.T) {
+	d := newTestDetector(Options{})
+	if err := d.readWatchlistStructured(writeTestFile(t, "watchlist.yml", "prefixes:\n  - prefix: [192.0.2.0/24\n")); err == nil {
+		t.Error("invalid YAML was accepted")
+	}
+}
+
+func TestWatchlistAlerts(t *testing.T) {
+	tests := []struct {
+		name   string
+		subnet string
+		aspath []uint32
+		want   []string
+	}{
+		{"as expected", "192.0.2.0/24", []uint32{64496, 64510, 64500}, nil},
+		{"prepended origin", "192.0.2.0/24", []uint32{64496, 64511, 64500, 64500}, nil},
+		{"unexpected origin", "192.0.2.0/24", []uint32{64496, 64510, 64502}, []string{alertUnexpectedOrigin}},
+		{"unexpected neighbor", "192.0.2.0/24", []uint32{64496, 64520, 64500}, []string{alertUnexpectedNeighbor}},
+		{"too specific", "192.0.2.0/25", []uint32{64496, 64510, 64500}, []string{alertTooSpecific}},
+		{"everything unexpected", "192.0.2.128/25", []uint32{64496, 64520, 64502}, []string{alertUnexpectedOrigin, alertUnexpectedNeighbor, alertTooSpecific}},
+		{"IPv6 as expected", "2001:db8::/32", []uint32{64496, 64501}, nil},
+		{"IPv6 within max length", "2001:db8:1::/48", []uint32{64496, 64500}, nil},
+		{"IPv6 too specific", "2001:db8:1:1::/64", []uint32{64496, 64500}, []string{alertTooSpecific}},
+		{"IPv6 unexpected origin", "2001:db8:2::/48", []uint32{64496, 64502}, []string{alertUnexpectedOrigin}},
+		{"IPv6 without neighbor expectations", "2001:db8::/32", []uint32{64520, 64500}, nil},
+		{"outside of the watchlist", "203.0.113.0/24", []uint32{64496, 64502}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reasons []string
+			d := newTestDetector(Options{
+				WatchlistFile: writeTestFile(t, "watchlist.yaml", testWatchlistYAML),
+				OnAlert: func(a AlertJSON) {
+					if a.Category == "watchlist" {
+						reasons = append(reasons, a.Reason)
+					}
+				},
+			})
+			d.Announce(Announcement{
+				Subnet:    mustParseCIDR(t, tt.subnet),
+				Peer:      d.Peer("192.0.2.1", 64496, "rrc00"),
+				Timestamp: 1600000000,
+				Origin:    tt.aspath[len(tt.aspath)-1],
+				ASPath:    tt.aspath,
+			}, true)
+			if !reflect.DeepEqual(reasons, tt.want) {
+				t.Errorf("alerts %v, want %v", reasons, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchlistAlertPeers(t *testing.T) {
+	var alerts []AlertJSON
+	d := newTestDetector(Options{
+		WatchlistFile: writeTestFile(t, "watchlist.yaml", testWatchlistYAML),
+		OnAlert:       func(a AlertJSON) { alerts = append(alerts, a) },
+	})
+	announce := func(peerIP string, subnet string, origin uint32) {
+		d.Announce(Announcement{
+			Subnet:    mustParseCIDR(t, subnet),
+			Peer:      d.Peer(peerIP, 64496, "rrc00"),
+			Timestamp: 1600000000,
+			Origin:    origin,
+			ASPath:    []uint32{64496, 64510, origin},
+		}, true)
+	}
+	announce("192.0.2.1", "192.0.2.0/24", 64500)
+	announce("192.0.2.2", "192.0.2.0/24", 64500)
+	announce("192.0.2.3", "192.0.2.0/25", 64502)
+
+	peerIPs := func(peers []PeerJSON) []string {
+		var result []string
+		for _, p := range peers {
+			result = append(result, p.IP)
+		}
+		return result
+	}
+	reasons := make(map[string]bool)
+	for _, a := range alerts {
+		if a.Category != "watchlist" {
+			continue
+		}
+		reasons[a.Reason] = true
+		if a.Announcement.PeerCount != 1 || !reflect.DeepEqual(peerIPs(a.Announcement.Peers), []string{"192.0.2.3"}) {
+			t.Errorf("%s: announcement carried by %d peers %v, want only 192.0.2.3", a.Reason, a.Announcement.PeerCount, a.Announcement.Peers)
+		}
+		if a.Reason != alertConflict {
+			continue
+		}
+		if a.Conflicting == nil || a.Conflicting.PeerCount != 2 || !reflect.DeepEqual(peerIPs(a.Conflicting.Peers), []string{"192.0.2.1", "192.0.2.2"}) {
+			t.Errorf("conflicting announcement %+v, want it carried by 192.0.2.1 and 192.0.2.2", a.Conflicting)
+		}
+	}
+	if !reasons[alertUnexpectedOrigin] || !reasons[alertConflict] {
+		t.Errorf("alerts %v, want at least %s and %s", reasons, alertUnexpectedOrigin, alertConflict)
+	}
+}
@@ -4,6 +4,8 @@ go 1.14
 
 require (
 	github.com/ammario/ipisp/v2 v2.0.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/osrg/gobgp v2.0.0+incompatible
 	github.com/stretchr/testify v1.8.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )